@@ -0,0 +1,571 @@
+// Package backend provides storages for farm state files
+package backend
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/req"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// List of supported backend types
+const (
+	TYPE_LOCAL = "local"
+	TYPE_S3    = "s3"
+	TYPE_HTTP  = "http"
+)
+
+// LOCK_NAME is name of lock object
+const LOCK_NAME = "state.lock"
+
+// LOCAL_LOCK_NAME is name of lock file used by local backend
+const LOCAL_LOCK_NAME = ".state.lock"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ErrNotExist is returned if state file doesn't exist in storage
+var ErrNotExist = errors.New("State file does not exist")
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Backend is state files storage
+type Backend interface {
+	// Name return backend name
+	Name() string
+
+	// Read return content of state file
+	Read(name string) ([]byte, error)
+
+	// Write save content of state file
+	Write(name string, data []byte) error
+
+	// Delete remove state file
+	Delete(name string) error
+
+	// Lock acquire state lock
+	Lock(info *LockInfo) error
+
+	// Unlock release state lock with given ID
+	Unlock(id string) error
+}
+
+// LockInfo contains info about state lock owner
+type LockInfo struct {
+	ID      string `json:"id"`
+	Owner   string `json:"owner"`
+	Command string `json:"command"`
+	Pid     int    `json:"pid"`
+	Created int64  `json:"created"`
+}
+
+// LockError is returned if state already locked by someone else
+type LockError struct {
+	Info *LockInfo
+}
+
+// LocalBackend is backend which stores state files in local directory, lock
+// is advisory lock on lock file, so it is released automatically when
+// process exits
+type LocalBackend struct {
+	Dir string
+
+	lockFd *os.File
+	lockID string
+}
+
+// S3Backend is backend which stores state files in S3-compatible
+// object storage
+type S3Backend struct {
+	URL       string // Bucket URL with optional prefix (path-style)
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// HTTPBackend is backend which stores state files on HTTP server
+type HTTPBackend struct {
+	URL      string
+	User     string
+	Password string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewLockInfo create new lock info for given command
+func NewLockInfo(command string) *LockInfo {
+	hostname, _ := os.Hostname()
+	id := make([]byte, 8)
+
+	rand.Read(id)
+
+	return &LockInfo{
+		ID:      hex.EncodeToString(id),
+		Owner:   os.Getenv("USER") + "@" + hostname,
+		Command: command,
+		Pid:     os.Getpid(),
+		Created: time.Now().Unix(),
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Error return error message
+func (e *LockError) Error() string {
+	if e.Info == nil {
+		return "State is locked"
+	}
+
+	return fmt.Sprintf(
+		"State is locked by %s for %s (command: %s, pid: %d, lock ID: %s)",
+		e.Info.Owner, timeutil.PrettyDuration(time.Since(time.Unix(e.Info.Created, 0))),
+		e.Info.Command, e.Info.Pid, e.Info.ID,
+	)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Name return backend name
+func (b *LocalBackend) Name() string {
+	return TYPE_LOCAL
+}
+
+// Read return content of state file
+func (b *LocalBackend) Read(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(b.Dir, name))
+
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+
+	return data, err
+}
+
+// Write save content of state file
+func (b *LocalBackend) Write(name string, data []byte) error {
+	file := filepath.Join(b.Dir, name)
+
+	// Write to temporary file and rename it for atomic update
+	err := ioutil.WriteFile(file+".tmp", data, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(file+".tmp", file)
+
+	if err != nil {
+		os.Remove(file + ".tmp")
+	}
+
+	return err
+}
+
+// Delete remove state file
+func (b *LocalBackend) Delete(name string) error {
+	err := os.Remove(filepath.Join(b.Dir, name))
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Lock acquire state lock
+func (b *LocalBackend) Lock(info *LockInfo) error {
+	fd, err := os.OpenFile(filepath.Join(b.Dir, LOCAL_LOCK_NAME), os.O_CREATE|os.O_RDWR, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+
+	if err != nil {
+		fd.Close()
+
+		if err == syscall.EWOULDBLOCK {
+			return &LockError{readLockInfo(b.Read(LOCAL_LOCK_NAME))}
+		}
+
+		return err
+	}
+
+	data, _ := json.Marshal(info)
+
+	fd.Truncate(0)
+	fd.WriteAt(data, 0)
+
+	b.lockFd, b.lockID = fd, info.ID
+
+	return nil
+}
+
+// Unlock release state lock with given ID, lock held by another process
+// can't be released
+func (b *LocalBackend) Unlock(id string) error {
+	if b.lockFd == nil || b.lockID != id {
+		return b.CheckLock()
+	}
+
+	b.lockFd.Truncate(0)
+	syscall.Flock(int(b.lockFd.Fd()), syscall.LOCK_UN)
+	b.lockFd.Close()
+
+	b.lockFd, b.lockID = nil, ""
+
+	return nil
+}
+
+// CheckLock return LockError if state is locked by another process
+func (b *LocalBackend) CheckLock() error {
+	fd, err := os.OpenFile(filepath.Join(b.Dir, LOCAL_LOCK_NAME), os.O_RDWR, 0600)
+
+	if err != nil {
+		return nil
+	}
+
+	defer fd.Close()
+
+	err = syscall.Flock(int(fd.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+
+	if err != nil {
+		return &LockError{readLockInfo(b.Read(LOCAL_LOCK_NAME))}
+	}
+
+	syscall.Flock(int(fd.Fd()), syscall.LOCK_UN)
+
+	return nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Name return backend name
+func (b *S3Backend) Name() string {
+	return TYPE_S3
+}
+
+// Read return content of state file
+func (b *S3Backend) Read(name string) ([]byte, error) {
+	resp, err := b.do(req.GET, name, nil, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return resp.Bytes(), nil
+	case 404:
+		resp.Discard()
+		return nil, ErrNotExist
+	}
+
+	resp.Discard()
+
+	return nil, fmt.Errorf("Storage returned status code %d", resp.StatusCode)
+}
+
+// Write save content of state file
+func (b *S3Backend) Write(name string, data []byte) error {
+	return b.put(name, data, nil)
+}
+
+// Delete remove state file
+func (b *S3Backend) Delete(name string) error {
+	resp, err := b.do(req.DELETE, name, nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Discard()
+
+	if resp.StatusCode != 204 && resp.StatusCode != 200 && resp.StatusCode != 404 {
+		return fmt.Errorf("Storage returned status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Lock acquire state lock
+func (b *S3Backend) Lock(info *LockInfo) error {
+	data, _ := json.Marshal(info)
+
+	// Conditional write fails if lock object already exists
+	err := b.put(LOCK_NAME, data, req.Headers{"If-None-Match": "*"})
+
+	if err == errPreconditionFailed {
+		return &LockError{readLockInfo(b.Read(LOCK_NAME))}
+	}
+
+	return err
+}
+
+// Unlock release state lock with given ID
+func (b *S3Backend) Unlock(id string) error {
+	info := readLockInfo(b.Read(LOCK_NAME))
+
+	if info != nil && info.ID != id {
+		return &LockError{info}
+	}
+
+	return b.Delete(LOCK_NAME)
+}
+
+// put upload object to storage
+func (b *S3Backend) put(name string, data []byte, headers req.Headers) error {
+	resp, err := b.do(req.PUT, name, data, headers)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Discard()
+
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 409, 412:
+		return errPreconditionFailed
+	}
+
+	return fmt.Errorf("Storage returned status code %d", resp.StatusCode)
+}
+
+// do send signed request to storage
+func (b *S3Backend) do(method, name string, data []byte, headers req.Headers) (*req.Response, error) {
+	objectURL, err := url.Parse(strings.TrimRight(b.URL, "/") + "/" + name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if headers == nil {
+		headers = req.Headers{}
+	}
+
+	signS3Request(b, method, objectURL, data, headers, time.Now().UTC())
+
+	request := req.Request{
+		Method:  method,
+		URL:     objectURL.String(),
+		Headers: headers,
+	}
+
+	if data != nil {
+		request.Body = data
+	}
+
+	return request.Do()
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Name return backend name
+func (b *HTTPBackend) Name() string {
+	return TYPE_HTTP
+}
+
+// Read return content of state file
+func (b *HTTPBackend) Read(name string) ([]byte, error) {
+	resp, err := b.do(req.GET, name, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return resp.Bytes(), nil
+	case 404:
+		resp.Discard()
+		return nil, ErrNotExist
+	}
+
+	resp.Discard()
+
+	return nil, fmt.Errorf("Server returned status code %d", resp.StatusCode)
+}
+
+// Write save content of state file
+func (b *HTTPBackend) Write(name string, data []byte) error {
+	return b.send(req.PUT, name, data)
+}
+
+// Delete remove state file
+func (b *HTTPBackend) Delete(name string) error {
+	resp, err := b.do(req.DELETE, name, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Discard()
+
+	if resp.StatusCode >= 300 && resp.StatusCode != 404 {
+		return fmt.Errorf("Server returned status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Lock acquire state lock
+func (b *HTTPBackend) Lock(info *LockInfo) error {
+	data, _ := json.Marshal(info)
+
+	return b.send("LOCK", LOCK_NAME, data)
+}
+
+// Unlock release state lock with given ID
+func (b *HTTPBackend) Unlock(id string) error {
+	data, _ := json.Marshal(&LockInfo{ID: id})
+
+	return b.send("UNLOCK", LOCK_NAME, data)
+}
+
+// send send request with body to server
+func (b *HTTPBackend) send(method, name string, data []byte) error {
+	resp, err := b.do(method, name, data)
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == 409 || resp.StatusCode == 423:
+		info := &LockInfo{}
+
+		if resp.JSON(info) != nil || info.ID == "" {
+			info = nil
+		}
+
+		return &LockError{info}
+
+	case resp.StatusCode >= 300:
+		resp.Discard()
+		return fmt.Errorf("Server returned status code %d", resp.StatusCode)
+	}
+
+	resp.Discard()
+
+	return nil
+}
+
+// do send request to server
+func (b *HTTPBackend) do(method, name string, data []byte) (*req.Response, error) {
+	request := req.Request{
+		Method:            method,
+		URL:               strings.TrimRight(b.URL, "/") + "/" + name,
+		BasicAuthUsername: b.User,
+		BasicAuthPassword: b.Password,
+	}
+
+	if data != nil {
+		request.Body = data
+		request.ContentType = req.CONTENT_TYPE_JSON
+	}
+
+	return request.Do()
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// errPreconditionFailed is returned if conditional write failed
+var errPreconditionFailed = errors.New("Precondition failed")
+
+// readLockInfo decode lock info
+func readLockInfo(data []byte, err error) *LockInfo {
+	if err != nil {
+		return nil
+	}
+
+	info := &LockInfo{}
+
+	if json.Unmarshal(data, info) != nil {
+		return nil
+	}
+
+	return info
+}
+
+// signS3Request add AWS Signature Version 4 headers to request
+func signS3Request(b *S3Backend, method string, u *url.URL, data []byte, headers req.Headers, now time.Time) {
+	date := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(data)
+
+	headers["X-Amz-Date"] = timestamp
+	headers["X-Amz-Content-Sha256"] = payloadHash
+
+	// Canonical headers must be sorted by name
+	signedHeaders := []string{"host"}
+	canonicalHeaders := "host:" + u.Host + "\n"
+
+	if headers["If-None-Match"] != "" {
+		signedHeaders = append(signedHeaders, "if-none-match")
+		canonicalHeaders += "if-none-match:" + headers["If-None-Match"] + "\n"
+	}
+
+	signedHeaders = append(signedHeaders, "x-amz-content-sha256", "x-amz-date")
+	canonicalHeaders += "x-amz-content-sha256:" + payloadHash + "\n"
+	canonicalHeaders += "x-amz-date:" + timestamp + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+b.SecretKey), date)
+	key = hmacSHA256(key, b.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	headers["Authorization"] = fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.AccessKey, scope, strings.Join(signedHeaders, ";"),
+		hex.EncodeToString(hmacSHA256(key, stringToSign)),
+	)
+}
+
+// sha256Hex return hex-encoded SHA-256 hash of data
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// hmacSHA256 return HMAC-SHA256 of data
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
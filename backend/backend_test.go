@@ -0,0 +1,348 @@
+package backend
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// fakeStateServer is HTTP state server which stores files in memory
+type fakeStateServer struct {
+	sync.Mutex
+
+	files map[string][]byte
+	lock  *LockInfo
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func TestHTTPBackendReadWriteDelete(t *testing.T) {
+	b, server := newTestHTTPBackend()
+	defer server.Close()
+
+	_, err := b.Read("farm.json")
+
+	if err != ErrNotExist {
+		t.Fatalf("Read of missing file must return ErrNotExist, got %v", err)
+	}
+
+	err = b.Write("farm.json", []byte(`{"ttl":60}`))
+
+	if err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	data, err := b.Read("farm.json")
+
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+
+	if string(data) != `{"ttl":60}` {
+		t.Fatalf("Read returned unexpected data: %s", data)
+	}
+
+	err = b.Delete("farm.json")
+
+	if err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+
+	_, err = b.Read("farm.json")
+
+	if err != ErrNotExist {
+		t.Fatalf("Read of deleted file must return ErrNotExist, got %v", err)
+	}
+
+	err = b.Delete("farm.json")
+
+	if err != nil {
+		t.Fatalf("Delete of missing file returned error: %v", err)
+	}
+}
+
+func TestHTTPBackendLock(t *testing.T) {
+	b, server := newTestHTTPBackend()
+	defer server.Close()
+
+	first := NewLockInfo("create")
+	second := NewLockInfo("destroy")
+
+	err := b.Lock(first)
+
+	if err != nil {
+		t.Fatalf("Lock returned error: %v", err)
+	}
+
+	err = b.Lock(second)
+
+	lockErr, ok := err.(*LockError)
+
+	if !ok {
+		t.Fatalf("Lock of locked state must return LockError, got %v", err)
+	}
+
+	if lockErr.Info == nil || lockErr.Info.ID != first.ID || lockErr.Info.Command != "create" {
+		t.Fatalf("LockError must contain info about lock holder, got %+v", lockErr.Info)
+	}
+
+	err = b.Unlock(second.ID)
+
+	if _, ok = err.(*LockError); !ok {
+		t.Fatalf("Unlock with wrong ID must return LockError, got %v", err)
+	}
+
+	err = b.Unlock(first.ID)
+
+	if err != nil {
+		t.Fatalf("Unlock returned error: %v", err)
+	}
+
+	err = b.Lock(second)
+
+	if err != nil {
+		t.Fatalf("Lock of released state returned error: %v", err)
+	}
+}
+
+func TestHTTPBackendLockWithoutInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+
+	defer server.Close()
+
+	b := &HTTPBackend{URL: server.URL}
+
+	err := b.Lock(NewLockInfo("create"))
+
+	lockErr, ok := err.(*LockError)
+
+	if !ok {
+		t.Fatalf("Lock must return LockError for 409 status, got %v", err)
+	}
+
+	if lockErr.Info != nil {
+		t.Fatalf("LockError must not contain info if server doesn't return it, got %+v", lockErr.Info)
+	}
+
+	if lockErr.Error() != "State is locked" {
+		t.Fatalf("Unexpected error message: %s", lockErr.Error())
+	}
+}
+
+func TestHTTPBackendErrors(t *testing.T) {
+	b, server := newTestHTTPBackend()
+	defer server.Close()
+
+	b.Password = "wrong"
+
+	_, err := b.Read("farm.json")
+
+	if err == nil || err == ErrNotExist {
+		t.Fatalf("Read with wrong credentials must return error, got %v", err)
+	}
+
+	err = b.Lock(NewLockInfo("create"))
+
+	if _, ok := err.(*LockError); ok || err == nil {
+		t.Fatalf("Lock with wrong credentials must return status error, got %v", err)
+	}
+}
+
+func TestLocalBackendReadWriteDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terrafarm")
+
+	if err != nil {
+		t.Fatalf("Can't create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var b Backend = &LocalBackend{Dir: dir}
+
+	_, err = b.Read("farm.json")
+
+	if err != ErrNotExist {
+		t.Fatalf("Read of missing file must return ErrNotExist, got %v", err)
+	}
+
+	err = b.Write("farm.json", []byte(`{"ttl":60}`))
+
+	if err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	data, err := b.Read("farm.json")
+
+	if err != nil || string(data) != `{"ttl":60}` {
+		t.Fatalf("Read returned unexpected data: %s (%v)", data, err)
+	}
+
+	err = b.Delete("farm.json")
+
+	if err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+
+	err = b.Delete("farm.json")
+
+	if err != nil {
+		t.Fatalf("Delete of missing file returned error: %v", err)
+	}
+}
+
+func TestLocalBackendLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terrafarm")
+
+	if err != nil {
+		t.Fatalf("Can't create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	first := &LocalBackend{Dir: dir}
+	second := &LocalBackend{Dir: dir}
+
+	firstInfo := NewLockInfo("create")
+	secondInfo := NewLockInfo("destroy")
+
+	err = first.Lock(firstInfo)
+
+	if err != nil {
+		t.Fatalf("Lock returned error: %v", err)
+	}
+
+	err = second.Lock(secondInfo)
+
+	lockErr, ok := err.(*LockError)
+
+	if !ok {
+		t.Fatalf("Lock of locked state must return LockError, got %v", err)
+	}
+
+	if lockErr.Info == nil || lockErr.Info.ID != firstInfo.ID {
+		t.Fatalf("LockError must contain info about lock holder, got %+v", lockErr.Info)
+	}
+
+	if _, ok = second.CheckLock().(*LockError); !ok {
+		t.Fatal("CheckLock must return LockError for state locked by another process")
+	}
+
+	if _, ok = second.Unlock(firstInfo.ID).(*LockError); !ok {
+		t.Fatal("Lock held by another process must not be released")
+	}
+
+	err = first.Unlock(firstInfo.ID)
+
+	if err != nil {
+		t.Fatalf("Unlock returned error: %v", err)
+	}
+
+	if second.CheckLock() != nil {
+		t.Fatal("CheckLock must return nil for released lock")
+	}
+
+	err = second.Lock(secondInfo)
+
+	if err != nil {
+		t.Fatalf("Lock of released state returned error: %v", err)
+	}
+
+	second.Unlock(secondInfo.ID)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// newTestHTTPBackend create HTTP backend connected to fake state server
+func newTestHTTPBackend() (*HTTPBackend, *httptest.Server) {
+	fake := &fakeStateServer{files: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+
+	return &HTTPBackend{URL: server.URL + "/state/", User: "john", Password: "test1234"}, server
+}
+
+// ServeHTTP is fake state server request handler
+func (s *fakeStateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+
+	if !ok || user != "john" || password != "test1234" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	name := strings.TrimPrefix(r.URL.Path, "/state/")
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch r.Method {
+	case "GET":
+		data, ok := s.files[name]
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write(data)
+
+	case "PUT":
+		s.files[name] = body
+
+	case "DELETE":
+		if _, ok := s.files[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		delete(s.files, name)
+
+	case "LOCK":
+		if s.lock != nil {
+			s.writeLockInfo(w, http.StatusLocked)
+			return
+		}
+
+		s.lock = &LockInfo{}
+		json.Unmarshal(body, s.lock)
+
+	case "UNLOCK":
+		info := &LockInfo{}
+		json.Unmarshal(body, info)
+
+		if s.lock != nil && s.lock.ID != info.ID {
+			s.writeLockInfo(w, http.StatusConflict)
+			return
+		}
+
+		s.lock = nil
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// writeLockInfo write info about current lock holder to response
+func (s *fakeStateServer) writeLockInfo(w http.ResponseWriter, status int) {
+	data, _ := json.Marshal(s.lock)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
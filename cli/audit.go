@@ -0,0 +1,378 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/log"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/path"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// AUDIT_LOG_FILE is name of audit log file
+const AUDIT_LOG_FILE = "audit.log"
+
+// List of audit record outcomes
+const (
+	AUDIT_SUCCESS   = "success"
+	AUDIT_FAILURE   = "failure"
+	AUDIT_CANCELLED = "cancelled"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// AuditRecord contains info about state changing action
+type AuditRecord struct {
+	Timestamp  int64    `json:"timestamp"`
+	User       string   `json:"user"`
+	ServerUser string   `json:"server_user,omitempty"` // User of terrafarm server
+	Host       string   `json:"host"`
+	Command    string   `json:"command"`
+	Args       []string `json:"args,omitempty"`
+	Template   string   `json:"template,omitempty"`
+	Outcome    string   `json:"outcome"`
+	Droplets   []string `json:"droplets,omitempty"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// auditRecord is record about current command
+var auditRecord *AuditRecord
+
+// auditDroplets contains IDs of droplets in terraform state before
+// command execution
+var auditDroplets map[string]string
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// startAudit start recording of state changing command
+func startAudit(command string) {
+	auditRecord = newAuditRecord(getAuditCommandName(command))
+	auditRecord.Args = getMaskedArgs(os.Args[1:])
+	auditDroplets = getStateResources()
+
+	farmState, err := readFarmState()
+
+	if err == nil {
+		auditRecord.Template = farmState.Preferences.Template
+	}
+}
+
+// cancelAudit mark current command as cancelled by user
+func cancelAudit() {
+	if auditRecord != nil {
+		auditRecord.Outcome = AUDIT_CANCELLED
+	}
+}
+
+// finishAudit write record about current command to audit log
+func finishAudit(code int) {
+	if auditRecord == nil {
+		return
+	}
+
+	record := auditRecord
+	auditRecord = nil
+
+	switch {
+	case record.Outcome == AUDIT_CANCELLED:
+		// keep outcome
+	case code == 0:
+		record.Outcome = AUDIT_SUCCESS
+	default:
+		record.Outcome = AUDIT_FAILURE
+	}
+
+	farmState, err := readFarmState()
+
+	if err == nil {
+		record.Template = farmState.Preferences.Template
+	}
+
+	// Record can already contain droplets destroyed outside of terraform
+	record.Droplets = getAuditDroplets(record.Droplets, auditDroplets, getStateResources())
+
+	err = writeAuditRecord(record)
+
+	if err != nil {
+		terminal.PrintWarnMessage("Can't write audit record: %v", err)
+	}
+}
+
+// auditMonitorDestroy write record about farm destroying by monitor
+func auditMonitorDestroy(template string, droplets map[string]string, destroyed bool) {
+	record := newAuditRecord("monitor-destroy")
+	record.Template = template
+	record.Droplets = getAuditDroplets(nil, droplets)
+	record.Outcome = AUDIT_FAILURE
+
+	if destroyed {
+		record.Outcome = AUDIT_SUCCESS
+	}
+
+	err := writeAuditRecord(record)
+
+	if err != nil {
+		log.Error("Can't write audit record: %v", err)
+	}
+}
+
+// auditCommand is audit command handler
+func auditCommand(args []string) {
+	var since int64
+
+	if options.Has(OPT_SINCE) {
+		since = time.Now().Unix() - timeutil.ParseDuration(options.GetS(OPT_SINCE))
+	}
+
+	records, err := readAuditRecords()
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't read audit log: %v", err)
+		exit(1)
+	}
+
+	fmtutil.Separator(false, "AUDIT")
+
+	var count int
+
+	for _, record := range records {
+		if record.Timestamp < since || !isAuditRecordMatch(record, args) {
+			continue
+		}
+
+		printAuditRecord(record)
+
+		count++
+	}
+
+	if count == 0 {
+		fmtc.Println("  {s}No records found{!}")
+	}
+
+	fmtutil.Separator(false)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// newAuditRecord create new audit record for given command
+func newAuditRecord(command string) *AuditRecord {
+	hostname, _ := os.Hostname()
+
+	return &AuditRecord{
+		Timestamp:  time.Now().Unix(),
+		User:       envMap["USER"],
+		ServerUser: envMap[EV_SERVER_USER],
+		Host:       hostname,
+		Command:    command,
+	}
+}
+
+// writeAuditRecord append record to audit log
+func writeAuditRecord(record *AuditRecord) error {
+	data, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(getAuditLogFilePath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	// Single write with O_APPEND flag is atomic for small records
+	_, err = fd.Write(append(data, '\n'))
+
+	return err
+}
+
+// readAuditRecords read all records from audit log
+func readAuditRecords() ([]*AuditRecord, error) {
+	fd, err := os.Open(getAuditLogFilePath())
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	var result []*AuditRecord
+
+	scanner := bufio.NewScanner(fd)
+
+	for scanner.Scan() {
+		record := &AuditRecord{}
+
+		if json.Unmarshal(scanner.Bytes(), record) == nil {
+			result = append(result, record)
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+// printAuditRecord print info about audit record
+func printAuditRecord(record *AuditRecord) {
+	var color string
+
+	switch record.Outcome {
+	case AUDIT_SUCCESS:
+		color = "{g}"
+	case AUDIT_FAILURE:
+		color = "{r}"
+	default:
+		color = "{y}"
+	}
+
+	user := record.User + "@" + record.Host
+
+	if record.ServerUser != "" {
+		user += " (" + record.ServerUser + ")"
+	}
+
+	fmtc.Printf(
+		"  {s-}%s{!} {*}%-16s{!} "+color+"%-9s{!} %s",
+		timeutil.Format(time.Unix(record.Timestamp, 0), "%Y/%m/%d %H:%M:%S"),
+		record.Command, record.Outcome, user,
+	)
+
+	if record.Template != "" {
+		fmtc.Printf(" {s}template: %s{!}", record.Template)
+	}
+
+	if len(record.Droplets) != 0 {
+		fmtc.Printf(" {s}droplets: %s{!}", strings.Join(record.Droplets, ", "))
+	}
+
+	fmtc.NewLine()
+
+	if len(record.Args) != 0 {
+		fmtc.Printf("  {s-}%19s args: %s{!}\n", "", strings.Join(record.Args, " "))
+	}
+}
+
+// isAuditRecordMatch return true if record matches all filters, filter is
+// command name, user name or template name
+func isAuditRecordMatch(record *AuditRecord, filters []string) bool {
+	for _, filter := range filters {
+		switch filter {
+		case record.Command, record.User, record.ServerUser, record.Template:
+			continue
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// getAuditDroplets return sorted unique IDs of given droplets and droplets
+// from all states
+func getAuditDroplets(droplets []string, states ...map[string]string) []string {
+	var result []string
+
+	ids := make(map[string]bool)
+
+	for _, id := range droplets {
+		if id != "" && !ids[id] {
+			ids[id] = true
+			result = append(result, id)
+		}
+	}
+
+	for _, state := range states {
+		for _, id := range state {
+			if id != "" && !ids[id] {
+				ids[id] = true
+				result = append(result, id)
+			}
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// getAuditCommandName return main name of command, so records can be
+// filtered by command name regardless of used alias
+func getAuditCommandName(command string) string {
+	switch command {
+	case CMD_CREATE, CMD_APPLY, CMD_START, CMD_CREATE_SHORTCUT:
+		return CMD_CREATE
+	case CMD_DESTROY, CMD_DELETE, CMD_STOP, CMD_DESTROY_SHORTCUT:
+		return CMD_DESTROY
+	case CMD_PROLONG, CMD_PROLONG_SHORTCUT:
+		return CMD_PROLONG
+	}
+
+	return command
+}
+
+// getMaskedArgs return command-line arguments with masked secrets
+func getMaskedArgs(args []string) []string {
+	var result []string
+
+	secrets := map[string]bool{
+		"--token": true, "-T": true,
+		"--password": true, "-P": true,
+	}
+
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+
+		switch {
+		case secrets[arg] && index+1 < len(args):
+			result = append(result, arg, getMaskedSecret(arg, args[index+1]))
+			index++
+
+		case strings.Contains(arg, "=") && secrets[arg[:strings.Index(arg, "=")]]:
+			name := arg[:strings.Index(arg, "=")]
+			result = append(result, name+"="+getMaskedSecret(name, arg[len(name)+1:]))
+
+		default:
+			result = append(result, arg)
+		}
+	}
+
+	return result
+}
+
+// getMaskedSecret return masked value of secret argument
+func getMaskedSecret(name, value string) string {
+	if name == "--token" || name == "-T" {
+		return getMaskedToken(value)
+	}
+
+	return "********"
+}
+
+// getAuditLogFilePath return path to audit log file
+func getAuditLogFilePath() string {
+	return path.Join(getDataDir(), AUDIT_LOG_FILE)
+}
@@ -0,0 +1,305 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fsutil"
+	"pkg.re/essentialkaos/ek.v9/log"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/path"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+
+	"github.com/essentialkaos/terrafarm/backend"
+	"github.com/essentialkaos/terrafarm/prefs"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// stateFiles contains names of files stored in state backend
+var stateFiles = []string{
+	TERRAFORM_STATE_FILE,
+	FARM_STATE_FILE,
+	MONITOR_STATE_FILE,
+}
+
+// localBackend is backend which stores state files in data directory, it
+// is used by default and provides state lock for processes on current host
+var localBackend = &backend.LocalBackend{}
+
+// stateBackend is backend used for storing state files
+var stateBackend backend.Backend = localBackend
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// initStateBackend configure state backend and download state files
+// into data directory
+func initStateBackend() {
+	p, err := prefs.ReadPreferences()
+
+	if err == nil {
+		err = p.ValidateStateBackend()
+	}
+
+	if err != nil {
+		printStateError("%v", err)
+		exit(1)
+	}
+
+	localBackend.Dir = getDataDir()
+	stateBackend = getStateBackend(p)
+
+	if !isRemoteStateBackend() {
+		return
+	}
+
+	err = pullState()
+
+	if err != nil {
+		printStateError("Can't read state from %s backend: %v", stateBackend.Name(), err)
+		exit(1)
+	}
+}
+
+// getStateBackend return state backend configured in preferences
+func getStateBackend(p *prefs.Preferences) backend.Backend {
+	switch p.StateBackend {
+	case prefs.BACKEND_S3:
+		return &backend.S3Backend{
+			URL:       p.StateURL,
+			Region:    p.StateRegion,
+			AccessKey: p.StateAccessKey,
+			SecretKey: p.StateSecretKey,
+		}
+
+	case prefs.BACKEND_HTTP:
+		return &backend.HTTPBackend{
+			URL:      p.StateURL,
+			User:     p.StateAccessKey,
+			Password: p.StateSecretKey,
+		}
+	}
+
+	return localBackend
+}
+
+// isRemoteStateBackend return true if state files are stored outside
+// of data directory, so they must be synced with local files
+func isRemoteStateBackend() bool {
+	return stateBackend != backend.Backend(localBackend)
+}
+
+// pullState download all state files from backend to data directory, if
+// backend is empty, local state files will be uploaded to backend
+func pullState() error {
+	var exist bool
+
+	states := make(map[string][]byte)
+
+	for _, name := range stateFiles {
+		data, err := stateBackend.Read(name)
+
+		switch err {
+		case nil:
+			states[name], exist = data, true
+		case backend.ErrNotExist:
+			continue
+		default:
+			return err
+		}
+	}
+
+	if !exist {
+		for _, name := range stateFiles {
+			pushStateFile(name)
+		}
+
+		return nil
+	}
+
+	for _, name := range stateFiles {
+		err := writeLocalStateFile(name, states[name])
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pushStateFile upload state file from data directory to backend or
+// remove it from backend if local file doesn't exist
+func pushStateFile(name string) {
+	if !isRemoteStateBackend() {
+		return
+	}
+
+	var err error
+
+	stateFile := path.Join(getDataDir(), name)
+
+	if fsutil.IsExist(stateFile) {
+		var data []byte
+
+		data, err = ioutil.ReadFile(stateFile)
+
+		if err == nil {
+			err = stateBackend.Write(name, data)
+		}
+	} else {
+		err = stateBackend.Delete(name)
+	}
+
+	if err != nil {
+		printStateError("Can't save %s to %s backend: %v", name, stateBackend.Name(), err)
+	}
+}
+
+// writeLocalStateFile write state file to data directory, file will be
+// removed if data is nil
+func writeLocalStateFile(name string, data []byte) error {
+	stateFile := path.Join(getDataDir(), name)
+
+	if data == nil {
+		if fsutil.IsExist(stateFile) {
+			return os.Remove(stateFile)
+		}
+
+		return nil
+	}
+
+	return writeFileAtomic(stateFile, data, 0600)
+}
+
+// refreshMonitorState apply changes of farm and monitor state made by
+// other users through state backend
+func refreshMonitorState() {
+	if !isRemoteStateBackend() {
+		return
+	}
+
+	for _, name := range []string{TERRAFORM_STATE_FILE, FARM_STATE_FILE} {
+		data, err := stateBackend.Read(name)
+
+		if err == backend.ErrNotExist {
+			data, err = nil, nil
+		}
+
+		if err == nil {
+			err = writeLocalStateFile(name, data)
+		}
+
+		if err != nil {
+			log.Error("Can't read %s from %s backend: %v", name, stateBackend.Name(), err)
+			return
+		}
+	}
+
+	data, err := stateBackend.Read(MONITOR_STATE_FILE)
+
+	if err != nil {
+		return
+	}
+
+	state := &MonitorState{}
+
+	if json.Unmarshal(data, state) != nil {
+		return
+	}
+
+	monitorStateLock.Lock()
+	defer monitorStateLock.Unlock()
+
+	if state.DestroyAfter == monitorState.DestroyAfter &&
+		state.MaxWait == monitorState.MaxWait &&
+		state.Paused == monitorState.Paused {
+		return
+	}
+
+	monitorState.DestroyAfter = state.DestroyAfter
+	monitorState.MaxWait = state.MaxWait
+	monitorState.Paused = state.Paused
+
+	log.Info("Monitor state changed through %s state backend", stateBackend.Name())
+
+	err = saveMonitorState(monitorState)
+
+	if err != nil {
+		log.Error("Can't save monitor state: %v", err)
+	}
+}
+
+// prolongRemoteMonitor increase TTL of farm which monitored by another
+// host through state backend
+func prolongRemoteMonitor(ttl, maxWait int64) (*MonitorState, error) {
+	state, err := readMonitorState()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = prolongFarmState(ttl, maxWait)
+
+	if err != nil {
+		return nil, err
+	}
+
+	state.DestroyAfter += ttl
+
+	if maxWait > 0 {
+		state.MaxWait = maxWait
+	}
+
+	return state, saveMonitorState(state)
+}
+
+// isRemoteMonitor return true if farm monitored by another host which
+// shares state through state backend and monitor heartbeat is not stale
+func isRemoteMonitor() bool {
+	if !isRemoteStateBackend() {
+		return false
+	}
+
+	state, err := readMonitorState()
+
+	if err != nil || state.Pid == 0 || isLocalMonitor(state) {
+		return false
+	}
+
+	return time.Now().Unix()-state.Heartbeat < MONITOR_HEARTBEAT_TIMEOUT
+}
+
+// isStateChangingCommand return true if command can change farm state
+func isStateChangingCommand(cmd string, args []string) bool {
+	switch cmd {
+	case CMD_CREATE, CMD_APPLY, CMD_START, CMD_CREATE_SHORTCUT,
+		CMD_DESTROY, CMD_DELETE, CMD_STOP, CMD_DESTROY_SHORTCUT,
+		CMD_SCALE, CMD_IMPORT, CMD_DOCTOR,
+		CMD_PROLONG, CMD_PROLONG_SHORTCUT:
+		return true
+
+	case CMD_MONITOR:
+		return len(args) != 0 && args[0] == MONITOR_CMD_DESTROY_NOW
+	}
+
+	return false
+}
+
+// printStateError print error message about state backend
+func printStateError(message string, args ...interface{}) {
+	if options.GetB(OPT_MONITOR) {
+		log.Error(message, args...)
+	} else {
+		terminal.PrintErrorMessage(message, args...)
+	}
+}
@@ -0,0 +1,181 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+
+	"github.com/essentialkaos/terrafarm/provider"
+	"github.com/essentialkaos/terrafarm/terraform"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// BILLING_MONTH_HOURS is max number of billed hours per month, droplets
+// which work longer are billed by monthly price
+const BILLING_MONTH_HOURS = 672
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// fillFarmNodes add info about droplets from terraform state to farm state
+// and update creation time and prices of nodes using provider API
+func fillFarmNodes(token string, farmState *FarmState, started int64) {
+	tfState, err := terraform.ReadState(getTerraformStateFilePath())
+
+	if err != nil || len(tfState.Modules) == 0 {
+		return
+	}
+
+	droplets := make(map[int]*provider.Machine)
+	dropletsList, err := getFarmProvider(farmState).GetMachines(token)
+
+	if err == nil {
+		for _, droplet := range dropletsList {
+			droplets[droplet.ID] = droplet
+		}
+	}
+
+	for address, resource := range getNodeResources(tfState) {
+		if resource.Info == nil || resource.Info.Attributes == nil {
+			continue
+		}
+
+		name := address[strings.Index(address, ".")+1:]
+		node := findFarmNode(farmState, name)
+
+		if node == nil {
+			node = &FarmNode{Name: name, Started: started}
+			farmState.Nodes = append(farmState.Nodes, node)
+		}
+
+		if node.ID != 0 {
+			continue
+		}
+
+		node.ID, _ = strconv.Atoi(resource.Info.ID)
+		node.Droplet = resource.Info.Attributes.Name
+		node.Size = farmState.Preferences.NodeSize
+
+		droplet := droplets[node.ID]
+
+		if droplet == nil {
+			continue
+		}
+
+		if !droplet.Created.IsZero() {
+			node.Started = droplet.Created.Unix()
+		}
+
+		if droplet.Size != "" {
+			node.Size = droplet.Size
+			node.PriceHourly = droplet.PriceHourly
+			node.PriceMonthly = droplet.PriceMonthly
+		}
+	}
+}
+
+// findFarmNode return farm node with given resource name
+func findFarmNode(farmState *FarmState, name string) *FarmNode {
+	for _, node := range farmState.Nodes {
+		if node.Name == name && node.Destroyed == 0 {
+			return node
+		}
+	}
+
+	return nil
+}
+
+// printFarmBilling print usage price of every farm node
+func printFarmBilling(farmState *FarmState) {
+	for index, node := range farmState.Nodes {
+		var title string
+
+		if index == 0 {
+			title = "Billing:"
+		}
+
+		name := node.Droplet
+
+		if name == "" {
+			name = node.Name
+		}
+
+		hours := getNodeBilledHours(node)
+
+		fmtc.Printf(
+			"  {*}%-16s{!} %-24s {s-}%s × %d h{!} $%.2f",
+			title, name, getNodeSize(farmState, node), hours, getNodeUsagePrice(farmState, node),
+		)
+
+		if node.Destroyed != 0 {
+			fmtc.Printf(" {s-}(removed){!}")
+		}
+
+		fmtc.NewLine()
+	}
+}
+
+// getNodeUsagePrice return node usage price using hourly billing rules
+// (every started hour is billed, price is capped by monthly price)
+func getNodeUsagePrice(farmState *FarmState, node *FarmNode) float64 {
+	priceHourly, priceMonthly := node.PriceHourly, node.PriceMonthly
+
+	if priceHourly == 0 {
+		priceHourly = getSizePrice(getFarmProvider(farmState), "", getNodeSize(farmState, node))
+	}
+
+	if priceMonthly == 0 {
+		priceMonthly = priceHourly * BILLING_MONTH_HOURS
+	}
+
+	hours := getNodeBilledHours(node)
+	months := hours / BILLING_MONTH_HOURS
+	hours = hours % BILLING_MONTH_HOURS
+
+	return float64(months)*priceMonthly + math.Min(float64(hours)*priceHourly, priceMonthly)
+}
+
+// getNodeBilledHours return number of billed hours for node
+func getNodeBilledHours(node *FarmNode) int64 {
+	end := time.Now().Unix()
+
+	if node.Destroyed != 0 {
+		end = node.Destroyed
+	}
+
+	if end <= node.Started {
+		return 1
+	}
+
+	return int64(math.Ceil(float64(end-node.Started) / 3600.0))
+}
+
+// getNodeSize return node droplet size
+func getNodeSize(farmState *FarmState, node *FarmNode) string {
+	if node.Size != "" {
+		return node.Size
+	}
+
+	return farmState.Preferences.NodeSize
+}
+
+// getFarmBilledHours return total number of billed hours for all farm nodes
+func getFarmBilledHours(farmState *FarmState) int64 {
+	var result int64
+
+	for _, node := range farmState.Nodes {
+		result += getNodeBilledHours(node)
+	}
+
+	return result
+}
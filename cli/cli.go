@@ -15,6 +15,7 @@ import (
 	"os/exec"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,7 +30,6 @@ import (
 	"pkg.re/essentialkaos/ek.v9/path"
 	"pkg.re/essentialkaos/ek.v9/pluralize"
 	"pkg.re/essentialkaos/ek.v9/req"
-	"pkg.re/essentialkaos/ek.v9/sliceutil"
 	"pkg.re/essentialkaos/ek.v9/spellcheck"
 	"pkg.re/essentialkaos/ek.v9/terminal"
 	"pkg.re/essentialkaos/ek.v9/timeutil"
@@ -37,8 +37,9 @@ import (
 	"pkg.re/essentialkaos/ek.v9/usage"
 	"pkg.re/essentialkaos/ek.v9/usage/update"
 
-	"github.com/essentialkaos/terrafarm/do"
+	"github.com/essentialkaos/terrafarm/notifier"
 	"github.com/essentialkaos/terrafarm/prefs"
+	"github.com/essentialkaos/terrafarm/provider"
 	"github.com/essentialkaos/terrafarm/terraform"
 )
 
@@ -53,23 +54,37 @@ const (
 
 // List of supported command-line arguments
 const (
-	OPT_TTL         = "t:ttl"
-	OPT_OUTPUT      = "o:output"
-	OPT_TOKEN       = "T:token"
-	OPT_KEY         = "K:key"
-	OPT_REGION      = "R:region"
-	OPT_NODE_SIZE   = "N:node-size"
-	OPT_USER        = "U:user"
-	OPT_PASSWORD    = "P:password"
-	OPT_DEBUG       = "D:debug"
-	OPT_MONITOR     = "m:monitor"
-	OPT_MAX_WAIT    = "w:max-wait"
-	OPT_FORCE       = "f:force"
-	OPT_NO_VALIDATE = "nv:no-validate"
-	OPT_NOTIFY      = "n:notify"
-	OPT_NO_COLOR    = "nc:no-color"
-	OPT_HELP        = "h:help"
-	OPT_VER         = "v:version"
+	OPT_TTL          = "t:ttl"
+	OPT_OUTPUT       = "o:output"
+	OPT_TOKEN        = "T:token"
+	OPT_KEY          = "K:key"
+	OPT_REGION       = "R:region"
+	OPT_NODE_SIZE    = "N:node-size"
+	OPT_USER         = "U:user"
+	OPT_PASSWORD     = "P:password"
+	OPT_ACCOUNT      = "account"
+	OPT_DEBUG        = "D:debug"
+	OPT_MONITOR      = "m:monitor"
+	OPT_FOREGROUND   = "foreground"
+	OPT_MAX_WAIT     = "w:max-wait"
+	OPT_FORCE        = "f:force"
+	OPT_NO_VALIDATE  = "nv:no-validate"
+	OPT_NOTIFY       = "n:notify"
+	OPT_NODES        = "nodes"
+	OPT_SNAPSHOT     = "S:from-snapshot"
+	OPT_RESUME       = "resume"
+	OPT_TEMPLATE     = "template"
+	OPT_REPO         = "repo"
+	OPT_PACKAGE      = "package"
+	OPT_FILE         = "file"
+	OPT_ADD          = "add"
+	OPT_REMOVE       = "remove"
+	OPT_LOCK_TIMEOUT = "lock-timeout"
+	OPT_SERVER       = "server"
+	OPT_SINCE        = "since"
+	OPT_NO_COLOR     = "nc:no-color"
+	OPT_HELP         = "h:help"
+	OPT_VER          = "v:version"
 )
 
 // List of supported commands
@@ -87,6 +102,15 @@ const (
 	CMD_STOP      = "stop"
 	CMD_TEMPLATES = "templates"
 	CMD_RESOURCES = "resources"
+	CMD_WATCH     = "watch"
+	CMD_MONITOR   = "monitor"
+	CMD_IMAGE     = "image"
+	CMD_SCALE     = "scale"
+	CMD_IMPORT    = "import"
+
+	CMD_FORCE_UNLOCK = "force-unlock"
+	CMD_SERVE        = "serve"
+	CMD_AUDIT        = "audit"
 
 	CMD_CREATE_SHORTCUT    = "c"
 	CMD_DESTROY_SHORTCUT   = "d"
@@ -132,33 +156,44 @@ const SEPARATOR = "-------------------------------------------------------------
 
 // FarmState contains farm specific info
 type FarmState struct {
-	Preferences *prefs.Preferences `json:"preferences"`
-	Started     int64              `json:"started"`
+	Preferences  *prefs.Preferences `json:"preferences"`
+	Account      string             `json:"account,omitempty"`  // Name of account used for farm creation
+	Provider     string             `json:"provider,omitempty"` // Name of provider used for farm creation
+	Started      int64              `json:"started"`
+	FromSnapshot bool               `json:"from_snapshot,omitempty"`
+	Failed       bool               `json:"failed,omitempty"`
+	Nodes        []*FarmNode        `json:"nodes,omitempty"`
+}
+
+// FarmNode contains info about farm build node lifetime
+type FarmNode struct {
+	Name         string  `json:"name"` // Terraform resource name
+	ID           int     `json:"id,omitempty"`
+	Droplet      string  `json:"droplet,omitempty"`
+	Size         string  `json:"size,omitempty"`
+	PriceHourly  float64 `json:"price_hourly,omitempty"`
+	PriceMonthly float64 `json:"price_monthly,omitempty"`
+	Started      int64   `json:"started"`
+	Destroyed    int64   `json:"destroyed,omitempty"`
 }
 
 // NodeInfo contains info about build node
 type NodeInfo struct {
 	Name     string
 	IP       string
+	Port     int // SSH port
 	Arch     string
 	User     string
 	Password string
 	State    uint8
-}
 
-// DropletInfo contains basic node info
-type DropletInfo struct {
-	Price   float64
-	CPU     int
-	Memory  float64
-	Disk    int
-	Regions []string
-}
-
-// RegionInfo contains basic region info
-type RegionInfo struct {
-	DCName     string
-	RegionName string
+	Build     string     // Name of package which currently built on node
+	Load      [3]float64 // Load average for 1, 5 and 15 minutes
+	Uptime    int64      // Uptime in seconds
+	DiskTotal uint64     // Size of partition with user home directory
+	DiskFree  uint64     // Free space on partition with user home directory
+	MemTotal  uint64     // Total memory size
+	MemUsed   uint64     // Used memory size (without buffers and cache)
 }
 
 // ////////////////////////////////////////////////////////////////////////////////// //
@@ -174,22 +209,36 @@ func (p NodeInfoSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
 // optMap is map with supported command-line options
 var optMap = options.Map{
-	OPT_TTL:         {},
-	OPT_OUTPUT:      {},
-	OPT_TOKEN:       {},
-	OPT_KEY:         {},
-	OPT_REGION:      {},
-	OPT_NODE_SIZE:   {},
-	OPT_USER:        {},
-	OPT_MAX_WAIT:    {},
-	OPT_DEBUG:       {Type: options.BOOL},
-	OPT_MONITOR:     {Type: options.BOOL},
-	OPT_FORCE:       {Type: options.BOOL},
-	OPT_NO_VALIDATE: {Type: options.BOOL},
-	OPT_NOTIFY:      {Type: options.BOOL},
-	OPT_NO_COLOR:    {Type: options.BOOL},
-	OPT_HELP:        {Type: options.BOOL, Alias: "u:usage"},
-	OPT_VER:         {Type: options.BOOL, Alias: "ver"},
+	OPT_TTL:          {},
+	OPT_OUTPUT:       {},
+	OPT_TOKEN:        {},
+	OPT_KEY:          {},
+	OPT_REGION:       {},
+	OPT_NODE_SIZE:    {},
+	OPT_USER:         {},
+	OPT_ACCOUNT:      {},
+	OPT_MAX_WAIT:     {},
+	OPT_REPO:         {},
+	OPT_PACKAGE:      {},
+	OPT_FILE:         {},
+	OPT_ADD:          {},
+	OPT_REMOVE:       {},
+	OPT_TEMPLATE:     {},
+	OPT_LOCK_TIMEOUT: {},
+	OPT_SERVER:       {},
+	OPT_SINCE:        {},
+	OPT_DEBUG:        {Type: options.BOOL},
+	OPT_MONITOR:      {Type: options.BOOL},
+	OPT_FOREGROUND:   {Type: options.BOOL},
+	OPT_FORCE:        {Type: options.BOOL},
+	OPT_NO_VALIDATE:  {Type: options.BOOL},
+	OPT_NOTIFY:       {Type: options.BOOL},
+	OPT_NODES:        {Type: options.BOOL},
+	OPT_SNAPSHOT:     {Type: options.BOOL},
+	OPT_RESUME:       {Type: options.BOOL},
+	OPT_NO_COLOR:     {Type: options.BOOL},
+	OPT_HELP:         {Type: options.BOOL, Alias: "u:usage"},
+	OPT_VER:          {Type: options.BOOL, Alias: "ver"},
 }
 
 // depList is slice with dependencies required by terrafarm
@@ -203,58 +252,6 @@ var envMap = env.Get()
 // startTime is time when app is started
 var startTime = time.Now().Unix()
 
-// droplets contains droplets codes
-var droplets = []string{
-	"512mb", "1gb", "2gb", "4gb", "8gb", "16gb", "32gb", "64gb",
-	"m-16gb", "m-32gb", "m-64gb", "m-128gb", "m-224gb",
-	"c-2", "c-4", "c-8", "c-16", "c-32",
-}
-
-// dropletInfoStorage contains info about droplets
-var dropletInfoStorage = map[string]DropletInfo{
-	"512mb":   {0.007, 1, 0.512, 20, nil},
-	"1gb":     {0.015, 1, 1, 30, nil},
-	"2gb":     {0.030, 2, 2, 40, nil},
-	"4gb":     {0.060, 2, 4, 60, nil},
-	"8gb":     {0.119, 4, 8, 80, nil},
-	"16gb":    {0.238, 8, 16, 160, nil},
-	"32gb":    {0.426, 12, 32, 320, nil},
-	"48gb":    {0.714, 16, 48, 480, nil},
-	"64gb":    {0.952, 20, 64, 640, nil},
-	"m-16gb":  {0.179, 2, 16, 30, []string{"nyc1", "nyc3", "sfo2", "lon1", "fra1", "tor1", "blr1"}},
-	"m-32gb":  {0.357, 4, 32, 90, []string{"nyc1", "nyc3", "sfo2", "lon1", "fra1", "tor1", "blr1"}},
-	"m-64gb":  {0.714, 8, 64, 200, []string{"nyc1", "nyc3", "sfo2", "lon1", "fra1", "tor1", "blr1"}},
-	"m-128gb": {1.429, 16, 128, 340, []string{"nyc1", "nyc3", "sfo2", "lon1", "fra1", "tor1", "blr1"}},
-	"m-224gb": {2.500, 32, 224, 500, []string{"nyc1", "nyc3", "sfo2", "lon1", "fra1", "tor1", "blr1"}},
-	"c-2":     {0.060, 2, 3, 20, []string{"nyc1", "nyc3", "sfo2", "ams3", "tor1", "blr1"}},
-	"c-4":     {0.119, 4, 6, 20, []string{"nyc1", "nyc3", "sfo2", "ams3", "tor1", "blr1"}},
-	"c-8":     {0.238, 8, 12, 20, []string{"nyc1", "nyc3", "sfo2", "ams3", "tor1", "blr1"}},
-	"c-16":    {0.476, 16, 24, 20, []string{"nyc1", "nyc3", "sfo2", "ams3", "tor1", "blr1"}},
-	"c-32":    {0.952, 32, 48, 20, []string{"nyc1", "nyc3", "sfo2", "ams3", "tor1", "blr1"}},
-}
-
-// regions contains regions codes
-var regions = []string{
-	"nyc1", "nyc2", "nyc3", "sfo1", "sfo2", "tor1",
-	"lon1", "ams2", "ams3", "fra1", "blr1", "sgp1",
-}
-
-// regionInfoStorage contains info about regions
-var regionInfoStorage = map[string]RegionInfo{
-	"nyc1": {"New York #1", "US East"},
-	"nyc2": {"New York #2", "US East"},
-	"nyc3": {"New York #3", "US East"},
-	"sfo1": {"San Francisco #1", "US West"},
-	"sfo2": {"San Francisco #2", "US West"},
-	"tor1": {"Toronto", "Canada"},
-	"lon1": {"London", "UK"},
-	"ams2": {"Amsterdam #2", "Europe"},
-	"ams3": {"Amsterdam #3", "Europe"},
-	"fra1": {"Frankfurt", "Europe"},
-	"blr1": {"Bangalore", "Asia Pacific"},
-	"sgp1": {"Singapore", "Asia Pacific"},
-}
-
 // colorTags contains fmtc color codes
 var colorTags = []string{
 	"{c}", "{m}", "{b}", "{y}", "{g}",
@@ -301,8 +298,15 @@ func Init() {
 	}
 
 	prepare()
+
+	if options.Has(OPT_SERVER) {
+		serverCommand(args[0], args[1:])
+		exit(0)
+	}
+
 	checkEnv()
 	checkDeps()
+	initStateBackend()
 
 	if options.GetB(OPT_MONITOR) {
 		startFarmMonitor()
@@ -403,9 +407,14 @@ func processCommand(cmd string, args []string) {
 	scm := getSpellcheckModel()
 	cmd = scm.Correct(cmd)
 
+	if isStateChangingCommand(cmd, args) {
+		lockState(cmd)
+		startAudit(cmd)
+	}
+
 	switch cmd {
 	case CMD_CREATE, CMD_APPLY, CMD_START, CMD_CREATE_SHORTCUT:
-		createCommand(getPreferences(), args)
+		createCommand(getTemplatePreferences(getCommandTemplate(args)), args)
 	case CMD_DESTROY, CMD_DELETE, CMD_STOP, CMD_DESTROY_SHORTCUT:
 		destroyCommand(getPreferences())
 	case CMD_STATUS, CMD_INFO, CMD_STATE, CMD_STATUS_SHORTCUT:
@@ -413,11 +422,27 @@ func processCommand(cmd string, args []string) {
 	case CMD_TEMPLATES, CMD_TEMPLATES_SHORTCUT:
 		templatesCommand()
 	case CMD_RESOURCES, CMD_RESOURCES_SHORTCUT:
-		resourcesCommand()
+		resourcesCommand(args)
+	case CMD_WATCH:
+		watchCommand(getPreferences())
+	case CMD_MONITOR:
+		monitorCommand(args)
+	case CMD_IMAGE:
+		imageCommand(getPreferences(), args)
+	case CMD_SCALE:
+		scaleCommand(getPreferences())
+	case CMD_IMPORT:
+		importCommand(getTemplatePreferences(getCommandTemplate(args)), args)
 	case CMD_PROLONG, CMD_PROLONG_SHORTCUT:
 		prolongCommand(args)
 	case CMD_DOCTOR:
 		doctorCommand(getPreferences())
+	case CMD_FORCE_UNLOCK:
+		forceUnlockCommand(args)
+	case CMD_SERVE:
+		serveCommand(getPreferences())
+	case CMD_AUDIT:
+		auditCommand(args)
 	default:
 		terminal.PrintErrorMessage("Unknown command %s", cmd)
 		exit(1)
@@ -428,16 +453,35 @@ func processCommand(cmd string, args []string) {
 
 // createCommand is create command handler
 func createCommand(p *prefs.Preferences, args []string) {
+	if len(args) != 0 {
+		p.Template = args[0]
+	}
+
+	if options.GetB(OPT_RESUME) {
+		resumeCommand(p)
+		return
+	}
+
 	if isTerrafarmActive() {
 		terminal.PrintWarnMessage("Terrafarm already works")
 		exit(1)
 	}
 
-	if len(args) != 0 {
-		p.Template = args[0]
+	validatePreferences(p)
+
+	if !isProviderSupported(p.Template) {
+		terminal.PrintErrorMessage(
+			"Provider %s used by template %s is not supported",
+			getTemplateProviderName(p.Template), p.Template,
+		)
+		exit(1)
+	}
+
+	if options.GetB(OPT_SNAPSHOT) && getTemplateProviderName(p.Template) != provider.DIGITALOCEAN {
+		terminal.PrintErrorMessage("Baked images are supported only for DigitalOcean templates")
+		exit(1)
 	}
 
-	validatePreferences(p)
 	statusCommand(p)
 
 	if !options.GetB(OPT_FORCE) {
@@ -445,12 +489,21 @@ func createCommand(p *prefs.Preferences, args []string) {
 
 		if !yes || err != nil {
 			fmtc.NewLine()
+			cancelAudit()
 			return
 		}
 
 		fmtutil.Separator(false)
 	}
 
+	err := runHooks(HOOK_PRE_CREATE, p, nil, 0)
+
+	if err != nil {
+		terminal.PrintErrorMessage("%v", err)
+		notify()
+		exit(1)
+	}
+
 	vars, err := prefsToArgs(p)
 
 	if err != nil {
@@ -460,27 +513,44 @@ func createCommand(p *prefs.Preferences, args []string) {
 
 	printDebug("EXEC → terraform apply %s", strings.Join(vars, " "))
 
-	// Current moment + 90 seconds for starting droplets
-	farmStartTime := time.Now().Unix() + 90
+	workDir, err := prepareWorkDir(p, getWorkDir(p.Template), options.GetB(OPT_SNAPSHOT))
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't prepare template: %v", err)
+		exit(1)
+	}
 
-	fsutil.Push(path.Join(getDataDir(), p.Template))
+	if !runPreflightChecks(p, workDir) && !options.GetB(OPT_FORCE) {
+		terminal.PrintErrorMessage("Pre-flight checks failed, use --force option for ignoring failed checks")
+		notify()
+		exit(1)
+	}
 
-	err = execTerraform(false, "apply", vars)
+	// Current moment + 90 seconds for starting droplets
+	farmStartTime := time.Now().Unix() + 90
 
-	fsutil.Pop()
+	err = applyFarm(p, workDir, vars)
 
 	if err != nil {
 		terminal.PrintErrorMessage("\nError while executing terraform: %v", err)
+		fmtutil.Separator(false)
+		rollbackFarm(p, workDir, farmStartTime, options.GetB(OPT_SNAPSHOT))
 		notify()
 		exit(1)
 	}
 
 	fmtutil.Separator(false)
 
+	completeFarmCreation(p, farmStartTime, options.GetB(OPT_SNAPSHOT))
+}
+
+// completeFarmCreation export info about nodes, run post-create hooks,
+// start monitor and save farm state after nodes creation
+func completeFarmCreation(p *prefs.Preferences, farmStartTime int64, fromSnapshot bool) {
 	if p.Output != "" {
 		fmtc.Println("Exporting info about build nodes...")
 
-		err = exportNodeList(p)
+		err := exportNodeList(p)
 
 		if err != nil {
 			terminal.PrintErrorMessage("Error while exporting info: %v", err)
@@ -497,6 +567,17 @@ func createCommand(p *prefs.Preferences, args []string) {
 		fmtutil.Separator(false)
 	}
 
+	nodes, _ := collectNodesInfo(p)
+
+	applyExtras(p, nodes)
+
+	err := runHooks(HOOK_POST_CREATE, p, nodes, farmStartTime)
+
+	if err != nil {
+		terminal.PrintWarnMessage("%v", err)
+		fmtutil.Separator(false)
+	}
+
 	if p.TTL > 0 {
 		fmtc.Printf("Starting monitoring process... ")
 
@@ -512,7 +593,7 @@ func createCommand(p *prefs.Preferences, args []string) {
 			exit(1)
 		}
 
-		err = startMonitorProcess(false)
+		err = startMonitorProcess()
 
 		if err != nil {
 			fmtc.NewLine()
@@ -526,7 +607,16 @@ func createCommand(p *prefs.Preferences, args []string) {
 		fmtutil.Separator(false)
 	}
 
-	saveState(p, farmStartTime)
+	sendNotification(p, notifier.NewEvent(
+		notifier.EVENT_FARM_CREATED, p.Template,
+		fmtc.Sprintf(
+			"Farm with %s created (TTL: %s)",
+			pluralize.Pluralize(getBuildNodesCount(p.Template), "build node", "build nodes"),
+			getPrettyTTL(p.TTL),
+		),
+	))
+
+	saveState(p, farmStartTime, fromSnapshot)
 
 	notify()
 }
@@ -539,10 +629,10 @@ func statusCommand(p *prefs.Preferences) {
 		farmState    *FarmState
 		monitorState *MonitorState
 
-		tokenValid       do.StatusCode
-		fingerprintValid do.StatusCode
-		regionValid      do.StatusCode
-		sizeValid        do.StatusCode
+		tokenValid       provider.StatusCode
+		fingerprintValid provider.StatusCode
+		regionValid      provider.StatusCode
+		sizeValid        provider.StatusCode
 
 		ttlRemain          int64
 		totalUsagePriceMin float64
@@ -555,14 +645,17 @@ func statusCommand(p *prefs.Preferences) {
 
 		buildersTotal   int
 		buildersBullets string
+
+		nodes []*NodeInfo
 	)
 
 	var (
 		terrafarmActive = isTerrafarmActive()
-		monitorActive   = isMonitorActive()
+		monitorActive   = isMonitorActive() || isRemoteMonitor()
 	)
 
 	disableValidation = options.GetB(OPT_NO_VALIDATE)
+	userPrefs := p
 
 	if terrafarmActive {
 		farmState, err = readFarmState()
@@ -573,18 +666,26 @@ func statusCommand(p *prefs.Preferences) {
 		}
 	}
 
-	buildersTotal = getBuildNodesCount(p.Template)
-
-	totalUsagePriceMin = calculateUsagePrice(p.TTL, buildersTotal, p.NodeSize)
+	var prov provider.Provider
 
-	if terrafarmActive {
-		usageHours := int64(time.Since(time.Unix(farmState.Started, 0)).Hours() * 60)
-		currentUsagePrice = calculateUsagePrice(usageHours, buildersTotal, p.NodeSize)
+	if terrafarmActive && farmState != nil {
+		prov = getFarmProvider(farmState)
+		buildersTotal = getFarmNodesCount(farmState)
+		currentUsagePrice = getFarmUsagePrice(farmState)
+	} else {
+		prov = getTemplateProvider(p.Template)
+		buildersTotal = getBuildNodesCount(p.Template)
 	}
 
+	totalUsagePriceMin = calculateUsagePrice(prov, userPrefs.Token, p.TTL, buildersTotal, p.NodeSize)
+
 	if p.MaxWait > 0 {
 		totalUsagePriceMax = totalUsagePriceMin
-		totalUsagePriceMax += calculateUsagePrice(p.MaxWait, buildersTotal, p.NodeSize)
+		totalUsagePriceMax += calculateUsagePrice(prov, userPrefs.Token, p.MaxWait, buildersTotal, p.NodeSize)
+	}
+
+	if terrafarmActive && (monitorActive || options.GetB(OPT_NODES)) {
+		nodes = getBuildNodesInfo(p)
 	}
 
 	if monitorActive {
@@ -595,20 +696,20 @@ func statusCommand(p *prefs.Preferences) {
 			ttlRemain = monitorState.DestroyAfter - time.Now().Unix()
 		}
 
-		buildersBullets = getBuildBullets(p)
+		buildersBullets = getBuildBullets(nodes)
 	}
 
 	if !disableValidation {
-		tokenValid = do.IsValidToken(p.Token)
-		fingerprintValid = do.IsFingerprintValid(p.Token, p.Fingerprint)
+		tokenValid = prov.IsValidToken(p.Token)
+		fingerprintValid = prov.IsFingerprintValid(p.Token, p.Fingerprint)
 
 		if p.Template != "" {
-			regionValid = do.IsRegionValid(p.Token, p.Region)
-			sizeValid = do.IsSizeValid(p.Token, p.NodeSize)
+			regionValid = prov.IsRegionValid(p.Token, p.Region)
+			sizeValid = prov.IsSizeValid(p.Token, p.NodeSize)
 		}
 
-		if !isDropletAvailable(p.NodeSize, p.Region) {
-			sizeValid = do.STATUS_NOT_OK
+		if !provider.IsSizeAvailable(prov, p.Token, p.NodeSize, p.Region) {
+			sizeValid = provider.STATUS_NOT_OK
 		}
 	}
 
@@ -621,6 +722,10 @@ func statusCommand(p *prefs.Preferences) {
 		)
 	}
 
+	if p.Account != "" {
+		fmtc.Printf("  {*}%-16s{!} %s\n", "Account:", p.Account)
+	}
+
 	fmtc.Printf("  {*}%-16s{!} %s", "Token:", getPrettyToken(p.Token))
 
 	printValidationMarker(tokenValid, disableValidation, true)
@@ -664,21 +769,33 @@ func statusCommand(p *prefs.Preferences) {
 
 		printValidationMarker(sizeValid, disableValidation, false)
 
-		if dropletInfoStorage[p.NodeSize].CPU != 0 {
+		if sizeInfo := provider.GetSize(prov, userPrefs.Token, p.NodeSize); sizeInfo != nil {
 			if disableValidation {
 				fmt.Printf(" ")
 			}
 
 			fmtc.Printf(
 				"{s-}(%s + %d GB Disk){!}\n",
-				pluralize.Pluralize(dropletInfoStorage[p.NodeSize].CPU, "CPU", "CPUs"),
-				dropletInfoStorage[p.NodeSize].Disk,
+				pluralize.Pluralize(sizeInfo.CPU, "CPU", "CPUs"),
+				sizeInfo.Disk,
 			)
 		} else {
 			fmtc.NewLine()
 		}
 
 		fmtc.Printf("  {*}%-16s{!} %s\n", "User:", p.User)
+
+		if len(p.ExtraRepos) != 0 {
+			fmtc.Printf("  {*}%-16s{!} %s\n", "Extra repos:", strings.Join(p.ExtraRepos, ", "))
+		}
+
+		if len(p.ExtraPackages) != 0 {
+			fmtc.Printf("  {*}%-16s{!} %s\n", "Extra packages:", strings.Join(p.ExtraPackages, ", "))
+		}
+
+		if len(p.ExtraFiles) != 0 {
+			fmtc.Printf("  {*}%-16s{!} %s\n", "Extra files:", strings.Join(p.ExtraFiles, ", "))
+		}
 	}
 
 	if p.Output != "" {
@@ -690,7 +807,11 @@ func statusCommand(p *prefs.Preferences) {
 	if !isTerrafarmActive() {
 		fmtc.Printf("  {*}%-16s{!} {s}stopped{!}\n", "State:")
 	} else {
-		fmtc.Printf("  {*}%-16s{!} {g}works{!}", "State:")
+		if farmState != nil && farmState.Failed {
+			fmtc.Printf("  {*}%-16s{!} {r}failed{!}", "State:")
+		} else {
+			fmtc.Printf("  {*}%-16s{!} {g}works{!}", "State:")
+		}
 
 		if currentUsagePrice == 0 {
 			fmtc.NewLine()
@@ -698,10 +819,16 @@ func statusCommand(p *prefs.Preferences) {
 			fmtc.Printf(" {s-}($%.2f){!}\n", currentUsagePrice)
 		}
 
+		if farmState != nil && len(farmState.Nodes) != 0 {
+			printFarmBilling(farmState)
+		}
+
 		fmtc.Printf("  {*}%-16s{!} "+buildersBullets+"\n", "Nodes Statuses:")
 
 		if monitorActive {
-			if ttlRemain == 0 {
+			if monitorState != nil && monitorState.Paused {
+				fmtc.Printf("  {*}%-16s{!} {g}works{!} {y}(paused){!}\n", "Monitor:")
+			} else if ttlRemain == 0 {
 				fmtc.Printf("  {*}%-16s{!} {r}unknown{!}\n", "Monitor:")
 			} else {
 				if ttlRemain < 0 {
@@ -720,9 +847,29 @@ func statusCommand(p *prefs.Preferences) {
 		} else {
 			fmtc.Printf("  {*}%-16s{!} {r}stopped{!}\n", "Monitor:")
 		}
+
+		if monitorState != nil && monitorState.Supervisor != "" {
+			fmtc.Printf("  {*}%-16s{!} %s\n", "Supervisor:", monitorState.Supervisor)
+		}
+
+		if options.GetB(OPT_NODES) {
+			printNodesMetrics(nodes)
+		}
+	}
+
+	if !options.GetB(OPT_NO_VALIDATE) {
+		orphans, err := findOrphanDroplets(prov, userPrefs.Token)
+
+		if err == nil {
+			printOrphanDroplets(orphans)
+		}
 	}
 
 	fmtutil.Separator(false)
+
+	if terrafarmActive && !monitorActive && farmState != nil {
+		checkMissedDeadline(userPrefs, farmState)
+	}
 }
 
 // destroyCommand is destroy command handler
@@ -747,6 +894,7 @@ func destroyCommand(prefs *prefs.Preferences) {
 
 			if !yes || err != nil {
 				fmtc.NewLine()
+				cancelAudit()
 				return
 			}
 		} else {
@@ -754,11 +902,17 @@ func destroyCommand(prefs *prefs.Preferences) {
 
 			if !yes || err != nil {
 				fmtc.NewLine()
+				cancelAudit()
 				return
 			}
 		}
 	}
 
+	destroyFarm(prefs)
+}
+
+// destroyFarm destroy farm using current preferences for credentials
+func destroyFarm(prefs *prefs.Preferences) {
 	farmState, err := readFarmState()
 
 	if err != nil {
@@ -781,9 +935,26 @@ func destroyCommand(prefs *prefs.Preferences) {
 
 	fmtutil.Separator(false)
 
+	nodes, _ := collectNodesInfo(p)
+	err = runHooks(HOOK_PRE_DESTROY, p, nodes, farmState.Started)
+
+	if err != nil {
+		if !options.GetB(OPT_FORCE) {
+			terminal.PrintErrorMessage("%v", err)
+			terminal.PrintWarnMessage("Farm destroy canceled, use --force option for ignoring hook errors")
+			notify()
+			exit(1)
+		}
+
+		terminal.PrintWarnMessage("%v", err)
+		fmtutil.Separator(false)
+	}
+
+	harvestArtifacts(p, nodes)
+
 	printDebug("EXEC → terraform destroy %s", strings.Join(vars, " "))
 
-	fsutil.Push(path.Join(getDataDir(), p.Template))
+	fsutil.Push(getFarmWorkDir(farmState))
 
 	err = execTerraform(false, "destroy", vars)
 
@@ -791,6 +962,10 @@ func destroyCommand(prefs *prefs.Preferences) {
 
 	if err != nil {
 		terminal.PrintErrorMessage("\nError while executing terraform: %v", err)
+		sendNotification(prefs, notifier.NewEvent(
+			notifier.EVENT_DESTROY_FAILED, p.Template,
+			fmtc.Sprintf("Can't destroy farm: %v", err),
+		))
 		notify()
 		exit(1)
 	}
@@ -798,28 +973,61 @@ func destroyCommand(prefs *prefs.Preferences) {
 	fmtutil.Separator(false)
 
 	if priceMessage != "" {
-		fmtc.Printf("  {*}Usage price:{!} %s {s-}(%s){!}\n\n", priceMessage, priceMessageComment)
+		fmtc.Printf("  {*}Usage price:{!} %s {s-}(%s){!}\n", priceMessage, priceMessageComment)
+
+		if len(farmState.Nodes) != 0 {
+			printFarmBilling(farmState)
+		}
+
+		fmtc.NewLine()
 	}
 
+	sendDestroyNotification(prefs, farmState, priceMessage, priceMessageComment)
+
 	deleteFarmStateFile()
 
+	err = runHooks(HOOK_POST_DESTROY, p, nodes, farmState.Started)
+
+	if err != nil {
+		terminal.PrintWarnMessage("%v", err)
+	}
+
 	notify()
 }
 
+// sendDestroyNotification send notification about destroyed farm
+func sendDestroyNotification(p *prefs.Preferences, farmState *FarmState, priceMessage, priceMessageComment string) {
+	event := notifier.NewEvent(
+		notifier.EVENT_FARM_DESTROYED, farmState.Preferences.Template,
+		"Farm destroyed",
+	)
+
+	if priceMessage != "" {
+		event.Message += fmtc.Sprintf(", usage price: %s (%s)", priceMessage, priceMessageComment)
+		event.Cost = getFarmUsagePrice(farmState)
+	}
+
+	sendNotification(p, event)
+}
+
+// getPrettyTTL return TTL (in minutes) as string
+func getPrettyTTL(ttl int64) string {
+	if ttl <= 0 {
+		return "disabled"
+	}
+
+	return timeutil.PrettyDuration(ttl * 60)
+}
+
 // templatesCommand is templates command handler
 func templatesCommand() {
-	templates := fsutil.List(
-		getDataDir(), true,
-		fsutil.ListingFilter{Perms: "DRX"},
-	)
+	templates := getTemplates()
 
 	if len(templates) == 0 {
 		terminal.PrintWarnMessage("No templates found")
 		return
 	}
 
-	sort.Strings(templates)
-
 	fmtutil.Separator(false, "TEMPLATES")
 
 	for _, template := range templates {
@@ -835,21 +1043,53 @@ func templatesCommand() {
 }
 
 // templatesCommand is resources command handler
-func resourcesCommand() {
-	fmtutil.Separator(false, "DROPLETS")
+func resourcesCommand(args []string) {
+	providerName := provider.DIGITALOCEAN
+
+	if len(args) != 0 {
+		providerName = args[0]
+	}
+
+	prov := provider.Get(providerName)
+
+	if prov == nil {
+		terminal.PrintErrorMessage("Provider %s is not supported", providerName)
+		exit(1)
+	}
+
+	// Token is required by providers which fetch sizes and regions info from API
+	var token string
 
-	for _, d := range droplets {
-		di := dropletInfoStorage[d]
-		fmtc.Printf("  {c}%7s{!} $%g/hr {s-}(%s + %g GB Memory + %d GB Disk){!}\n", d,
-			di.Price, pluralize.Pluralize(di.CPU, "CPU", "CPUs"), di.Memory, di.Disk,
+	p, err := prefs.ReadPreferences()
+
+	if err == nil {
+		token = p.Token
+	}
+
+	sizes := prov.Sizes(token)
+
+	fmtutil.Separator(false, "SIZES")
+
+	if len(sizes) == 0 {
+		fmtc.Println("  {s}No info about sizes{!}")
+	}
+
+	for _, size := range sizes {
+		fmtc.Printf("  {c}%7s{!} $%g/hr {s-}(%s + %g GB Memory + %d GB Disk){!}\n", size.Name,
+			size.Price, pluralize.Pluralize(size.CPU, "CPU", "CPUs"), size.Memory, size.Disk,
 		)
 	}
 
+	regions := prov.Regions(token)
+
 	fmtutil.Separator(false, "REGIONS")
 
-	for _, r := range regions {
-		ri := regionInfoStorage[r]
-		fmtc.Printf("  {y}%s{!} %s {s-}(%s){!}\n", r, ri.DCName, ri.RegionName)
+	if len(regions) == 0 {
+		fmtc.Println("  {s}No info about regions{!}")
+	}
+
+	for _, region := range regions {
+		fmtc.Printf("  {y}%s{!} %s {s-}(%s){!}\n", region.Name, region.DCName, region.RegionName)
 	}
 
 	fmtutil.Separator(false)
@@ -862,7 +1102,9 @@ func prolongCommand(args []string) {
 		exit(1)
 	}
 
-	if !isMonitorActive() {
+	monitorActive := isMonitorActive()
+
+	if !monitorActive && !isRemoteMonitor() {
 		terminal.PrintWarnMessage("Monitor does not works")
 		exit(1)
 	}
@@ -879,6 +1121,7 @@ func prolongCommand(args []string) {
 
 		if ttl == 0 {
 			terminal.PrintErrorMessage("Incorrect ttl property")
+			exit(1)
 		}
 	}
 
@@ -887,6 +1130,7 @@ func prolongCommand(args []string) {
 
 		if maxWait == 0 {
 			terminal.PrintErrorMessage("Incorrect max-wait property")
+			exit(1)
 		}
 	}
 
@@ -909,183 +1153,251 @@ func prolongCommand(args []string) {
 		)
 	}
 
-	yes, err := terminal.ReadAnswer(answer, "y")
+	if !options.GetB(OPT_FORCE) {
+		yes, err := terminal.ReadAnswer(answer, "y")
+
+		if !yes || err != nil {
+			fmtc.NewLine()
+			cancelAudit()
+			return
+		}
 
-	if !yes || err != nil {
 		fmtc.NewLine()
-		return
 	}
 
-	fmtc.NewLine()
+	fmtc.Printf("Updating monitor state... ")
 
-	farmState, err := readFarmState()
+	var (
+		monitorState *MonitorState
+		err          error
+	)
+
+	if monitorActive {
+		monitorState, err = sendMonitorCommand(&MonitorRequest{
+			Command: MONITOR_CMD_PROLONG,
+			TTL:     ttl * 60,
+			MaxWait: maxWait * 60,
+		})
+	} else {
+		// Monitor works on another host and will get new state from backend
+		monitorState, err = prolongRemoteMonitor(ttl*60, maxWait*60)
+	}
 
 	if err != nil {
 		terminal.PrintErrorMessage("ERROR\n")
-		terminal.PrintErrorMessage("Can't read farm state file: %v\n", err)
+		terminal.PrintErrorMessage("Can't prolong farm TTL: %v\n", err)
 		exit(1)
 	}
 
-	monitorState, err := readMonitorState()
+	fmtc.Println("{g}DONE{!}")
 
-	if err != nil {
-		terminal.PrintErrorMessage("ERROR\n")
-		terminal.PrintErrorMessage("Can't read monitor state file: %v\n", err)
+	fmtc.Printf(
+		"\nFarm will be destroyed after {*}%s{!}\n\n",
+		timeutil.Format(time.Unix(monitorState.DestroyAfter, 0), "%Y/%m/%d %H:%M:%S"),
+	)
+}
+
+// monitorCommand is monitor command handler
+func monitorCommand(args []string) {
+	if len(args) == 0 {
+		terminal.PrintErrorMessage("You must provide monitor command")
 		exit(1)
 	}
 
-	fmtc.Printf("Stopping monitor process... ")
-
-	err = killMonitorProcess()
+	switch args[0] {
+	case "install":
+		installMonitorCommand()
+		return
+	case "uninstall":
+		uninstallMonitorCommand()
+		return
+	}
 
-	if err != nil {
-		terminal.PrintErrorMessage("ERROR\n")
-		terminal.PrintErrorMessage("Can't stop monitor process: %v\n", err)
+	if !isMonitorActive() {
+		terminal.PrintWarnMessage("Monitor does not works")
 		exit(1)
-	} else {
-		fmtc.Println("{g}DONE{!}")
 	}
 
-	fmtc.Printf("Updating farm state... ")
+	request := &MonitorRequest{}
+
+	switch args[0] {
+	case MONITOR_CMD_STATUS, MONITOR_CMD_PAUSE, MONITOR_CMD_RESUME:
+		request.Command = args[0]
+
+	case MONITOR_CMD_DESTROY_NOW:
+		if !options.GetB(OPT_FORCE) {
+			yes, err := terminal.ReadAnswer("Destroy farm right now?", "n")
+
+			if !yes || err != nil {
+				return
+			}
+		}
+
+		request.Command = MONITOR_CMD_DESTROY_NOW
+
+	case MONITOR_CMD_SET_MAX_WAIT, "max-wait":
+		if len(args) < 2 {
+			terminal.PrintErrorMessage("You must provide max wait time")
+			exit(1)
+		}
+
+		request.Command = MONITOR_CMD_SET_MAX_WAIT
+		request.MaxWait = timeutil.ParseDuration(args[1])
 
-	farmState.Preferences.TTL += ttl
+		if request.MaxWait == 0 && args[1] != "0" {
+			terminal.PrintErrorMessage("Incorrect max-wait property")
+			exit(1)
+		}
 
-	if maxWait != 0 {
-		farmState.Preferences.MaxWait = maxWait
+	default:
+		terminal.PrintErrorMessage("Unknown monitor command %s", args[0])
+		exit(1)
 	}
 
-	err = updateFarmState(farmState)
+	state, err := sendMonitorCommand(request)
 
 	if err != nil {
-		terminal.PrintErrorMessage("ERROR\n")
-		terminal.PrintErrorMessage("Can't save farm state: %v\n", err)
+		terminal.PrintErrorMessage(err.Error())
 		exit(1)
-	} else {
-		fmtc.Println("{g}DONE{!}")
 	}
 
-	fmtc.Printf("Updating monitor state... ")
-
-	monitorState.DestroyAfter += (ttl * 60)
+	printMonitorState(state)
+}
 
-	if maxWait != 0 {
-		monitorState.MaxWait = maxWait * 60
-	}
+// installMonitorCommand install systemd user unit for monitor
+func installMonitorCommand() {
+	fmtc.Printf("Installing monitor unit... ")
 
-	err = saveMonitorState(monitorState)
+	err := installMonitorUnit()
 
 	if err != nil {
 		terminal.PrintErrorMessage("ERROR\n")
-		terminal.PrintErrorMessage("Can't save monitoring state: %v\n", err)
+		terminal.PrintErrorMessage("Can't install monitor unit: %v\n", err)
 		exit(1)
-	} else {
-		fmtc.Println("{g}DONE{!}")
 	}
 
-	fmtc.Printf("Starting monitoring process... ")
+	fmtc.Println("{g}DONE{!}")
+
+	fmtc.Printf("Enabling lingering for user services... ")
 
-	err = startMonitorProcess(true)
+	err = enableUserLinger()
 
 	if err != nil {
-		terminal.PrintErrorMessage("ERROR\n")
-		terminal.PrintErrorMessage("Can't start monitoring process: %v\n", err)
-		exit(1)
+		fmtc.Println("{y}SKIPPED{!}")
+		terminal.PrintWarnMessage("Monitor will be stopped after logout: %v", err)
 	} else {
 		fmtc.Println("{g}DONE{!}")
 	}
 
-	fmtc.NewLine()
-}
+	monitorState, err := readMonitorState()
 
-// doctorCommand fix problems with farm
-func doctorCommand(p *prefs.Preferences) {
-	fmtc.Println("\nThis command can solve almost all problems that can occur with farm.")
-	fmtc.Println("This is list of actions which will be performed:\n")
+	if err == nil && monitorState.Supervisor == "" && isMonitorActive() {
+		fmtc.Printf("Restarting monitor under systemd... ")
 
-	fmtc.Println(" - Terrafarm monitor will be stopped")
-	fmtc.Println(" - Terraform state file will be removed")
-	fmtc.Println(" - Terrafarm state file will be removed")
-	fmtc.Println(" - All droplets with prefix \"terrafarm\" will be destroyed\n")
+		killMonitorProcess()
 
-	yes, err := terminal.ReadAnswer("Perform dry run of this actions?", "n")
+		// Wait until old monitor process stops
+		for i := 0; i < 40 && isMonitorActive(); i++ {
+			time.Sleep(125 * time.Millisecond)
+		}
 
-	if !yes || err != nil {
-		fmtc.NewLine()
-		return
+		err = startMonitorProcess()
+
+		if err != nil {
+			terminal.PrintErrorMessage("ERROR\n")
+			terminal.PrintErrorMessage("Can't start monitor: %v\n", err)
+			exit(1)
+		}
+
+		fmtc.Println("{g}DONE{!}")
 	}
 
-	fmtc.NewLine()
+	if envMap[prefs.EV_TOKEN] != "" {
+		terminal.PrintWarnMessage(
+			"Supervised monitor doesn't inherit environment variables, make sure that token is defined in preferences file",
+		)
+	}
+}
 
-	terraformStateFile := getTerraformStateFilePath()
-	terrafarmStateFile := getFarmStateFilePath()
+// uninstallMonitorCommand remove systemd user unit for monitor
+func uninstallMonitorCommand() {
+	fmtc.Printf("Removing monitor unit... ")
 
-	terrafarmDroplets, err := do.GetTerrafarmDropletsList(p.Token)
+	err := uninstallMonitorUnit()
 
 	if err != nil {
-		terminal.PrintErrorMessage(err.Error())
+		terminal.PrintErrorMessage("ERROR\n")
+		terminal.PrintErrorMessage("Can't remove monitor unit: %v\n", err)
 		exit(1)
 	}
 
-	fmtc.Println("  Terrafarm monitor stoppped")
-	fmtc.Printf("  File %s removed\n", terraformStateFile)
-	fmtc.Printf("  File %s removed\n", terrafarmStateFile)
-
-	for dropletName, dropletID := range terrafarmDroplets {
-		fmtc.Printf("  Droplet %s {s-}(ID: %d){!} destroyed\n", dropletName, dropletID)
-	}
+	fmtc.Println("{g}DONE{!}")
+}
 
-	fmtc.NewLine()
+// printMonitorState print info about monitor state
+func printMonitorState(state *MonitorState) {
+	fmtutil.Separator(false, "MONITOR")
 
-	yes, err = terminal.ReadAnswer("Perform this actions?", "n")
+	fmtc.Printf("  {*}%-16s{!} %d\n", "PID:", state.Pid)
 
-	if !yes || err != nil {
-		fmtc.NewLine()
-		return
+	if state.Paused {
+		fmtc.Printf("  {*}%-16s{!} {y}paused{!}\n", "State:")
+	} else {
+		fmtc.Printf("  {*}%-16s{!} {g}works{!}\n", "State:")
 	}
 
-	fmtc.NewLine()
-
-	printErrorStatusMarker(killMonitorProcess())
-	fmtc.Println("Terrafarm monitor stoppped")
-
-	printErrorStatusMarker(os.Remove(terraformStateFile))
-	fmtc.Printf("File %s removed\n", terraformStateFile)
+	if state.Supervisor != "" {
+		fmtc.Printf("  {*}%-16s{!} %s\n", "Supervisor:", state.Supervisor)
+	}
 
-	printErrorStatusMarker(os.Remove(terrafarmStateFile))
-	fmtc.Printf("File %s removed\n", terrafarmStateFile)
+	fmtc.Printf(
+		"  {*}%-16s{!} %s\n", "Destroy after:",
+		timeutil.Format(time.Unix(state.DestroyAfter, 0), "%Y/%m/%d %H:%M:%S"),
+	)
 
-	printErrorStatusMarker(do.DestroyTerrafarmDroplets(p.Token))
-	fmtc.Println("Terrafarm droplets destroyed")
+	if state.MaxWait > 0 {
+		fmtc.Printf("  {*}%-16s{!} %s\n", "Max wait:", timeutil.PrettyDuration(state.MaxWait))
+	} else {
+		fmtc.Printf("  {*}%-16s{!} {s}disabled{!}\n", "Max wait:")
+	}
 
-	fmtc.NewLine()
+	fmtutil.Separator(false)
 }
 
-// saveFarmState collect and save farm state into file
-func saveState(p *prefs.Preferences, farmStartTime int64) {
+// saveState collect and save farm state into file
+func saveState(p *prefs.Preferences, farmStartTime int64, fromSnapshot bool) *FarmState {
 	farmState := &FarmState{
-		Preferences: p,
-		Started:     farmStartTime,
+		Preferences:  p,
+		Account:      p.Account,
+		Provider:     getTemplateProviderName(p.Template),
+		Started:      farmStartTime,
+		FromSnapshot: fromSnapshot,
 	}
 
+	fillFarmNodes(p.Token, farmState, farmStartTime)
+
 	farmState.Preferences.Token = getMaskedToken(p.Token)
 	farmState.Preferences.Password = ""
+	farmState.Preferences.NotifySMTPPassword = ""
 
 	err := saveFarmState(farmState)
 
 	if err != nil {
 		fmtc.Printf("Can't save farm state: %v\n", err)
 	}
+
+	return farmState
 }
 
 // printValidationMarker print validation mark
-func printValidationMarker(value do.StatusCode, disableValidate, newLine bool) {
+func printValidationMarker(value provider.StatusCode, disableValidate, newLine bool) {
 	if !disableValidate {
 		switch {
-		case value == do.STATUS_OK:
+		case value == provider.STATUS_OK:
 			fmtc.Printf(" {g}✔ {!}")
-		case value == do.STATUS_NOT_OK:
+		case value == provider.STATUS_NOT_OK:
 			fmtc.Printf(" {r}✘ {!}")
-		case value == do.STATUS_ERROR:
+		case value == provider.STATUS_ERROR:
 			fmtc.Printf(" {y*}? {!}")
 		}
 	}
@@ -1124,9 +1436,7 @@ func getMaskedToken(token string) string {
 }
 
 // getBuildBullets return colored string with bullets
-func getBuildBullets(p *prefs.Preferences) string {
-	nodes := getBuildNodesInfo(p)
-
+func getBuildBullets(nodes []*NodeInfo) string {
 	if len(nodes) == 0 {
 		return "{y}unknown{!}"
 	}
@@ -1149,8 +1459,96 @@ func getBuildBullets(p *prefs.Preferences) string {
 	return result
 }
 
+// printNodesMetrics print table with build nodes metrics
+func printNodesMetrics(nodes []*NodeInfo) {
+	fmtutil.Separator(false, "NODES")
+
+	if len(nodes) == 0 {
+		fmtc.Println("  {y}Can't collect info about build nodes{!}")
+		return
+	}
+
+	fmtc.Printf(
+		"  {s}%-20s %-15s %-8s %-16s %-22s %-22s %-8s %s{!}\n",
+		"NAME", "IP", "STATE", "LOAD", "MEMORY", "DISK FREE", "UPTIME", "BUILD",
+	)
+
+	for _, node := range nodes {
+		fmtc.Printf("  {*}%-20s{!} %-15s ", node.Name, node.IP)
+
+		switch node.State {
+		case STATE_ACTIVE:
+			fmtc.Printf("{g}%-8s{!} ", "build")
+		case STATE_INACTIVE:
+			fmtc.Printf("{s}%-8s{!} ", "idle")
+		default:
+			fmtc.Printf("{r}%-8s{!}\n", "down")
+			continue
+		}
+
+		fmtc.Printf(
+			"%-16s %-22s ",
+			fmtc.Sprintf("%.2f %.2f %.2f", node.Load[0], node.Load[1], node.Load[2]),
+			fmtutil.PrettySize(node.MemUsed)+" / "+fmtutil.PrettySize(node.MemTotal),
+		)
+
+		diskInfo := fmtutil.PrettySize(node.DiskFree) + " / " + fmtutil.PrettySize(node.DiskTotal)
+
+		if isLowDiskSpace(node) {
+			fmtc.Printf("{r}%-22s{!} ", diskInfo)
+		} else {
+			fmtc.Printf("%-22s ", diskInfo)
+		}
+
+		fmtc.Printf("%-8s ", getShortDuration(node.Uptime))
+
+		if node.Build == "" {
+			fmtc.Println("{s-}—{!}")
+		} else {
+			fmtc.Printf("{c}%s{!}\n", node.Build)
+		}
+	}
+}
+
+// isLowDiskSpace return true if node have less than 10%
+// or less than 2 GB of free disk space
+func isLowDiskSpace(node *NodeInfo) bool {
+	if node.DiskTotal == 0 {
+		return false
+	}
+
+	if node.DiskFree < 2*1024*1024*1024 {
+		return true
+	}
+
+	return float64(node.DiskFree)/float64(node.DiskTotal) < 0.1
+}
+
+// getShortDuration return short duration string (like 3d 4h)
+func getShortDuration(seconds int64) string {
+	var (
+		days    = seconds / 86400
+		hours   = (seconds % 86400) / 3600
+		minutes = (seconds % 3600) / 60
+	)
+
+	switch {
+	case days != 0:
+		return fmtc.Sprintf("%dd %dh", days, hours)
+	case hours != 0:
+		return fmtc.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmtc.Sprintf("%dm", minutes)
+	}
+}
+
 // prefsToArgs return preferences as command line arguments for terraform
 func prefsToArgs(p *prefs.Preferences, args ...string) ([]string, error) {
+	return getTerraformArgs(p, getTerraformStateFilePath(), args...)
+}
+
+// getTerraformArgs return arguments for terraform with given state file
+func getTerraformArgs(p *prefs.Preferences, stateFile string, args ...string) ([]string, error) {
 	varsData, err := p.GetVariablesData()
 
 	if err != nil {
@@ -1171,7 +1569,7 @@ func prefsToArgs(p *prefs.Preferences, args ...string) ([]string, error) {
 
 	varsSlice := []string{
 		fmtc.Sprintf("-var-file=%s", varsFile),
-		fmtc.Sprintf("-state=%s", getTerraformStateFilePath()),
+		fmtc.Sprintf("-state=%s", stateFile),
 	}
 
 	if len(args) != 0 {
@@ -1244,6 +1642,9 @@ func execTerraform(logOutput bool, command string, args []string) error {
 
 	err = cmd.Wait()
 
+	// Terraform state must be saved even if command failed
+	pushStateFile(TERRAFORM_STATE_FILE)
+
 	if err != nil {
 		return fmtc.Errorf(stderrBuffer.String())
 	}
@@ -1253,19 +1654,57 @@ func execTerraform(logOutput bool, command string, args []string) error {
 
 // getPreferencies
 func getPreferences() *prefs.Preferences {
-	p, errs := prefs.FindAndReadPreferences(getDataDir())
+	return getTemplatePreferences("")
+}
+
+// getTemplatePreferences read and validate preferences for given template,
+// if farm is created, credentials of account used for farm creation will
+// be used
+func getTemplatePreferences(template string) *prefs.Preferences {
+	p, errs := readTemplatePreferences(template)
 
 	if len(errs) != 0 {
 		for _, err := range errs {
 			terminal.PrintErrorMessage(err.Error())
 		}
 
-		os.Exit(1)
+		exit(1)
 	}
 
 	return p
 }
 
+// readTemplatePreferences read and validate preferences for given template
+// or for created farm
+func readTemplatePreferences(template string) (*prefs.Preferences, []error) {
+	farmState, err := readFarmState()
+
+	if err == nil {
+		// Template of created farm is required for validation, because
+		// local templates don't require token
+		if template == "" && farmState.Preferences != nil {
+			template = farmState.Preferences.Template
+		}
+
+		return prefs.FindAndReadFarmPreferences(getDataDir(), template, farmState.Account)
+	}
+
+	return prefs.FindAndReadPreferences(getDataDir(), template)
+}
+
+// getCommandTemplate return template name defined by --template option
+// or first command argument
+func getCommandTemplate(args []string) string {
+	switch {
+	case options.Has(OPT_TEMPLATE):
+		return options.GetS(OPT_TEMPLATE)
+	case len(args) != 0:
+		return args[0]
+	}
+
+	return ""
+}
+
 func validatePreferences(p *prefs.Preferences) {
 	errs := p.Validate(getDataDir(), false)
 
@@ -1274,7 +1713,7 @@ func validatePreferences(p *prefs.Preferences) {
 			terminal.PrintErrorMessage(err.Error())
 		}
 
-		os.Exit(1)
+		exit(1)
 	}
 }
 
@@ -1309,24 +1748,25 @@ func getColoredCommandOutput(colorStore map[string]string, line string) string {
 
 // getUsagePriceMessage return message with usage price
 func getUsagePriceMessage() (string, string) {
-	if !isMonitorActive() {
-		return "", ""
-	}
-
 	farmState, err := readFarmState()
 
 	if err != nil {
 		return "", ""
 	}
 
-	buildersTotal := getBuildNodesCount(farmState.Preferences.Template)
-	usageHours := time.Since(time.Unix(farmState.Started, 0)).Hours()
+	buildersTotal := getFarmNodesCount(farmState)
 	usageMinutes := int(time.Since(time.Unix(farmState.Started, 0)).Minutes())
-	currentUsagePrice := (usageHours * dropletInfoStorage[farmState.Preferences.NodeSize].Price) * float64(buildersTotal)
-	currentUsagePrice = mathutil.BetweenF(currentUsagePrice, 0.01, 1000000.0)
+	currentUsagePrice := getFarmUsagePrice(farmState)
 
-	switch buildersTotal {
-	case 1:
+	switch {
+	case len(farmState.Nodes) != 0:
+		return fmtc.Sprintf("~ $%.2f", currentUsagePrice),
+			fmtc.Sprintf(
+				"%s, %s",
+				pluralize.Pluralize(len(farmState.Nodes), "node", "nodes"),
+				pluralize.Pluralize(int(getFarmBilledHours(farmState)), "billed hour", "billed hours"),
+			)
+	case buildersTotal == 1:
 		return fmtc.Sprintf("~ $%.2f", currentUsagePrice),
 			fmtc.Sprintf("%s × %d min", farmState.Preferences.NodeSize, usageMinutes)
 	default:
@@ -1335,14 +1775,50 @@ func getUsagePriceMessage() (string, string) {
 	}
 }
 
+// getFarmUsagePrice return current farm usage price
+func getFarmUsagePrice(farmState *FarmState) float64 {
+	var currentUsagePrice float64
+
+	if len(farmState.Nodes) == 0 {
+		buildersTotal := getBuildNodesCount(farmState.Preferences.Template)
+		usageHours := time.Since(time.Unix(farmState.Started, 0)).Hours()
+		currentUsagePrice = (usageHours * getSizePrice(getFarmProvider(farmState), "", farmState.Preferences.NodeSize)) * float64(buildersTotal)
+	} else {
+		for _, node := range farmState.Nodes {
+			currentUsagePrice += getNodeUsagePrice(farmState, node)
+		}
+	}
+
+	return mathutil.BetweenF(currentUsagePrice, 0.01, 1000000.0)
+}
+
+// getFarmNodesCount return number of working nodes in farm
+func getFarmNodesCount(farmState *FarmState) int {
+	if len(farmState.Nodes) == 0 {
+		return getBuildNodesCount(farmState.Preferences.Template)
+	}
+
+	var result int
+
+	for _, node := range farmState.Nodes {
+		if node.Destroyed == 0 {
+			result++
+		}
+	}
+
+	return result
+}
+
 // calculateUsagePrice calculate usage price
-func calculateUsagePrice(time int64, nodeNum int, nodeSize string) float64 {
-	if dropletInfoStorage[nodeSize].Price == 0.0 {
+func calculateUsagePrice(prov provider.Provider, token string, time int64, nodeNum int, nodeSize string) float64 {
+	sizePrice := getSizePrice(prov, token, nodeSize)
+
+	if sizePrice == 0.0 {
 		return 0.0
 	}
 
 	hours := float64(time) / 60.0
-	price := (hours * dropletInfoStorage[nodeSize].Price) * float64(nodeNum)
+	price := (hours * sizePrice) * float64(nodeNum)
 	price = mathutil.BetweenF(price, 0.01, 1000000.0)
 
 	return price
@@ -1362,7 +1838,19 @@ func isTerrafarmActive() bool {
 		return true
 	}
 
-	return len(tfState.Modules[0].Resources) != 0
+	return len(getNodeResources(tfState)) != 0
+}
+
+// getTemplates return sorted list of farm templates
+func getTemplates() []string {
+	templates := fsutil.List(
+		getDataDir(), true,
+		fsutil.ListingFilter{Perms: "DRX"},
+	)
+
+	sort.Strings(templates)
+
+	return templates
 }
 
 // getBuildNodesCount return number of nodes in given farm template
@@ -1405,29 +1893,30 @@ func getFarmStateFilePath() string {
 
 // deleteFarmStateFile remote farm state file
 func deleteFarmStateFile() error {
-	return os.Remove(getFarmStateFilePath())
+	err := os.Remove(getFarmStateFilePath())
+
+	pushStateFile(FARM_STATE_FILE)
+
+	return err
 }
 
 // saveFarmState save farm state to file
 func saveFarmState(state *FarmState) error {
-	return jsonutil.EncodeToFile(getFarmStateFilePath(), state)
+	err := encodeToFileAtomic(getFarmStateFilePath(), state)
+
+	pushStateFile(FARM_STATE_FILE)
+
+	return err
 }
 
 // updateState update farm state file
 func updateFarmState(state *FarmState) error {
-	err := deleteFarmStateFile()
-
-	if err != nil {
-		return err
-	}
-
-	err = saveFarmState(state)
-
-	if err != nil {
-		fmtc.Errorf("Can't save farm state: %v", err)
+	if !fsutil.IsExist(getFarmStateFilePath()) {
+		return fmtc.Errorf("Farm state file is not exist")
 	}
 
-	return nil
+	// State file is replaced atomically, so readers never see empty file
+	return saveFarmState(state)
 }
 
 // readFarmState read farm state from file
@@ -1456,30 +1945,49 @@ func collectNodesInfo(p *prefs.Preferences) ([]*NodeInfo, error) {
 		return nil, fmtc.Errorf("Can't read state file: %v", err)
 	}
 
-	if len(tfState.Modules) == 0 || len(tfState.Modules[0].Resources) == 0 {
+	nodeResources := getNodeResources(tfState)
+
+	if len(nodeResources) == 0 {
 		return nil, nil
 	}
 
 	var result []*NodeInfo
 
-	for _, node := range tfState.Modules[0].Resources {
+	for _, node := range nodeResources {
 		if node.Info == nil || node.Info.Attributes == nil {
 			continue
 		}
 
+		ip, port := node.Info.Attributes.IP, 22
+
+		// Local containers don't have public IP, SSH port of container
+		// is mapped to port on local machine
+		if ip == "" {
+			ip = node.Info.Attributes.ContainerIP
+			sshPort, _ := strconv.Atoi(node.Info.Attributes.SSHPort)
+
+			if sshPort != 0 {
+				ip, port = "127.0.0.1", sshPort
+			}
+		}
+
 		node := &NodeInfo{
 			Name:     node.Info.Attributes.Name,
-			IP:       node.Info.Attributes.IP,
+			IP:       ip,
+			Port:     port,
 			User:     p.User,
 			Password: p.Password,
 			State:    STATE_UNKNOWN,
 		}
 
+		// Nodes added by scale command have numeric suffix
+		baseName := scaledNodeSuffix.ReplaceAllString(node.Name, "")
+
 		switch {
-		case strings.HasSuffix(node.Name, "-x32"):
+		case strings.HasSuffix(baseName, "-x32"):
 			node.Arch = "i386"
 
-		case strings.HasSuffix(node.Name, "-x48"):
+		case strings.HasSuffix(baseName, "-x48"):
 			node.Arch = "i686"
 		}
 
@@ -1491,6 +1999,28 @@ func collectNodesInfo(p *prefs.Preferences) ([]*NodeInfo, error) {
 	return result, nil
 }
 
+// getStateNodes return map droplet name -> terraform resource name for
+// all droplets in terraform state
+func getStateNodes() map[string]string {
+	result := make(map[string]string)
+
+	tfState, err := terraform.ReadState(getTerraformStateFilePath())
+
+	if err != nil || len(tfState.Modules) == 0 {
+		return result
+	}
+
+	for address, resource := range getNodeResources(tfState) {
+		if resource.Info == nil || resource.Info.Attributes == nil {
+			continue
+		}
+
+		result[resource.Info.Attributes.Name] = address[strings.Index(address, ".")+1:]
+	}
+
+	return result
+}
+
 // printNodesInfo collect and print info about build nodes
 func printNodesInfo(p *prefs.Preferences) {
 	nodesInfo, err := collectNodesInfo(p)
@@ -1502,8 +2032,8 @@ func printNodesInfo(p *prefs.Preferences) {
 
 	for _, node := range nodesInfo {
 		fmtc.Printf(
-			"  {*}%20s{!}: ssh %s@%s {s-}(Password: %s){!}\n",
-			node.Name, node.User, node.IP, node.Password,
+			"  {*}%20s{!}: %s {s-}(Password: %s){!}\n",
+			node.Name, getSSHCommand(node.User, node.IP, node.Port), node.Password,
 		)
 	}
 }
@@ -1547,21 +2077,6 @@ func exportNodeList(p *prefs.Preferences) error {
 	return nil
 }
 
-// isDropletAvailable check availability of droplet in given region
-func isDropletAvailable(name, region string) bool {
-	info := dropletInfoStorage[name]
-
-	if info.Price == 0 {
-		return false
-	}
-
-	if info.Regions == nil {
-		return true
-	}
-
-	return sliceutil.Contains(info.Regions, region)
-}
-
 // getSpellcheckModel return spellcheck model for correcting
 // given command name
 func getSpellcheckModel() *spellcheck.Model {
@@ -1569,7 +2084,9 @@ func getSpellcheckModel() *spellcheck.Model {
 		CMD_APPLY, CMD_CREATE, CMD_DELETE, CMD_DESTROY,
 		CMD_DOCTOR, CMD_INFO, CMD_PROLONG, CMD_START,
 		CMD_STATE, CMD_STATUS, CMD_STOP, CMD_TEMPLATES,
-		CMD_RESOURCES,
+		CMD_RESOURCES, CMD_WATCH, CMD_MONITOR, CMD_IMAGE,
+		CMD_SCALE, CMD_IMPORT, CMD_FORCE_UNLOCK, CMD_SERVE,
+		CMD_AUDIT,
 	})
 }
 
@@ -1636,6 +2153,9 @@ func printDebug(message string, args ...interface{}) {
 
 // exit exit from app with given code
 func exit(code int) {
+	finishAudit(code)
+	unlockState()
+
 	if !options.GetB(OPT_DEBUG) {
 		temp.Clean()
 	}
@@ -1654,10 +2174,18 @@ func showUsage() {
 	info.AddCommand(CMD_CREATE, "Create and run farm droplets on DigitalOcean", "?template-name")
 	info.AddCommand(CMD_DESTROY, "Destroy farm droplets on DigitalOcean")
 	info.AddCommand(CMD_STATUS, "Show current Terrafarm preferences and status")
+	info.AddCommand(CMD_WATCH, "Show live dashboard with farm state and monitor log")
 	info.AddCommand(CMD_TEMPLATES, "List all available farm templates")
-	info.AddCommand(CMD_RESOURCES, "List available resources {s-}(droplets & regions){!}")
+	info.AddCommand(CMD_RESOURCES, "List available resources {s-}(sizes & regions){!}", "?provider")
 	info.AddCommand(CMD_PROLONG, "Increase TTL or set max wait time", "ttl", "?max-wait")
-	info.AddCommand(CMD_DOCTOR, "Fix problems with farm")
+	info.AddCommand(CMD_MONITOR, "Control monitor {s-}(status, pause, resume, destroy-now, max-wait, install, uninstall){!}", "command", "?time")
+	info.AddCommand(CMD_IMAGE, "Manage baked node images {s-}(bake, list, prune){!}", "command", "?template-name")
+	info.AddCommand(CMD_SCALE, "Add or remove nodes of running farm")
+	info.AddCommand(CMD_IMPORT, "Import existing droplets into farm", "?template-name")
+	info.AddCommand(CMD_DOCTOR, "Find and fix problems with farm")
+	info.AddCommand(CMD_FORCE_UNLOCK, "Release state lock", "?lock-id")
+	info.AddCommand(CMD_SERVE, "Start server with HTTP API for team farm management")
+	info.AddCommand(CMD_AUDIT, "Show audit log of farm operations", "?filter")
 
 	info.AddOption(OPT_TTL, "Max farm TTL {s-}(Time To Live){!}", "time")
 	info.AddOption(OPT_MAX_WAIT, "Max time which monitor will wait if farm have active build", "time")
@@ -1668,9 +2196,23 @@ func showUsage() {
 	info.AddOption(OPT_NODE_SIZE, "Droplet size on DigitalOcean", "size")
 	info.AddOption(OPT_USER, "Build node user name", "username")
 	info.AddOption(OPT_PASSWORD, "Build node user password", "password")
+	info.AddOption(OPT_ACCOUNT, "DigitalOcean account name from preferences", "name")
 	info.AddOption(OPT_FORCE, "Force command execution")
 	info.AddOption(OPT_NO_VALIDATE, "Don't validate preferences")
+	info.AddOption(OPT_FOREGROUND, "Run monitor in foreground with logging to stdout")
 	info.AddOption(OPT_NOTIFY, "Ring the system bell after finishing command execution")
+	info.AddOption(OPT_REPO, "Extra repository {s-}(repo package, .repo file or base URL){!}", "url")
+	info.AddOption(OPT_PACKAGE, "Extra package which will be installed on all nodes", "name")
+	info.AddOption(OPT_FILE, "Extra file which will be copied to all nodes", "local:remote")
+	info.AddOption(OPT_ADD, "Nodes which will be added to farm {s-}(scale command){!}", "kind=num")
+	info.AddOption(OPT_REMOVE, "Nodes which will be removed from farm {s-}(scale command){!}", "node")
+	info.AddOption(OPT_TEMPLATE, "Farm template name {s-}(import command){!}", "name")
+	info.AddOption(OPT_SNAPSHOT, "Create farm from baked images {s-}(create command){!}")
+	info.AddOption(OPT_RESUME, "Continue creation of partially created farm {s-}(create command){!}")
+	info.AddOption(OPT_LOCK_TIMEOUT, "Max time of waiting for state lock", "time")
+	info.AddOption(OPT_SERVER, "Execute command through terrafarm server", "url")
+	info.AddOption(OPT_SINCE, "Show audit records for given period {s-}(audit command){!}", "time")
+	info.AddOption(OPT_NODES, "Show table with build nodes metrics {s-}(status command){!}")
 	info.AddOption(OPT_NO_COLOR, "Disable colors in output")
 	info.AddOption(OPT_HELP, "Show this help message")
 	info.AddOption(OPT_VER, "Show version")
@@ -1678,9 +2220,25 @@ func showUsage() {
 	info.AddExample(CMD_CREATE+" --node-size 8gb --ttl 3h", "Create farm with redefined node size and TTL")
 	info.AddExample(CMD_CREATE+" --force", "Forced farm creation (without prompt)")
 	info.AddExample(CMD_CREATE+" c6-multiarch-fast", "Create farm from template c6-multiarch-fast")
+	info.AddExample(CMD_CREATE+" --from-snapshot c7-x64", "Create farm from images baked for template c7-x64")
+	info.AddExample(CMD_CREATE+" --resume", "Continue creation of partially created farm")
+	info.AddExample(CMD_CREATE+" --repo https://dl.fedoraproject.org/pub/epel/epel-release-latest-7.noarch.rpm --package ccache", "Create farm with EPEL repository and ccache package")
+	info.AddExample(CMD_CREATE+" --account oss c7-x64", "Create farm from template c7-x64 using credentials of account oss")
 	info.AddExample(CMD_DESTROY, "Destroy all farm nodes")
 	info.AddExample(CMD_STATUS, "Show info about terrafarm")
+	info.AddExample(CMD_STATUS+" --nodes", "Show info about terrafarm and build nodes metrics")
+	info.AddExample(CMD_WATCH, "Show live dashboard {s-}(press Ctrl+C for exit){!}")
 	info.AddExample(CMD_PROLONG+" 1h 15m", "Increase TTL on 1 hour and set max wait to 15 minutes")
+	info.AddExample(CMD_MONITOR+" max-wait 30m", "Set max wait time to 30 minutes without restarting monitor")
+	info.AddExample(CMD_MONITOR+" install", "Install systemd user unit for monitor")
+	info.AddExample(CMD_SCALE+" --add c7-x64=2 --remove terrafarm-c6-x64", "Add two c7-x64 nodes and remove node terrafarm-c6-x64")
+	info.AddExample(CMD_IMPORT+" --template c7-x64 --ttl 2h", "Import droplets created from template c7-x64 and destroy them in 2 hours")
+	info.AddExample(CMD_DESTROY+" --lock-timeout 5m", "Destroy farm, wait up to 5 minutes if state is locked")
+	info.AddExample(CMD_FORCE_UNLOCK+" 4f2a9c1e8b3d7a60", "Release state lock with given ID")
+	info.AddExample(CMD_CREATE+" --server https://farm.example.com:33100 c7-x64", "Create farm from template c7-x64 using terrafarm server")
+	info.AddExample(CMD_AUDIT+" --since 7d destroy", "Show all farm destroys for last 7 days")
+	info.AddExample(CMD_IMAGE+" bake c7-x64", "Bake node images for template c7-x64")
+	info.AddExample(CMD_IMAGE+" prune", "Delete all outdated images")
 
 	info.Render()
 }
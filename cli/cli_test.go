@@ -0,0 +1,88 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func TestLocalFarmPreferencesWithoutToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terrafarm")
+
+	if err != nil {
+		t.Fatalf("Can't create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	dataDir := filepath.Join(dir, "data")
+	key := filepath.Join(dir, "id_rsa")
+
+	writeTestFile(t, filepath.Join(dataDir, "c7-x64-local", "provider.tf"), `provider "docker" {}`)
+	writeTestFile(t, filepath.Join(dataDir, FARM_STATE_FILE), `{"preferences":{"template":"c7-x64-local"},"provider":"local","started":1}`)
+	writeTestFile(t, key, "private")
+	writeTestFile(t, key+".pub", "public")
+
+	defer setTestEnv(EV_DATA, dataDir)()
+	defer setTestEnv(prefs.EV_KEY, key)()
+	defer setTestEnv(prefs.EV_TOKEN, "")()
+
+	// Preferences for destroy, status and prolong commands and for
+	// monitor are read without template name
+	p, errs := readTemplatePreferences("")
+
+	if len(errs) != 0 {
+		t.Fatalf("Preferences for local farm without token must be valid, got %v", errs)
+	}
+
+	if p.Template != "c7-x64-local" {
+		t.Fatalf("Template must be taken from farm state, got %q", p.Template)
+	}
+
+	p = getPreferences()
+
+	if p.Token != "" || p.Template != "c7-x64-local" {
+		t.Fatalf("Unexpected preferences for destroy command: token %q, template %q", p.Token, p.Template)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// writeTestFile create file with given data and all parent directories
+func writeTestFile(t *testing.T, file, data string) {
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+
+	if err == nil {
+		err = ioutil.WriteFile(file, []byte(data), 0600)
+	}
+
+	if err != nil {
+		t.Fatalf("Can't create file %s: %v", file, err)
+	}
+}
+
+// setTestEnv set environment variable for both terrafarm and preferences
+// and return function for restoring previous value
+func setTestEnv(name, value string) func() {
+	prevEnv, prevMap := os.Getenv(name), envMap[name]
+
+	os.Setenv(name, value)
+	envMap[name] = value
+
+	return func() {
+		os.Setenv(name, prevEnv)
+		envMap[name] = prevMap
+	}
+}
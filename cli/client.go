@@ -0,0 +1,267 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/pluralize"
+	"pkg.re/essentialkaos/ek.v9/req"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// serverEngine is HTTP engine for server requests, it has no request
+// timeout because farm creation can take a long time
+var serverEngine = &req.Engine{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// serverCommand execute command through terrafarm server
+func serverCommand(cmd string, args []string) {
+	p, err := prefs.ReadPreferences()
+
+	if err != nil {
+		terminal.PrintErrorMessage(err.Error())
+		exit(1)
+	}
+
+	if p.ServerToken == "" {
+		terminal.PrintErrorMessage("Property %s must be set for working with server", prefs.SERVER_TOKEN)
+		exit(1)
+	}
+
+	cmd = getSpellcheckModel().Correct(cmd)
+
+	serverEngine.SetUserAgent(APP, VER)
+
+	switch cmd {
+	case CMD_CREATE, CMD_APPLY, CMD_START, CMD_CREATE_SHORTCUT:
+		serverCreateCommand(p, args)
+	case CMD_DESTROY, CMD_DELETE, CMD_STOP, CMD_DESTROY_SHORTCUT:
+		serverDestroyCommand(p)
+	case CMD_STATUS, CMD_INFO, CMD_STATE, CMD_STATUS_SHORTCUT:
+		serverStatusCommand(p)
+	case CMD_TEMPLATES, CMD_TEMPLATES_SHORTCUT:
+		serverTemplatesCommand(p)
+	case CMD_PROLONG, CMD_PROLONG_SHORTCUT:
+		serverProlongCommand(p, args)
+	default:
+		terminal.PrintErrorMessage("Command %s is not supported by server", cmd)
+		exit(1)
+	}
+}
+
+// serverCreateCommand create farm through server
+func serverCreateCommand(p *prefs.Preferences, args []string) {
+	request := &ServerRequest{
+		Template: p.Template,
+		TTL:      options.GetS(OPT_TTL),
+		MaxWait:  options.GetS(OPT_MAX_WAIT),
+		NodeSize: options.GetS(OPT_NODE_SIZE),
+		Region:   options.GetS(OPT_REGION),
+	}
+
+	if len(args) != 0 {
+		request.Template = args[0]
+	}
+
+	if request.Template == "" {
+		terminal.PrintErrorMessage("You must define template name")
+		exit(1)
+	}
+
+	fmtc.Printf("Creating farm {*}%s{!} on server, it can take a while...\n\n", request.Template)
+
+	response := sendServerRequest(p, API_CREATE, request)
+
+	printServerOutput(response)
+
+	if response.Error == "" {
+		serverCredentialsCommand(p)
+	}
+}
+
+// serverDestroyCommand destroy farm through server
+func serverDestroyCommand(p *prefs.Preferences) {
+	if !options.GetB(OPT_FORCE) {
+		yes, err := terminal.ReadAnswer("Destroy farm?", "n")
+
+		if !yes || err != nil {
+			fmtc.NewLine()
+			return
+		}
+	}
+
+	printServerOutput(sendServerRequest(p, API_DESTROY, &ServerRequest{}))
+}
+
+// serverProlongCommand prolong farm TTL through server
+func serverProlongCommand(p *prefs.Preferences, args []string) {
+	if len(args) == 0 {
+		terminal.PrintErrorMessage("You must provide prolongation time")
+		exit(1)
+	}
+
+	request := &ServerRequest{TTL: args[0]}
+
+	if len(args) >= 2 {
+		request.MaxWait = args[1]
+	}
+
+	printServerOutput(sendServerRequest(p, API_PROLONG, request))
+}
+
+// serverStatusCommand show farm status from server
+func serverStatusCommand(p *prefs.Preferences) {
+	status := sendServerRequest(p, API_STATUS, nil).Status
+
+	fmtutil.Separator(false, "TERRAFARM")
+
+	fmtc.Printf("  {*}%-16s{!} %s\n", "Server:", options.GetS(OPT_SERVER))
+
+	if status == nil || !status.Active {
+		fmtc.Printf("  {*}%-16s{!} {s}stopped{!}\n", "State:")
+		fmtutil.Separator(false)
+		return
+	}
+
+	fmtc.Printf(
+		"  {*}%-16s{!} %s {s-}(%s){!}\n", "Template:", status.Template,
+		pluralize.Pluralize(status.Nodes, "build node", "build nodes"),
+	)
+
+	if status.Owner != "" {
+		fmtc.Printf("  {*}%-16s{!} %s\n", "Owner:", status.Owner)
+	}
+
+	fmtc.Printf("  {*}%-16s{!} %s\n", "Region:", status.Region)
+	fmtc.Printf("  {*}%-16s{!} %s\n", "Node size:", status.NodeSize)
+	fmtc.Printf("  {*}%-16s{!} {g}works{!}\n", "State:")
+
+	if status.Started != 0 {
+		fmtc.Printf(
+			"  {*}%-16s{!} %s {s-}(~ $%.2f){!}\n", "Working time:",
+			timeutil.PrettyDuration(time.Since(time.Unix(status.Started, 0))), status.Price,
+		)
+	}
+
+	if status.DestroyAfter != 0 {
+		fmtc.Printf(
+			"  {*}%-16s{!} %s\n", "Destroy after:",
+			timeutil.Format(time.Unix(status.DestroyAfter, 0), "%Y/%m/%d %H:%M:%S"),
+		)
+	}
+
+	fmtutil.Separator(false)
+
+	serverCredentialsCommand(p)
+}
+
+// serverTemplatesCommand show templates available on server
+func serverTemplatesCommand(p *prefs.Preferences) {
+	templates := sendServerRequest(p, API_TEMPLATES, nil).Templates
+
+	if len(templates) == 0 {
+		terminal.PrintWarnMessage("No templates found")
+		return
+	}
+
+	fmtutil.Separator(false, "TEMPLATES")
+
+	for _, template := range templates {
+		fmtc.Printf(
+			"  %s {s-}(%s){!}\n", template.Name,
+			pluralize.Pluralize(template.Nodes, "build node", "build nodes"),
+		)
+	}
+
+	fmtutil.Separator(false)
+}
+
+// serverCredentialsCommand show build nodes credentials from server
+func serverCredentialsCommand(p *prefs.Preferences) {
+	nodes := sendServerRequest(p, API_CREDENTIALS, nil).Nodes
+
+	if len(nodes) == 0 {
+		return
+	}
+
+	fmtutil.Separator(false, "BUILD NODES")
+
+	for _, node := range nodes {
+		fmtc.Printf(
+			"  {*}%-24s{!} %s {s-}(password: %s){!}\n",
+			node.Name, getSSHCommand(node.User, node.IP, node.Port), node.Password,
+		)
+	}
+
+	fmtutil.Separator(false)
+}
+
+// sendServerRequest send request to server and return response, exit
+// with error if request failed
+func sendServerRequest(p *prefs.Preferences, method string, request *ServerRequest) *ServerResponse {
+	r := req.Request{
+		Method:      req.GET,
+		URL:         strings.TrimRight(options.GetS(OPT_SERVER), "/") + method,
+		Accept:      req.CONTENT_TYPE_JSON,
+		BearerAuth:  p.ServerToken,
+		AutoDiscard: true,
+	}
+
+	if request != nil {
+		r.Method = req.POST
+		r.Body = request
+		r.ContentType = req.CONTENT_TYPE_JSON
+	}
+
+	resp, err := serverEngine.Do(r)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't send request to server: %v", err)
+		exit(1)
+	}
+
+	response := &ServerResponse{}
+	err = resp.JSON(response)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't decode server response (status code %d): %v", resp.StatusCode, err)
+		exit(1)
+	}
+
+	if response.Error != "" && response.Output == "" {
+		terminal.PrintErrorMessage("Server returned error: %s", response.Error)
+		exit(1)
+	}
+
+	return response
+}
+
+// printServerOutput print output of command executed by server
+func printServerOutput(response *ServerResponse) {
+	if response.Output != "" {
+		// Output can contain braces, so it printed without color tags parsing
+		fmt.Println(strings.TrimRight(response.Output, "\n"))
+	}
+
+	if response.Error != "" {
+		terminal.PrintErrorMessage("Server returned error: %s", response.Error)
+		exit(1)
+	}
+}
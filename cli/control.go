@@ -0,0 +1,300 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fsutil"
+	"pkg.re/essentialkaos/ek.v9/log"
+	"pkg.re/essentialkaos/ek.v9/path"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// MONITOR_SOCKET_FILE is name of monitor control socket
+const MONITOR_SOCKET_FILE = ".monitor.sock"
+
+// List of supported monitor control commands
+const (
+	MONITOR_CMD_STATUS       = "status"
+	MONITOR_CMD_PROLONG      = "prolong"
+	MONITOR_CMD_SET_MAX_WAIT = "set-max-wait"
+	MONITOR_CMD_DESTROY_NOW  = "destroy-now"
+	MONITOR_CMD_PAUSE        = "pause"
+	MONITOR_CMD_RESUME       = "resume"
+)
+
+// MONITOR_SOCKET_TIMEOUT is timeout for communication with monitor
+const MONITOR_SOCKET_TIMEOUT = 5 * time.Second
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// MonitorRequest contains request to monitor control API
+type MonitorRequest struct {
+	Command string `json:"command"`
+	TTL     int64  `json:"ttl,omitempty"`      // Prolongation time in seconds
+	MaxWait int64  `json:"max_wait,omitempty"` // Max wait time in seconds
+}
+
+// MonitorResponse contains response from monitor control API
+type MonitorResponse struct {
+	Error string        `json:"error,omitempty"`
+	State *MonitorState `json:"state,omitempty"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// monitorState is current state of monitor, must be accessed only
+// while holding monitorStateLock
+var monitorState *MonitorState
+
+// monitorStateLock is lock for monitor state
+var monitorStateLock sync.Mutex
+
+// monitorWakeChan is channel used for waking up monitoring loop
+var monitorWakeChan = make(chan bool, 1)
+
+// controlListener is control socket listener
+var controlListener net.Listener
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// startControlServer start listening monitor control socket
+func startControlServer() error {
+	socketFile := getMonitorSocketFilePath()
+
+	if fsutil.IsExist(socketFile) {
+		err := os.Remove(socketFile)
+
+		if err != nil {
+			return fmtc.Errorf("Can't remove stale control socket: %v", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", socketFile)
+
+	if err != nil {
+		return fmtc.Errorf("Can't create control socket: %v", err)
+	}
+
+	err = os.Chmod(socketFile, 0600)
+
+	if err != nil {
+		listener.Close()
+		return fmtc.Errorf("Can't set control socket permissions: %v", err)
+	}
+
+	controlListener = listener
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go handleControlConnection(conn)
+		}
+	}()
+
+	return nil
+}
+
+// stopControlServer stop listening control socket and remove socket file
+func stopControlServer() {
+	if controlListener == nil {
+		return
+	}
+
+	controlListener.Close()
+	os.Remove(getMonitorSocketFilePath())
+}
+
+// handleControlConnection process request from control socket
+func handleControlConnection(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(MONITOR_SOCKET_TIMEOUT))
+
+	request := &MonitorRequest{}
+	response := &MonitorResponse{}
+
+	err := json.NewDecoder(bufio.NewReader(conn)).Decode(request)
+
+	if err != nil {
+		response.Error = fmtc.Sprintf("Can't decode request: %v", err)
+	} else {
+		response.State, err = processControlRequest(request)
+
+		if err != nil {
+			response.Error = err.Error()
+		}
+	}
+
+	json.NewEncoder(conn).Encode(response)
+}
+
+// processControlRequest apply request to monitor state
+func processControlRequest(request *MonitorRequest) (*MonitorState, error) {
+	monitorStateLock.Lock()
+	defer monitorStateLock.Unlock()
+
+	switch request.Command {
+	case MONITOR_CMD_STATUS:
+		// just return current state
+
+	case MONITOR_CMD_PROLONG:
+		if request.TTL <= 0 {
+			return nil, fmtc.Errorf("Prolongation time must be greater than zero")
+		}
+
+		err := prolongFarmState(request.TTL, request.MaxWait)
+
+		if err != nil {
+			return nil, err
+		}
+
+		monitorState.DestroyAfter += request.TTL
+
+		if request.MaxWait > 0 {
+			monitorState.MaxWait = request.MaxWait
+		}
+
+		log.Info(
+			"Farm TTL increased on %d minutes by user request",
+			request.TTL/60,
+		)
+
+	case MONITOR_CMD_SET_MAX_WAIT:
+		if request.MaxWait < 0 {
+			return nil, fmtc.Errorf("Max wait time can't be negative")
+		}
+
+		monitorState.MaxWait = request.MaxWait
+
+		log.Info("Max wait time set to %d minutes by user request", request.MaxWait/60)
+
+	case MONITOR_CMD_DESTROY_NOW:
+		monitorState.DestroyAfter = time.Now().Unix()
+		monitorState.MaxWait = 0
+		monitorState.Paused = false
+
+		log.Info("Got request for immediate farm destroying")
+
+	case MONITOR_CMD_PAUSE:
+		monitorState.Paused = true
+		log.Info("Monitor paused by user request")
+
+	case MONITOR_CMD_RESUME:
+		monitorState.Paused = false
+		log.Info("Monitor resumed by user request")
+
+	default:
+		return nil, fmtc.Errorf("Unknown command %s", request.Command)
+	}
+
+	if request.Command != MONITOR_CMD_STATUS {
+		err := saveMonitorState(monitorState)
+
+		if err != nil {
+			log.Error("Can't save monitor state: %v", err)
+		}
+
+		wakeMonitoringLoop()
+	}
+
+	state := *monitorState
+
+	return &state, nil
+}
+
+// prolongFarmState increase TTL in farm state
+func prolongFarmState(ttl, maxWait int64) error {
+	farmState, err := readFarmState()
+
+	if err != nil {
+		return fmtc.Errorf("Can't read farm state: %v", err)
+	}
+
+	farmState.Preferences.TTL += ttl / 60
+
+	if maxWait > 0 {
+		farmState.Preferences.MaxWait = maxWait / 60
+	}
+
+	err = updateFarmState(farmState)
+
+	if err != nil {
+		return fmtc.Errorf("Can't save farm state: %v", err)
+	}
+
+	return nil
+}
+
+// wakeMonitoringLoop force monitoring loop to check farm state
+func wakeMonitoringLoop() {
+	select {
+	case monitorWakeChan <- true:
+	default:
+	}
+}
+
+// getCurrentMonitorState return copy of current monitor state
+func getCurrentMonitorState() MonitorState {
+	monitorStateLock.Lock()
+	defer monitorStateLock.Unlock()
+
+	return *monitorState
+}
+
+// sendMonitorCommand send command to monitor using control socket
+func sendMonitorCommand(request *MonitorRequest) (*MonitorState, error) {
+	conn, err := net.DialTimeout("unix", getMonitorSocketFilePath(), MONITOR_SOCKET_TIMEOUT)
+
+	if err != nil {
+		return nil, fmtc.Errorf("Can't connect to monitor: %v", err)
+	}
+
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(MONITOR_SOCKET_TIMEOUT))
+
+	err = json.NewEncoder(conn).Encode(request)
+
+	if err != nil {
+		return nil, fmtc.Errorf("Can't send request to monitor: %v", err)
+	}
+
+	response := &MonitorResponse{}
+
+	err = json.NewDecoder(bufio.NewReader(conn)).Decode(response)
+
+	if err != nil {
+		return nil, fmtc.Errorf("Can't decode monitor response: %v", err)
+	}
+
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+
+	return response.State, nil
+}
+
+// getMonitorSocketFilePath return path to monitor control socket
+func getMonitorSocketFilePath() string {
+	return path.Join(getDataDir(), MONITOR_SOCKET_FILE)
+}
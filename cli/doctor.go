@@ -0,0 +1,420 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/fsutil"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/path"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+	"github.com/essentialkaos/terrafarm/provider"
+	"github.com/essentialkaos/terrafarm/terraform"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Diagnosis contains info about found problem and the way to fix it
+type Diagnosis struct {
+	Problem string
+	Fix     string       // Description of fix, empty if problem can't be fixed
+	Action  func() error // Fix action
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// doctorCommand find inconsistencies between local state files and
+// droplets and offer fix for every found problem
+func doctorCommand(p *prefs.Preferences) {
+	diagnoses, err := diagnoseFarm(p)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't check farm: %v", err)
+		exit(1)
+	}
+
+	fmtutil.Separator(false, "DOCTOR")
+
+	if len(diagnoses) == 0 {
+		fmtc.Println("  {g}No problems found{!}")
+		fmtutil.Separator(false)
+		return
+	}
+
+	for index, diagnosis := range diagnoses {
+		fmtc.Printf("  {y}%d.{!} %s\n", index+1, diagnosis.Problem)
+
+		if diagnosis.Fix != "" {
+			fmtc.Printf("     {s-}Fix: %s{!}\n", diagnosis.Fix)
+		}
+	}
+
+	fmtutil.Separator(false)
+
+	for index, diagnosis := range diagnoses {
+		if diagnosis.Action == nil {
+			continue
+		}
+
+		if !options.GetB(OPT_FORCE) {
+			yes, err := terminal.ReadAnswer(
+				fmtc.Sprintf("Fix problem %d (%s)?", index+1, diagnosis.Fix), "n",
+			)
+
+			if !yes || err != nil {
+				fmtc.NewLine()
+				continue
+			}
+		}
+
+		err = diagnosis.Action()
+
+		printErrorStatusMarker(err)
+
+		if err != nil {
+			fmtc.Printf("{r}%v{!}\n\n", err)
+		} else {
+			fmtc.Printf("%s\n\n", diagnosis.Fix)
+		}
+	}
+}
+
+// diagnoseFarm compare terraform state, farm state, monitor state and
+// droplets and return list of found problems
+func diagnoseFarm(p *prefs.Preferences) ([]*Diagnosis, error) {
+	var result []*Diagnosis
+
+	prov := getActiveProvider(p)
+	droplets, err := prov.GetMachines(p.Token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	liveDroplets := make(map[string]*provider.Machine)
+
+	for _, droplet := range droplets {
+		liveDroplets[strconv.Itoa(droplet.ID)] = droplet
+	}
+
+	farmState, _ := readFarmState()
+	stateNodes := getStateResources()
+	workDir := getDoctorWorkDir(p, farmState)
+
+	// Local provider can't list containers, so nodes can be checked
+	// only through terraform
+	checkMachines := prov.Name() != provider.LOCAL
+
+	// Nodes in terraform state without droplets
+	for address, id := range stateNodes {
+		if !checkMachines || liveDroplets[id] != nil {
+			continue
+		}
+
+		result = append(result, &Diagnosis{
+			Problem: fmtc.Sprintf("Node %s (ID: %s) is present in terraform state, but droplet doesn't exist", address, id),
+			Fix:     "Remove node " + address + " from terraform state",
+			Action:  getStateRemoveAction(address),
+		})
+	}
+
+	// Droplets without terraform state
+	for _, droplet := range droplets {
+		if isStateResource(stateNodes, strconv.Itoa(droplet.ID)) {
+			continue
+		}
+
+		diagnosis := &Diagnosis{
+			Problem: fmtc.Sprintf("Droplet %s (ID: %d) is not present in terraform state", droplet.Name, droplet.ID),
+		}
+
+		address := findImportAddress(prov, workDir, stateNodes, droplet.Name)
+
+		if address != "" {
+			diagnosis.Fix = "Import droplet " + droplet.Name + " to terraform state as " + address
+			diagnosis.Action = getImportAction(p, workDir, address, droplet.ID)
+		} else {
+			diagnosis.Fix = "Destroy droplet " + droplet.Name
+			diagnosis.Action = getDestroyDropletAction(prov, p.Token, droplet.ID)
+		}
+
+		result = append(result, diagnosis)
+	}
+
+	farmActive := len(stateNodes) != 0
+
+	switch {
+	case farmActive && farmState == nil:
+		result = append(result, &Diagnosis{
+			Problem: "Terraform state contains nodes, but farm state doesn't exist",
+			Fix:     "Adopt nodes using current preferences",
+			Action:  getAdoptAction(p, droplets),
+		})
+
+	case !farmActive && farmState != nil:
+		result = append(result, &Diagnosis{
+			Problem: "Farm state exists, but terraform state doesn't contain nodes",
+			Fix:     "Remove farm state",
+			Action:  deleteFarmStateFile,
+		})
+	}
+
+	result = append(result, diagnoseMonitor(farmState, farmActive)...)
+
+	if farmState != nil {
+		result = append(result, diagnoseFarmState(p, farmState, liveDroplets, checkMachines)...)
+	}
+
+	if prov.IsFingerprintValid(p.Token, p.Fingerprint) == provider.STATUS_NOT_OK {
+		result = append(result, &Diagnosis{
+			Problem: fmtc.Sprintf("Key with fingerprint %s is not added to %s account", p.Fingerprint, prov.Title()),
+			Fix:     "Add key " + p.Key + ".pub to " + prov.Title() + " account",
+			Action:  getAddKeyAction(prov, p),
+		})
+	}
+
+	return result, nil
+}
+
+// diagnoseMonitor check monitor state
+func diagnoseMonitor(farmState *FarmState, farmActive bool) []*Diagnosis {
+	monitorActive := isMonitorActive()
+	monitorStateExist := fsutil.IsExist(getMonitorStateFilePath())
+
+	// Monitor on another host can't be checked or restarted from this host
+	if !monitorActive && isRemoteMonitor() {
+		return nil
+	}
+
+	switch {
+	case monitorActive && !farmActive:
+		return []*Diagnosis{{
+			Problem: "Monitor works, but farm is not active",
+			Fix:     "Stop monitor",
+			Action:  killMonitorProcess,
+		}}
+
+	case !monitorActive && monitorStateExist && !farmActive:
+		return []*Diagnosis{{
+			Problem: "Monitor state exists, but farm is not active",
+			Fix:     "Remove monitor state",
+			Action:  deleteMonitorStateFile,
+		}}
+
+	case !monitorActive && farmActive && (monitorStateExist || (farmState != nil && farmState.Preferences.TTL > 0)):
+		return []*Diagnosis{{
+			Problem: "Monitor is dead",
+			Fix:     "Restart monitor",
+			Action:  startMonitorProcess,
+		}}
+	}
+
+	return nil
+}
+
+// diagnoseFarmState check farm state
+func diagnoseFarmState(p *prefs.Preferences, farmState *FarmState, liveDroplets map[string]*provider.Machine, checkMachines bool) []*Diagnosis {
+	var result []*Diagnosis
+
+	if farmState.Preferences.Fingerprint != "" && farmState.Preferences.Fingerprint != p.Fingerprint {
+		result = append(result, &Diagnosis{
+			Problem: fmtc.Sprintf(
+				"Farm was created with key %s, but current key is %s",
+				farmState.Preferences.Fingerprint, p.Fingerprint,
+			),
+		})
+	}
+
+	if !checkMachines {
+		return result
+	}
+
+	for _, node := range farmState.Nodes {
+		if node.ID == 0 || node.Destroyed != 0 || liveDroplets[strconv.Itoa(node.ID)] != nil {
+			continue
+		}
+
+		result = append(result, &Diagnosis{
+			Problem: fmtc.Sprintf("Node %s is destroyed, but marked as working in farm state", node.Name),
+			Fix:     "Mark node " + node.Name + " as destroyed",
+			Action:  getMarkDestroyedAction(farmState, node),
+		})
+	}
+
+	return result
+}
+
+// getStateRemoveAction return action for removing node from terraform state
+func getStateRemoveAction(address string) func() error {
+	return func() error {
+		return execTerraform(false, "state", []string{
+			"rm", "-state=" + getTerraformStateFilePath(), address,
+		})
+	}
+}
+
+// getImportAction return action for importing droplet into terraform state
+func getImportAction(p *prefs.Preferences, workDir, address string, id int) func() error {
+	return func() error {
+		vars, err := prefsToArgs(p, address, strconv.Itoa(id))
+
+		if err != nil {
+			return err
+		}
+
+		fsutil.Push(workDir)
+
+		defer fsutil.Pop()
+
+		return execTerraform(false, "import", vars)
+	}
+}
+
+// getDestroyDropletAction return action for destroying droplet
+func getDestroyDropletAction(prov provider.Provider, token string, id int) func() error {
+	return func() error {
+		err := prov.DestroyMachine(token, id)
+
+		// Destroyed droplet is not present in terraform state, so it must
+		// be added to audit record manually
+		if err == nil && auditRecord != nil {
+			auditRecord.Droplets = append(auditRecord.Droplets, strconv.Itoa(id))
+		}
+
+		return err
+	}
+}
+
+// getAddKeyAction return action for adding public key to account
+func getAddKeyAction(prov provider.Provider, p *prefs.Preferences) func() error {
+	return func() error {
+		data, err := ioutil.ReadFile(p.Key + ".pub")
+
+		if err != nil {
+			return err
+		}
+
+		return prov.AddSSHKey(p.Token, "terrafarm", strings.TrimSpace(string(data)))
+	}
+}
+
+// getAdoptAction return action for creating farm state for nodes from
+// terraform state
+func getAdoptAction(p *prefs.Preferences, droplets []*provider.Machine) func() error {
+	return func() error {
+		started := time.Now().Unix()
+
+		for _, droplet := range droplets {
+			created := droplet.Created.Unix()
+
+			if !droplet.Created.IsZero() && created < started {
+				started = created
+			}
+		}
+
+		// Farm state contains masked token, so we use copy of preferences
+		adoptPrefs := *p
+
+		if saveState(&adoptPrefs, started, false) == nil {
+			return fmtc.Errorf("Can't save farm state")
+		}
+
+		return nil
+	}
+}
+
+// getMarkDestroyedAction return action for marking node as destroyed
+func getMarkDestroyedAction(farmState *FarmState, node *FarmNode) func() error {
+	return func() error {
+		node.Destroyed = time.Now().Unix()
+		return updateFarmState(farmState)
+	}
+}
+
+// getStateResources return map resource address -> resource ID for all
+// nodes in terraform state, IDs are kept as is because local containers
+// have hex IDs
+func getStateResources() map[string]string {
+	result := make(map[string]string)
+
+	if !fsutil.IsExist(getTerraformStateFilePath()) {
+		return result
+	}
+
+	tfState, err := terraform.ReadState(getTerraformStateFilePath())
+
+	if err != nil || len(tfState.Modules) == 0 {
+		return result
+	}
+
+	for address, resource := range getNodeResources(tfState) {
+		if resource.Info == nil {
+			continue
+		}
+
+		result[address] = resource.Info.ID
+	}
+
+	return result
+}
+
+// findImportAddress return address of resource in working directory
+// which can be used for importing droplet with given name
+func findImportAddress(prov provider.Provider, workDir string, stateNodes map[string]string, name string) string {
+	if workDir == "" {
+		return ""
+	}
+
+	for builder, resource := range getBuilderConfigs(workDir) {
+		data, err := ioutil.ReadFile(path.Join(workDir, builder))
+
+		if err != nil || terraform.GetAttribute(string(data), "name") != name {
+			continue
+		}
+
+		address := getStateNodeAddress(prov, resource)
+
+		if _, exist := stateNodes[address]; !exist {
+			return address
+		}
+	}
+
+	return ""
+}
+
+// getDoctorWorkDir return path to farm working directory
+func getDoctorWorkDir(p *prefs.Preferences, farmState *FarmState) string {
+	switch {
+	case farmState != nil:
+		return getFarmWorkDir(farmState)
+	case p.Template != "":
+		return getTemplateWorkDir(p.Template)
+	}
+
+	return ""
+}
+
+// isStateResource return true if droplet with given ID is present in
+// terraform state
+func isStateResource(stateNodes map[string]string, id string) bool {
+	for _, nodeID := range stateNodes {
+		if nodeID == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,218 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/path"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ExtrasResult contains info about applying extras to build node
+type ExtrasResult struct {
+	Node  string
+	Error error
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// applyExtras install extra repositories and packages and copy extra files
+// to all build nodes
+func applyExtras(p *prefs.Preferences, nodes []*NodeInfo) {
+	if !hasExtras(p) || len(nodes) == 0 {
+		return
+	}
+
+	sshConfig, err := getSSHConfig(p)
+
+	if err != nil {
+		fmtc.Printf("{r}Can't apply extras: %v{!}\n", err)
+		fmtutil.Separator(false)
+		return
+	}
+
+	fmtc.Println("Installing extra repositories, packages and files...\n")
+
+	resultChan := make(chan *ExtrasResult, len(nodes))
+	wg := &sync.WaitGroup{}
+
+	for _, node := range nodes {
+		wg.Add(1)
+
+		go func(node *NodeInfo) {
+			resultChan <- &ExtrasResult{node.Name, applyNodeExtras(p, node, sshConfig)}
+			wg.Done()
+		}(node)
+	}
+
+	wg.Wait()
+	close(resultChan)
+
+	results := make(map[string]error)
+
+	for result := range resultChan {
+		results[result.Node] = result.Error
+	}
+
+	// Print results in the same order as nodes
+	for _, node := range nodes {
+		if results[node.Name] != nil {
+			fmtc.Printf("  {r}✘ {!} %-24s {r}%v{!}\n", node.Name, results[node.Name])
+		} else {
+			fmtc.Printf("  {g}✔ {!} %s\n", node.Name)
+		}
+	}
+
+	fmtutil.Separator(false)
+}
+
+// applyNodeExtras apply extras to build node
+func applyNodeExtras(p *prefs.Preferences, node *NodeInfo, sshConfig *ssh.ClientConfig) error {
+	client, err := ssh.Dial("tcp", node.SSHAddress(), sshConfig)
+
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	for _, file := range p.ExtraFiles {
+		fileSlice := strings.Split(file, ":")
+
+		err = uploadFile(client, fileSlice[0], fileSlice[1])
+
+		if err != nil {
+			return fmtc.Errorf("Can't copy file %s: %v", fileSlice[0], err)
+		}
+	}
+
+	for index, repo := range p.ExtraRepos {
+		err = runExtrasCommand(client, getRepoInstallCommand(repo, index))
+
+		if err != nil {
+			return fmtc.Errorf("Can't install repository %s: %v", repo, err)
+		}
+	}
+
+	if len(p.ExtraPackages) != 0 {
+		err = runExtrasCommand(client, getPackagesInstallCommand(p.ExtraPackages))
+
+		if err != nil {
+			return fmtc.Errorf("Can't install packages: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// uploadFile upload local file to build node
+func uploadFile(client *ssh.Client, local, remote string) error {
+	fd, err := os.Open(local)
+
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	session, err := client.NewSession()
+
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	session.Stdin = fd
+
+	return session.Run(fmt.Sprintf(
+		"mkdir -p %s && cat > %s",
+		getQuotedArg(path.Dir(remote)), getQuotedArg(remote),
+	))
+}
+
+// runExtrasCommand execute command on build node and return error with
+// last line of output if command failed
+func runExtrasCommand(client *ssh.Client, command string) error {
+	session, err := client.NewSession()
+
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	output, err := session.CombinedOutput(command)
+
+	if err == nil {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+
+	if lines[len(lines)-1] == "" {
+		return err
+	}
+
+	return errors.New(lines[len(lines)-1])
+}
+
+// getRepoInstallCommand return command for installing repository, repository
+// can be defined as repository package, .repo file or base URL
+func getRepoInstallCommand(repo string, index int) string {
+	switch {
+	case strings.HasSuffix(repo, ".rpm"):
+		return getPackagesInstallCommand([]string{repo})
+	case strings.HasSuffix(repo, ".repo"):
+		return fmt.Sprintf(
+			"curl -sS -f -o %s %s",
+			getQuotedArg("/etc/yum.repos.d/"+path.Base(repo)), getQuotedArg(repo),
+		)
+	}
+
+	name := getQuotedArg(fmt.Sprintf("terrafarm-extra-%d", index))
+	file := getQuotedArg(fmt.Sprintf("/etc/yum.repos.d/terrafarm-extra-%d.repo", index))
+
+	return fmt.Sprintf(
+		"printf '[%%s]\\nname=%%s\\nbaseurl=%%s\\nenabled=1\\ngpgcheck=0\\n' %s %s %s > %s",
+		name, name, getQuotedArg(repo), file,
+	)
+}
+
+// getPackagesInstallCommand return command for installing packages
+func getPackagesInstallCommand(packages []string) string {
+	var args []string
+
+	for _, pkg := range packages {
+		args = append(args, getQuotedArg(pkg))
+	}
+
+	return "yum -y -q install " + strings.Join(args, " ")
+}
+
+// getQuotedArg return argument quoted for usage in shell command
+func getQuotedArg(arg string) string {
+	return "'" + strings.Replace(arg, "'", "'\\''", -1) + "'"
+}
+
+// hasExtras return true if extra repos, packages or files are defined
+func hasExtras(p *prefs.Preferences) bool {
+	return len(p.ExtraRepos)+len(p.ExtraPackages)+len(p.ExtraFiles) != 0
+}
@@ -0,0 +1,80 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"os/exec"
+	"testing"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func TestGetRepoInstallCommand(t *testing.T) {
+	tests := []struct {
+		repo     string
+		expected string
+	}{
+		{
+			"https://domain.com/repo.rpm",
+			`yum -y -q install 'https://domain.com/repo.rpm'`,
+		},
+		{
+			"https://domain.com/my repo;reboot.rpm",
+			`yum -y -q install 'https://domain.com/my repo;reboot.rpm'`,
+		},
+		{
+			"https://domain.com/$(reboot).repo",
+			`curl -sS -f -o '/etc/yum.repos.d/$(reboot).repo' 'https://domain.com/$(reboot).repo'`,
+		},
+		{
+			"https://domain.com/it's my.repo",
+			`curl -sS -f -o '/etc/yum.repos.d/it'\''s my.repo' 'https://domain.com/it'\''s my.repo'`,
+		},
+		{
+			"https://domain.com/el7/x86_64/",
+			`printf '[%s]\nname=%s\nbaseurl=%s\nenabled=1\ngpgcheck=0\n' 'terrafarm-extra-1' 'terrafarm-extra-1' 'https://domain.com/el7/x86_64/' > '/etc/yum.repos.d/terrafarm-extra-1.repo'`,
+		},
+		{
+			"https://domain.com/it's here/`reboot`",
+			`printf '[%s]\nname=%s\nbaseurl=%s\nenabled=1\ngpgcheck=0\n' 'terrafarm-extra-1' 'terrafarm-extra-1' 'https://domain.com/it'\''s here/` + "`reboot`" + `' > '/etc/yum.repos.d/terrafarm-extra-1.repo'`,
+		},
+	}
+
+	for _, test := range tests {
+		command := getRepoInstallCommand(test.repo, 1)
+
+		if command != test.expected {
+			t.Errorf("Unexpected command for repo %q:\n  got:  %s\n  want: %s", test.repo, command, test.expected)
+		}
+	}
+}
+
+func TestGetPackagesInstallCommand(t *testing.T) {
+	command := getPackagesInstallCommand([]string{"git", "lib 'x'", "$(reboot)"})
+	expected := `yum -y -q install 'git' 'lib '\''x'\''' '$(reboot)'`
+
+	if command != expected {
+		t.Fatalf("Unexpected command:\n  got:  %s\n  want: %s", command, expected)
+	}
+}
+
+func TestGetQuotedArg(t *testing.T) {
+	args := []string{"", "simple", "with space", "it's", "a;b", "$(reboot)", "`reboot`", `"\n"`}
+
+	for _, arg := range args {
+		output, err := exec.Command("/bin/sh", "-c", "printf '%s' "+getQuotedArg(arg)).Output()
+
+		if err != nil {
+			t.Fatalf("Can't execute command with argument %q: %v", arg, err)
+		}
+
+		if string(output) != arg {
+			t.Errorf("Argument %q is passed to shell as %q", arg, output)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/log"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/path"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DEFAULT_HARVEST_DIR is default path to directory for harvested artifacts
+const DEFAULT_HARVEST_DIR = "~/terrafarm-artifacts"
+
+// DEFAULT_HARVEST_TIMEOUT is default harvesting timeout in minutes
+const DEFAULT_HARVEST_TIMEOUT = 15
+
+// HARVEST_SCRIPT is script used for packing artifacts on build node, home
+// directory and list of patterns must be passed as format arguments
+const HARVEST_SCRIPT = `shopt -s globstar nullglob
+cd %s || exit 1
+for f in %s ; do
+  [[ -f "$f" ]] && printf '%%s\0' "$f"
+done | tar --null -T - -cf - 2>/dev/null
+`
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// HarvestResult contains info about artifacts harvested from build node
+type HarvestResult struct {
+	Node  string
+	Files int
+	Size  int64
+	Error error
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DefaultHarvestPaths is list of paths harvested by default
+var DefaultHarvestPaths = []string{
+	"~/rpmbuild/RPMS/**",
+	"~/rpmbuild/SRPMS/**",
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// harvestArtifacts download artifacts from all build nodes
+func harvestArtifacts(p *prefs.Preferences, nodes []*NodeInfo) {
+	if !p.Harvest || len(nodes) == 0 {
+		return
+	}
+
+	sshConfig, err := getSSHConfig(p)
+
+	if err != nil {
+		printHarvestError("Can't harvest artifacts: %v", err)
+		return
+	}
+
+	targetDir := path.Join(
+		path.Clean(getHarvestDir(p)),
+		timeutil.Format(time.Now(), "%Y%m%d-%H%M%S"),
+	)
+
+	if options.GetB(OPT_MONITOR) {
+		log.Info("Harvesting artifacts from build nodes to %s...", targetDir)
+	} else {
+		fmtc.Printf("Harvesting artifacts from build nodes to {*}%s{!}...\n\n", targetDir)
+	}
+
+	resultChan := make(chan *HarvestResult, len(nodes))
+	wg := &sync.WaitGroup{}
+
+	for _, node := range nodes {
+		wg.Add(1)
+
+		go func(node *NodeInfo) {
+			resultChan <- harvestNodeArtifacts(p, node, sshConfig, path.Join(targetDir, node.Name))
+			wg.Done()
+		}(node)
+	}
+
+	doneChan := make(chan bool)
+
+	go func() {
+		wg.Wait()
+		close(doneChan)
+	}()
+
+	timeout := getHarvestTimeout(p)
+
+	select {
+	case <-doneChan:
+	case <-time.After(timeout):
+		printHarvestError("Harvesting took more than %s and was interrupted", timeout)
+	}
+
+	// Results channel is buffered, so we can safely read all available
+	// results without waiting unfinished goroutines
+	for i := len(resultChan); i > 0; i-- {
+		printHarvestResult(<-resultChan)
+	}
+
+	if !options.GetB(OPT_MONITOR) {
+		fmtutil.Separator(false)
+	}
+}
+
+// harvestNodeArtifacts download artifacts from build node to given directory
+func harvestNodeArtifacts(p *prefs.Preferences, node *NodeInfo, sshConfig *ssh.ClientConfig, dir string) *HarvestResult {
+	result := &HarvestResult{Node: node.Name}
+
+	client, err := ssh.Dial("tcp", node.SSHAddress(), sshConfig)
+
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	defer client.Close()
+
+	session, err := client.NewSession()
+
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	homeDir := "/home/" + p.User
+	script := fmt.Sprintf(HARVEST_SCRIPT, homeDir, getHarvestPatterns(p))
+
+	err = session.Start(script)
+
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	result.Error = unpackArtifacts(stdout, dir, result)
+
+	err = session.Wait()
+
+	if result.Error == nil && err != nil {
+		result.Error = err
+	}
+
+	return result
+}
+
+// unpackArtifacts unpack tar stream with artifacts to given directory
+func unpackArtifacts(r io.Reader, dir string, result *HarvestResult) error {
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		target := path.Join(dir, header.Name)
+
+		// Skip files with paths outside of target directory
+		if !strings.HasPrefix(target, dir+"/") {
+			continue
+		}
+
+		err = os.MkdirAll(path.Dir(target), 0755)
+
+		if err != nil {
+			return err
+		}
+
+		fd, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+
+		if err != nil {
+			return err
+		}
+
+		size, err := io.Copy(fd, tr)
+
+		fd.Close()
+
+		if err != nil {
+			return err
+		}
+
+		result.Files++
+		result.Size += size
+	}
+}
+
+// printHarvestResult print or log info about harvested artifacts
+func printHarvestResult(result *HarvestResult) {
+	switch {
+	case result.Error != nil:
+		printHarvestError("Can't harvest artifacts from %s: %v", result.Node, result.Error)
+
+	case options.GetB(OPT_MONITOR):
+		log.Info(
+			"Harvested %d files (%s) from %s",
+			result.Files, fmtutil.PrettySize(result.Size), result.Node,
+		)
+
+	default:
+		fmtc.Printf(
+			"  {g}✔ {!} %-24s {s-}%d files, %s{!}\n",
+			result.Node, result.Files, fmtutil.PrettySize(result.Size),
+		)
+	}
+}
+
+// printHarvestError print or log harvesting error
+func printHarvestError(message string, args ...interface{}) {
+	if options.GetB(OPT_MONITOR) {
+		log.Error(message, args...)
+	} else {
+		fmtc.Printf("  {r}✘ "+message+"{!}\n", args...)
+	}
+}
+
+// getHarvestPatterns return harvest paths as patterns for shell
+func getHarvestPatterns(p *prefs.Preferences) string {
+	paths := p.HarvestPaths
+
+	if len(paths) == 0 {
+		paths = DefaultHarvestPaths
+	}
+
+	var result []string
+
+	for _, pattern := range paths {
+		// Paths in user home directory must be relative
+		pattern = strings.TrimPrefix(pattern, "~/")
+		pattern = strings.Replace(pattern, " ", "\\ ", -1)
+		result = append(result, pattern)
+	}
+
+	return strings.Join(result, " ")
+}
+
+// getHarvestDir return path to directory for harvested artifacts
+func getHarvestDir(p *prefs.Preferences) string {
+	if p.HarvestDir == "" {
+		return DEFAULT_HARVEST_DIR
+	}
+
+	return p.HarvestDir
+}
+
+// getHarvestTimeout return harvesting timeout
+func getHarvestTimeout(p *prefs.Preferences) time.Duration {
+	if p.HarvestTimeout <= 0 {
+		return DEFAULT_HARVEST_TIMEOUT * time.Minute
+	}
+
+	return time.Duration(p.HarvestTimeout) * time.Minute
+}
@@ -0,0 +1,258 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fsutil"
+	"pkg.re/essentialkaos/ek.v9/log"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/path"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// List of supported hooks
+const (
+	HOOK_PRE_CREATE   = "pre-create"
+	HOOK_POST_CREATE  = "post-create"
+	HOOK_PRE_DESTROY  = "pre-destroy"
+	HOOK_POST_DESTROY = "post-destroy"
+)
+
+// HOOKS_DIR is name of directory with hooks in template
+const HOOKS_DIR = "hooks"
+
+// DEFAULT_HOOK_TIMEOUT is default hook execution timeout in minutes
+const DEFAULT_HOOK_TIMEOUT = 30
+
+// HOOK_KILL_TIMEOUT is max time of waiting for killed hook completion
+const HOOK_KILL_TIMEOUT = 5 * time.Second
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// HookData contains info about farm passed to hook as JSON
+type HookData struct {
+	Hook     string      `json:"hook"`
+	Template string      `json:"template"`
+	Region   string      `json:"region"`
+	NodeSize string      `json:"node_size"`
+	User     string      `json:"user"`
+	TTL      int64       `json:"ttl"`
+	Started  int64       `json:"started,omitempty"`
+	Nodes    []*HookNode `json:"nodes"`
+}
+
+// HookNode contains info about build node passed to hook
+type HookNode struct {
+	Name     string `json:"name"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port,omitempty"`
+	Arch     string `json:"arch,omitempty"`
+	User     string `json:"user"`
+	Password string `json:"password,omitempty"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// runHooks run template and user hooks with given name
+func runHooks(hook string, p *prefs.Preferences, nodes []*NodeInfo, started int64) error {
+	scripts := getHookScripts(hook, p)
+
+	if len(scripts) == 0 {
+		return nil
+	}
+
+	data := getHookData(hook, p, nodes, started)
+
+	for _, script := range scripts {
+		if options.GetB(OPT_MONITOR) {
+			log.Info("Running %s hook %s...", hook, script)
+		} else {
+			fmtc.Printf("Running {*}%s{!} hook {s-}(%s){!}...\n\n", hook, script)
+		}
+
+		err := execHook(script, data, getHookTimeout(p))
+
+		if err != nil {
+			return fmtc.Errorf("Hook %s (%s) failed: %v", hook, script, err)
+		}
+
+		if !options.GetB(OPT_MONITOR) {
+			fmtc.NewLine()
+		}
+	}
+
+	return nil
+}
+
+// getHookScripts return slice with paths to hook scripts (template
+// hook first and then hook from preferences)
+func getHookScripts(hook string, p *prefs.Preferences) []string {
+	var result []string
+
+	templateHook := path.Join(getDataDir(), p.Template, HOOKS_DIR, hook)
+
+	if fsutil.CheckPerms("FRX", templateHook) {
+		result = append(result, templateHook)
+	}
+
+	var userHook string
+
+	switch hook {
+	case HOOK_PRE_CREATE:
+		userHook = p.HookPreCreate
+	case HOOK_POST_CREATE:
+		userHook = p.HookPostCreate
+	case HOOK_PRE_DESTROY:
+		userHook = p.HookPreDestroy
+	case HOOK_POST_DESTROY:
+		userHook = p.HookPostDestroy
+	}
+
+	if userHook != "" {
+		result = append(result, userHook)
+	}
+
+	return result
+}
+
+// getHookData return data passed to hook
+func getHookData(hook string, p *prefs.Preferences, nodes []*NodeInfo, started int64) *HookData {
+	data := &HookData{
+		Hook:     hook,
+		Template: p.Template,
+		Region:   p.Region,
+		NodeSize: p.NodeSize,
+		User:     p.User,
+		TTL:      p.TTL,
+		Started:  started,
+		Nodes:    make([]*HookNode, 0),
+	}
+
+	for _, node := range nodes {
+		data.Nodes = append(data.Nodes, &HookNode{
+			Name:     node.Name,
+			IP:       node.IP,
+			Port:     node.Port,
+			Arch:     node.Arch,
+			User:     node.User,
+			Password: node.Password,
+		})
+	}
+
+	return data
+}
+
+// execHook execute hook script, farm info passed through environment
+// variables and as JSON to stdin
+func execHook(script string, data *HookData, timeout time.Duration) error {
+	stdinData, err := json.MarshalIndent(data, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(script)
+	cmd.Env = append(os.Environ(), getHookEnv(data)...)
+	cmd.Stdin = bytes.NewReader(stdinData)
+
+	// Hook is started in own process group, so processes started by
+	// hook can be killed with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	var output *bytes.Buffer
+
+	if options.GetB(OPT_MONITOR) {
+		output = &bytes.Buffer{}
+		cmd.Stdout = output
+		cmd.Stderr = output
+	} else {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	err = cmd.Start()
+
+	if err != nil {
+		return err
+	}
+
+	errChan := make(chan error, 1)
+
+	go func() {
+		errChan <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-errChan:
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+
+		// Wait until output copying is finished before reading the buffer,
+		// processes which left hook process group can still hold output
+		select {
+		case <-errChan:
+		case <-time.After(HOOK_KILL_TIMEOUT):
+			output = nil
+		}
+
+		err = fmtc.Errorf("Hook execution took more than %s", timeout)
+	}
+
+	if output != nil {
+		scanner := bufio.NewScanner(output)
+
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				log.Info("[%s] %s", data.Hook, scanner.Text())
+			}
+		}
+	}
+
+	return err
+}
+
+// getHookEnv return slice with environment variables for hook
+func getHookEnv(data *HookData) []string {
+	var nodes []string
+
+	for _, node := range data.Nodes {
+		nodes = append(nodes, node.Name+"="+node.IP)
+	}
+
+	return []string{
+		"TERRAFARM_HOOK=" + data.Hook,
+		"TERRAFARM_HOOK_TEMPLATE=" + data.Template,
+		"TERRAFARM_HOOK_REGION=" + data.Region,
+		"TERRAFARM_HOOK_NODE_SIZE=" + data.NodeSize,
+		"TERRAFARM_HOOK_USER=" + data.User,
+		"TERRAFARM_HOOK_NODES=" + strings.Join(nodes, " "),
+		"TERRAFARM_HOOK_DATA=" + getDataDir(),
+	}
+}
+
+// getHookTimeout return hook execution timeout
+func getHookTimeout(p *prefs.Preferences) time.Duration {
+	if p.HookTimeout <= 0 {
+		return DEFAULT_HOOK_TIMEOUT * time.Minute
+	}
+
+	return time.Duration(p.HookTimeout) * time.Minute
+}
@@ -0,0 +1,492 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/fsutil"
+	"pkg.re/essentialkaos/ek.v9/jsonutil"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/path"
+	"pkg.re/essentialkaos/ek.v9/pluralize"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+
+	"github.com/essentialkaos/terrafarm/do"
+	"github.com/essentialkaos/terrafarm/prefs"
+	"github.com/essentialkaos/terrafarm/provider"
+	"github.com/essentialkaos/terrafarm/terraform"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// List of image subcommands
+const (
+	IMAGE_CMD_BAKE  = "bake"
+	IMAGE_CMD_LIST  = "list"
+	IMAGE_CMD_PRUNE = "prune"
+)
+
+// IMAGES_FILE is name of file with info about baked images
+const IMAGES_FILE = ".images"
+
+// BAKE_STATE_FILE is name of terraform state file used for baking images
+const BAKE_STATE_FILE = ".bake.tfstate"
+
+// BAKE_DIR_PREFIX is prefix of working directory used for baking images
+const BAKE_DIR_PREFIX = ".bake-"
+
+// IMAGE_MAX_AGE is max image age (in seconds) after which user will be
+// warned about outdated image
+const IMAGE_MAX_AGE = 30 * 24 * 3600
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ImageRecord contains info about baked image
+type ImageRecord struct {
+	Template string `json:"template"`
+	Node     string `json:"node"`
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Region   string `json:"region"`
+	Created  int64  `json:"created"`
+}
+
+// ImageRecordSlice is slice with image records
+type ImageRecordSlice []*ImageRecord
+
+func (s ImageRecordSlice) Len() int      { return len(s) }
+func (s ImageRecordSlice) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s ImageRecordSlice) Less(i, j int) bool {
+	if s[i].Template != s[j].Template {
+		return s[i].Template < s[j].Template
+	}
+
+	if s[i].Node != s[j].Node {
+		return s[i].Node < s[j].Node
+	}
+
+	return s[i].Created > s[j].Created
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// imageCommand is image command handler
+func imageCommand(p *prefs.Preferences, args []string) {
+	if len(args) == 0 {
+		terminal.PrintErrorMessage("You must define image command (bake, list or prune)")
+		exit(1)
+	}
+
+	if len(args) > 1 {
+		p.Template = args[1]
+	}
+
+	switch args[0] {
+	case IMAGE_CMD_BAKE:
+		bakeImagesCommand(p)
+	case IMAGE_CMD_LIST:
+		listImagesCommand(p)
+	case IMAGE_CMD_PRUNE:
+		pruneImagesCommand(p, len(args) > 1)
+	default:
+		terminal.PrintErrorMessage("Unknown image command %s", args[0])
+		exit(1)
+	}
+}
+
+// bakeImagesCommand provision one node per OS/arch from template, take
+// snapshots and destroy nodes
+func bakeImagesCommand(p *prefs.Preferences) {
+	validatePreferences(p)
+
+	if getTemplateProviderName(p.Template) != provider.DIGITALOCEAN {
+		terminal.PrintErrorMessage("Images baking is supported only for DigitalOcean templates")
+		exit(1)
+	}
+
+	nodesCount := getBuildNodesCount(p.Template)
+
+	if !options.GetB(OPT_FORCE) {
+		yes, err := terminal.ReadAnswer(
+			fmtc.Sprintf(
+				"Bake images for template %s? It will create %s for a while.",
+				p.Template, pluralize.Pluralize(nodesCount, "droplet", "droplets"),
+			), "n",
+		)
+
+		if !yes || err != nil {
+			fmtc.NewLine()
+			return
+		}
+
+		fmtutil.Separator(false)
+	}
+
+	stateFile := path.Join(getDataDir(), BAKE_STATE_FILE)
+
+	if fsutil.IsExist(stateFile) {
+		terminal.PrintWarnMessage("Found nodes from previous unfinished baking, destroying...")
+		destroyBakeNodes(p, stateFile)
+	}
+
+	workDir, err := prepareWorkDir(p, getBakeWorkDir(p.Template), false)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't prepare template: %v", err)
+		exit(1)
+	}
+
+	vars, err := getTerraformArgs(p, stateFile)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't parse preferences: %v", err)
+		exit(1)
+	}
+
+	fsutil.Push(workDir)
+
+	err = execTerraform(false, "apply", vars)
+
+	fsutil.Pop()
+
+	if err != nil {
+		terminal.PrintErrorMessage("\nError while executing terraform: %v", err)
+		destroyBakeNodes(p, stateFile)
+		notify()
+		exit(1)
+	}
+
+	fmtutil.Separator(false)
+
+	records, err := snapshotBakeNodes(p, stateFile)
+
+	fmtutil.Separator(false)
+
+	destroyBakeNodes(p, stateFile)
+
+	if len(records) != 0 {
+		saveErr := addImageRecords(records)
+
+		if saveErr != nil {
+			terminal.PrintErrorMessage("Can't save info about images: %v", saveErr)
+		}
+	}
+
+	if err != nil {
+		terminal.PrintErrorMessage("Error while baking images: %v", err)
+		notify()
+		exit(1)
+	}
+
+	fmtc.Printf(
+		"{g}Images for template %s successfully baked, use --from-snapshot option for creating farm from them{!}\n",
+		p.Template,
+	)
+
+	notify()
+}
+
+// listImagesCommand print info about baked images
+func listImagesCommand(p *prefs.Preferences) {
+	records := readImageRecords()
+
+	if len(records) == 0 {
+		terminal.PrintWarnMessage("No baked images found")
+		return
+	}
+
+	sort.Sort(ImageRecordSlice(records))
+
+	fmtutil.Separator(false, "IMAGES")
+
+	var hasOutdated bool
+
+	for _, record := range records {
+		age := time.Now().Unix() - record.Created
+		ageColor := "{s-}"
+
+		if age > IMAGE_MAX_AGE {
+			ageColor = "{y}"
+			hasOutdated = true
+		}
+
+		fmtc.Printf(
+			"  %-16s %-20s {s-}%-10d{!} %-6s "+ageColor+"%s{!}\n",
+			record.Template, record.Node, record.ID, record.Region,
+			timeutil.PrettyDuration(age),
+		)
+	}
+
+	if hasOutdated {
+		fmtc.Printf(
+			"\n  {y}Some images are older than %s, consider baking new images{!}\n",
+			timeutil.PrettyDuration(IMAGE_MAX_AGE),
+		)
+	}
+
+	fmtutil.Separator(false)
+}
+
+// pruneImagesCommand delete all outdated images (all images except the
+// newest image for every node) and records about deleted images
+func pruneImagesCommand(p *prefs.Preferences, filterByTemplate bool) {
+	records := readImageRecords()
+
+	if len(records) == 0 {
+		terminal.PrintWarnMessage("No baked images found")
+		return
+	}
+
+	sort.Sort(ImageRecordSlice(records))
+
+	var keep, prune []*ImageRecord
+
+	latest := make(map[string]bool)
+
+	for _, record := range records {
+		key := record.Template + ":" + record.Node
+
+		switch {
+		case filterByTemplate && record.Template != p.Template:
+			keep = append(keep, record)
+			continue
+		case latest[key]:
+			prune = append(prune, record)
+			continue
+		}
+
+		image, err := do.GetImage(p.Token, record.ID)
+
+		// Skip records about images which were removed manually
+		if err == nil && image == nil {
+			continue
+		}
+
+		latest[key] = true
+		keep = append(keep, record)
+	}
+
+	if len(prune) == 0 {
+		saveImageRecords(keep)
+		fmtc.Println("{g}There are no outdated images{!}")
+		return
+	}
+
+	if !options.GetB(OPT_FORCE) {
+		yes, err := terminal.ReadAnswer(
+			fmtc.Sprintf(
+				"Delete %s?",
+				pluralize.Pluralize(len(prune), "outdated image", "outdated images"),
+			), "n",
+		)
+
+		if !yes || err != nil {
+			fmtc.NewLine()
+			return
+		}
+
+		fmtc.NewLine()
+	}
+
+	for _, record := range prune {
+		err := do.DeleteImage(p.Token, record.ID)
+
+		if err != nil {
+			terminal.PrintErrorMessage("Can't delete image %s: %v", record.Name, err)
+			keep = append(keep, record)
+			continue
+		}
+
+		fmtc.Printf("  {g}✔ {!} %s {s-}(ID: %d){!}\n", record.Name, record.ID)
+	}
+
+	err := saveImageRecords(keep)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't save info about images: %v", err)
+		exit(1)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// snapshotBakeNodes power off bake nodes and take snapshots
+func snapshotBakeNodes(p *prefs.Preferences, stateFile string) ([]*ImageRecord, error) {
+	tfState, err := terraform.ReadState(stateFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tfState.Modules) == 0 {
+		return nil, fmtc.Errorf("Terraform state doesn't contain any nodes")
+	}
+
+	var records []*ImageRecord
+
+	for name, resource := range tfState.Modules[0].Resources {
+		if resource.Type != "digitalocean_droplet" || resource.Info == nil {
+			continue
+		}
+
+		// Resource name in state has format "type.name"
+		node := name[strings.Index(name, ".")+1:]
+
+		dropletID, err := strconv.Atoi(resource.Info.ID)
+
+		if err != nil {
+			return records, fmtc.Errorf("Can't parse droplet ID for %s: %v", node, err)
+		}
+
+		fmtc.Printf("Powering off {*}%s{!}...\n", node)
+
+		err = do.PowerOffDroplet(p.Token, dropletID)
+
+		if err != nil {
+			return records, err
+		}
+
+		imageName := fmtc.Sprintf("terrafarm-%s-%s-%d", p.Template, node, time.Now().Unix())
+
+		fmtc.Printf("Taking snapshot {*}%s{!}... {s-}(it can take a while){!}\n", imageName)
+
+		image, err := do.SnapshotDroplet(p.Token, dropletID, imageName)
+
+		if err != nil {
+			return records, err
+		}
+
+		records = append(records, &ImageRecord{
+			Template: p.Template,
+			Node:     node,
+			ID:       image.ID,
+			Name:     image.Name,
+			Region:   p.Region,
+			Created:  time.Now().Unix(),
+		})
+	}
+
+	return records, nil
+}
+
+// destroyBakeNodes destroy nodes created for baking images
+func destroyBakeNodes(p *prefs.Preferences, stateFile string) {
+	vars, err := getTerraformArgs(p, stateFile, "-force")
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't parse preferences: %v", err)
+		return
+	}
+
+	workDir := getBakeWorkDir(p.Template)
+
+	if !fsutil.IsDir(workDir) {
+		workDir = path.Join(getDataDir(), p.Template)
+	}
+
+	fsutil.Push(workDir)
+
+	err = execTerraform(false, "destroy", vars)
+
+	fsutil.Pop()
+
+	if err != nil {
+		terminal.PrintErrorMessage("\nError while executing terraform: %v", err)
+		terminal.PrintWarnMessage("Check droplets list and destroy bake nodes manually")
+		return
+	}
+
+	os.Remove(stateFile)
+	os.Remove(stateFile + ".backup")
+	os.RemoveAll(getBakeWorkDir(p.Template))
+
+	fmtutil.Separator(false)
+}
+
+// getSnapshotImages return map node->image with images which must be used
+// for creating nodes
+func getSnapshotImages(p *prefs.Preferences, nodes []string) (map[string]*ImageRecord, error) {
+	images := getLatestImages(p.Template)
+
+	for _, node := range nodes {
+		image := images[node]
+
+		switch {
+		case image == nil:
+			return nil, fmtc.Errorf("There is no baked image for %s, use 'terrafarm image bake %s' for baking", node, p.Template)
+		case image.Region != p.Region:
+			return nil, fmtc.Errorf("Image for %s baked in region %s and can't be used in region %s", node, image.Region, p.Region)
+		}
+
+		age := time.Now().Unix() - image.Created
+
+		if age > IMAGE_MAX_AGE {
+			terminal.PrintWarnMessage(
+				"Image for %s is %s old, consider baking new images",
+				node, timeutil.PrettyDuration(age),
+			)
+		}
+	}
+
+	return images, nil
+}
+
+// getLatestImages return map node->image with the newest images for template
+func getLatestImages(template string) map[string]*ImageRecord {
+	result := make(map[string]*ImageRecord)
+
+	for _, record := range readImageRecords() {
+		if record.Template != template {
+			continue
+		}
+
+		if result[record.Node] == nil || result[record.Node].Created < record.Created {
+			result[record.Node] = record
+		}
+	}
+
+	return result
+}
+
+// getBakeWorkDir return path to working directory used for baking images
+func getBakeWorkDir(template string) string {
+	return path.Join(getDataDir(), BAKE_DIR_PREFIX+template)
+}
+
+// addImageRecords add records about images to images file
+func addImageRecords(records []*ImageRecord) error {
+	return saveImageRecords(append(readImageRecords(), records...))
+}
+
+// readImageRecords read records about baked images
+func readImageRecords() []*ImageRecord {
+	var records []*ImageRecord
+
+	imagesFile := path.Join(getDataDir(), IMAGES_FILE)
+
+	if !fsutil.IsExist(imagesFile) {
+		return records
+	}
+
+	jsonutil.DecodeFile(imagesFile, &records)
+
+	return records
+}
+
+// saveImageRecords save records about baked images
+func saveImageRecords(records []*ImageRecord) error {
+	return encodeToFileAtomic(path.Join(getDataDir(), IMAGES_FILE), records)
+}
@@ -0,0 +1,276 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/fsutil"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/path"
+	"pkg.re/essentialkaos/ek.v9/pluralize"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+	"github.com/essentialkaos/terrafarm/provider"
+	"github.com/essentialkaos/terrafarm/terraform"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ImportNode contains info about imported droplet
+type ImportNode struct {
+	Droplet *provider.Machine
+	Kind    string // Builder config name without "builder-" prefix
+	Index   int    // Index of node added by scale command
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// importCommand is import command handler
+func importCommand(p *prefs.Preferences, args []string) {
+	switch {
+	case options.Has(OPT_TEMPLATE):
+		p.Template = options.GetS(OPT_TEMPLATE)
+	case len(args) != 0:
+		p.Template = args[0]
+	}
+
+	if isTerrafarmActive() {
+		terminal.PrintWarnMessage("Terrafarm already works, use doctor command for fixing farm state")
+		exit(1)
+	}
+
+	validatePreferences(p)
+
+	workDir, err := prepareWorkDir(p, getWorkDir(p.Template), false)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't prepare template: %v", err)
+		exit(1)
+	}
+
+	nodes, err := findImportNodes(p, workDir)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't find droplets: %v", err)
+		exit(1)
+	}
+
+	if len(nodes) == 0 {
+		terminal.PrintWarnMessage("Droplets for template %s not found", p.Template)
+		exit(1)
+	}
+
+	fmtutil.Separator(false, "IMPORT")
+
+	for _, node := range nodes {
+		fmtc.Printf(
+			"  {*}%-24s{!} {s-}(ID: %d, created %s ago){!}\n",
+			node.Droplet.Name, node.Droplet.ID,
+			timeutil.PrettyDuration(time.Since(node.Droplet.Created)),
+		)
+	}
+
+	fmtutil.Separator(false)
+
+	if !options.GetB(OPT_FORCE) {
+		yes, err := terminal.ReadAnswer(
+			fmtc.Sprintf(
+				"Import %s into farm %s?",
+				pluralize.Pluralize(len(nodes), "droplet", "droplets"), p.Template,
+			), "n",
+		)
+
+		if !yes || err != nil {
+			fmtc.NewLine()
+			cancelAudit()
+			return
+		}
+
+		fmtutil.Separator(false)
+	}
+
+	err = importNodes(p, workDir, nodes)
+
+	if err != nil {
+		terminal.PrintErrorMessage("\nError while importing droplets: %v", err)
+		terminal.PrintWarnMessage("Use doctor command for fixing farm state")
+		notify()
+		exit(1)
+	}
+
+	fmtutil.Separator(false)
+
+	adoptImportedNodes(p, nodes)
+
+	notify()
+}
+
+// importNodes import droplets into terraform state
+func importNodes(p *prefs.Preferences, workDir string, nodes []*ImportNode) error {
+	for _, node := range nodes {
+		resource, err := getImportResource(p.Template, workDir, node)
+
+		if err != nil {
+			return err
+		}
+
+		vars, err := prefsToArgs(p, getStateNodeAddress(getTemplateProvider(p.Template), resource), strconv.Itoa(node.Droplet.ID))
+
+		if err != nil {
+			return fmtc.Errorf("Can't parse preferences: %v", err)
+		}
+
+		fsutil.Push(workDir)
+
+		err = execTerraform(false, "import", vars)
+
+		fsutil.Pop()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// adoptImportedNodes create farm state for imported nodes and start
+// monitor with new TTL
+func adoptImportedNodes(p *prefs.Preferences, nodes []*ImportNode) {
+	now := time.Now().Unix()
+	started := now
+
+	for _, node := range nodes {
+		created := node.Droplet.Created
+
+		if !created.IsZero() && created.Unix() < started {
+			started = created.Unix()
+		}
+	}
+
+	ttl := p.TTL
+
+	// Farm state contains masked token, so we use copy of preferences
+	farmPrefs := *p
+
+	// TTL is counted from the moment of import
+	if ttl > 0 {
+		farmPrefs.TTL = (now-started)/60 + ttl
+	}
+
+	if saveState(&farmPrefs, started, false) == nil {
+		return
+	}
+
+	fmtc.Printf(
+		"{g}%s imported into farm %s{!}\n",
+		pluralize.Pluralize(len(nodes), "droplet", "droplets"), p.Template,
+	)
+
+	fmtutil.Separator(false)
+
+	if ttl <= 0 {
+		return
+	}
+
+	fmtc.Printf("Starting monitoring process... ")
+
+	err := saveMonitorState(&MonitorState{
+		DestroyAfter: now + ttl*60,
+		MaxWait:      p.MaxWait * 60,
+	})
+
+	if err == nil {
+		err = startMonitorProcess()
+	}
+
+	if err != nil {
+		fmtc.NewLine()
+		terminal.PrintErrorMessage("Error while starting monitoring process: %v", err)
+		return
+	}
+
+	fmtc.Printf("{g}DONE{!} {s-}(farm will be destroyed in %s){!}\n", timeutil.PrettyDuration(ttl*60))
+
+	fmtutil.Separator(false)
+}
+
+// findImportNodes find droplets which match nodes in template
+func findImportNodes(p *prefs.Preferences, workDir string) ([]*ImportNode, error) {
+	droplets, err := getTemplateProvider(p.Template).GetMachines(p.Token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Map droplet name -> builder kind
+	kinds := make(map[string]string)
+
+	for builder := range getBuilderConfigs(workDir) {
+		data, err := ioutil.ReadFile(path.Join(workDir, builder))
+
+		if err != nil {
+			continue
+		}
+
+		name := terraform.GetAttribute(string(data), "name")
+		kinds[name] = strings.TrimSuffix(strings.TrimPrefix(builder, "builder-"), ".tf")
+	}
+
+	var result []*ImportNode
+
+	for _, droplet := range droplets {
+		if kinds[droplet.Name] != "" {
+			result = append(result, &ImportNode{Droplet: droplet, Kind: kinds[droplet.Name]})
+			continue
+		}
+
+		// Droplets added by scale command
+		baseName := scaledNodeSuffix.ReplaceAllString(droplet.Name, "")
+
+		if baseName == droplet.Name || kinds[baseName] == "" {
+			continue
+		}
+
+		index, _ := strconv.Atoi(droplet.Name[len(baseName)+1:])
+
+		result = append(result, &ImportNode{
+			Droplet: droplet,
+			Kind:    kinds[baseName],
+			Index:   index,
+		})
+	}
+
+	return result, nil
+}
+
+// getImportResource return name of terraform resource for imported droplet,
+// configuration will be created for droplets added by scale command
+func getImportResource(template, workDir string, node *ImportNode) (string, error) {
+	if node.Index == 0 {
+		data, err := ioutil.ReadFile(path.Join(workDir, "builder-"+node.Kind+".tf"))
+
+		if err != nil {
+			return "", err
+		}
+
+		return terraform.GetResourceName(string(data)), nil
+	}
+
+	resource, _, err := writeNodeConfig(template, workDir, node.Kind, node.Index)
+
+	return resource, err
+}
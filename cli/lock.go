@@ -0,0 +1,236 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/log"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+
+	"github.com/essentialkaos/terrafarm/backend"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// MONITOR_LOCK_TIMEOUT is max time which monitor will wait for state lock
+const MONITOR_LOCK_TIMEOUT = 10 * time.Minute
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// stateLock contains info about acquired state lock
+var stateLock *backend.LockInfo
+
+// lockWaitShown is true if message about waiting for lock already shown
+var lockWaitShown bool
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// lockState acquire state lock for given command or exit if state
+// can't be locked
+func lockState(command string) {
+	timeout := time.Duration(timeutil.ParseDuration(options.GetS(OPT_LOCK_TIMEOUT))) * time.Second
+
+	err := acquireStateLock(command, timeout)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't lock state: %v", err)
+
+		if _, ok := err.(*backend.LockError); ok {
+			terminal.PrintWarnMessage("Use --lock-timeout option for waiting for lock or force-unlock command for releasing lock")
+		}
+
+		exit(1)
+	}
+}
+
+// lockMonitorState acquire state lock for monitor
+func lockMonitorState() bool {
+	err := acquireStateLock("monitor", MONITOR_LOCK_TIMEOUT)
+
+	if err != nil {
+		log.Error("Can't lock state: %v", err)
+		return false
+	}
+
+	return true
+}
+
+// acquireStateLock acquire local and remote state locks
+func acquireStateLock(command string, timeout time.Duration) error {
+	info := backend.NewLockInfo(command)
+	deadline := time.Now().Add(timeout)
+
+	// Local lock is always acquired, so processes on current host never
+	// change state files at the same time
+	err := acquireBackendLock(localBackend, info, deadline)
+
+	if err != nil {
+		return err
+	}
+
+	if isRemoteStateBackend() {
+		err = acquireBackendLock(stateBackend, info, deadline)
+
+		if err == nil {
+			// State could be changed while we waited for lock
+			err = pullState()
+		}
+
+		if err != nil {
+			stateBackend.Unlock(info.ID)
+			localBackend.Unlock(info.ID)
+			return err
+		}
+	}
+
+	stateLock = info
+
+	return nil
+}
+
+// unlockState release acquired state locks
+func unlockState() {
+	if stateLock == nil {
+		return
+	}
+
+	if isRemoteStateBackend() {
+		err := stateBackend.Unlock(stateLock.ID)
+
+		if err != nil {
+			printStateError("Can't unlock state: %v", err)
+		}
+	}
+
+	localBackend.Unlock(stateLock.ID)
+
+	stateLock = nil
+}
+
+// acquireBackendLock acquire lock in given state backend
+func acquireBackendLock(b backend.Backend, info *backend.LockInfo, deadline time.Time) error {
+	for {
+		err := b.Lock(info)
+
+		if err == nil {
+			return nil
+		}
+
+		lockErr, ok := err.(*backend.LockError)
+
+		if !ok || !waitForLock(lockErr, deadline) {
+			return err
+		}
+	}
+}
+
+// waitForLock show info about lock holder and wait some time if deadline
+// is not reached yet
+func waitForLock(lockErr *backend.LockError, deadline time.Time) bool {
+	if time.Now().After(deadline) {
+		return false
+	}
+
+	if !lockWaitShown {
+		if options.GetB(OPT_MONITOR) {
+			log.Info("Waiting for lock: %v", lockErr)
+		} else {
+			fmtc.Printf("{s}Waiting for lock: %v...{!}\n", lockErr)
+		}
+
+		lockWaitShown = true
+	}
+
+	time.Sleep(time.Second)
+
+	return true
+}
+
+// forceUnlockCommand is force-unlock command handler
+func forceUnlockCommand(args []string) {
+	// Local lock is released automatically when process exits, so it
+	// can't be released by force
+	err := localBackend.CheckLock()
+
+	if err != nil {
+		terminal.PrintErrorMessage("Local state lock is held by running process: %v", err)
+		terminal.PrintWarnMessage("Local lock is released automatically when process exits")
+		exit(1)
+	}
+
+	if !isRemoteStateBackend() {
+		fmtc.Println("{g}State is not locked{!}")
+		return
+	}
+
+	if len(args) == 0 {
+		terminal.PrintErrorMessage("You must provide lock ID")
+		exit(1)
+	}
+
+	if !options.GetB(OPT_FORCE) {
+		terminal.PrintWarnMessage("Releasing lock held by another user can corrupt farm state")
+
+		yes, err := terminal.ReadAnswer("Release state lock "+args[0]+"?", "n")
+
+		if !yes || err != nil {
+			fmtc.NewLine()
+			return
+		}
+
+		fmtutil.Separator(false)
+	}
+
+	err = stateBackend.Unlock(args[0])
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't release lock: %v", err)
+		exit(1)
+	}
+
+	fmtc.Println("{g}State lock successfully released{!}")
+}
+
+// writeFileAtomic write data to temporary file and rename it to given
+// file, so readers never see partially written file
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	tmpFile := file + ".tmp"
+
+	err := ioutil.WriteFile(tmpFile, data, perm)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpFile, file)
+
+	if err != nil {
+		os.Remove(tmpFile)
+	}
+
+	return err
+}
+
+// encodeToFileAtomic encode data to JSON and atomically write it to file
+func encodeToFileAtomic(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(file, data, 0644)
+}
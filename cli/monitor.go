@@ -8,39 +8,64 @@ package cli
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 import (
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"pkg.re/essentialkaos/ek.v9/fmtc"
 	"pkg.re/essentialkaos/ek.v9/fsutil"
 	"pkg.re/essentialkaos/ek.v9/jsonutil"
 	"pkg.re/essentialkaos/ek.v9/log"
+	"pkg.re/essentialkaos/ek.v9/options"
 	"pkg.re/essentialkaos/ek.v9/path"
 	"pkg.re/essentialkaos/ek.v9/signal"
 	"pkg.re/essentialkaos/ek.v9/timeutil"
+
+	"github.com/essentialkaos/terrafarm/notifier"
+	"github.com/essentialkaos/terrafarm/prefs"
 )
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+// MONITOR_HEARTBEAT_TIMEOUT is time (in seconds) after which monitor without
+// heartbeat updates is treated as dead
+const MONITOR_HEARTBEAT_TIMEOUT = 300
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
 // MonitorState contains monitor specific info
 type MonitorState struct {
-	Pid          int   `json:"pid"`
-	DestroyAfter int64 `json:"destroy_after"`
-	MaxWait      int64 `json:"max_wait"`
+	Pid          int    `json:"pid"`
+	DestroyAfter int64  `json:"destroy_after"`
+	MaxWait      int64  `json:"max_wait"`
+	Paused       bool   `json:"paused"`
+	Supervisor   string `json:"supervisor,omitempty"`
+	Host         string `json:"host,omitempty"`      // Name of host where monitor works
+	Heartbeat    int64  `json:"heartbeat,omitempty"` // Date of last monitoring loop iteration
 }
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 // startFarmMonitor starts monitoring process
 func startFarmMonitor() {
-	log.Set(getMonitorLogFilePath(), 0644)
+	// In foreground mode all messages will be written to stdout
+	if !options.GetB(OPT_FOREGROUND) {
+		log.Set(getMonitorLogFilePath(), 0644)
+	}
 
 	log.Aux(SEPARATOR)
 	log.Aux("Terrafarm %s monitor started", VER)
 
+	if !isTerrafarmActive() {
+		log.Info("Farm is not active, nothing to monitor")
+		deleteMonitorStateFile()
+		exit(0)
+	}
+
 	state, err := getMonitorState()
 
 	if err != nil {
@@ -48,30 +73,79 @@ func startFarmMonitor() {
 		exit(1)
 	}
 
-	updateMonitorPid()
+	if state.Pid != 0 && state.Pid != os.Getpid() && isLocalMonitor(state) && isTerrafarmProcess(state.Pid) {
+		log.Info("Monitor already works (pid %d)", state.Pid)
+		exit(0)
+	}
+
+	if isRemoteMonitor() {
+		log.Info("Monitor already works on host %s", state.Host)
+		exit(0)
+	}
+
+	state.Pid = os.Getpid()
+	state.Host, _ = os.Hostname()
+	state.Supervisor = envMap[EV_SUPERVISOR]
+	monitorState = state
+
+	if isMonitorSupervised() {
+		log.Info("Monitor supervised by %s", state.Supervisor)
+	}
+
+	checkMissedDeadlineByMonitor(state)
+
+	err = saveMonitorState(state)
+
+	if err != nil {
+		log.Crit("Can't save monitor state: %v", err)
+		exit(1)
+	}
+
+	err = startControlServer()
+
+	if err != nil {
+		log.Crit(err.Error())
+		exit(1)
+	}
 
 	signal.Handlers{
-		signal.USR1: usr1SignalHandler,
 		signal.TERM: termSignalHandler,
 	}.TrackAsync()
 
-	runMonitoringLoop(time.Unix(state.DestroyAfter, 0), state.MaxWait)
+	runMonitoringLoop()
 
 	deleteFarmStateFile()
 	deleteMonitorStateFile()
+	stopControlServer()
 
 	log.Info("Farm successfully destroyed!")
 
 	exit(0)
 }
 
-// getMonitorState return monitor state
+// getMonitorState return monitor state, if monitor state file doesn't
+// exist state will be restored from farm state
 func getMonitorState() (*MonitorState, error) {
-	if !fsutil.IsExist(getMonitorStateFilePath()) {
+	if fsutil.IsExist(getMonitorStateFilePath()) {
+		return readMonitorState()
+	}
+
+	farmState, err := readFarmState()
+
+	if err != nil {
 		return nil, fmtc.Errorf("Can't start monitoring process: state file not exist")
 	}
 
-	return readMonitorState()
+	if farmState.Preferences.TTL <= 0 {
+		return nil, fmtc.Errorf("Can't start monitoring process: TTL is disabled for farm")
+	}
+
+	log.Info("Monitor state file is not exist, state restored from farm state")
+
+	return &MonitorState{
+		DestroyAfter: farmState.Started + farmState.Preferences.TTL*60,
+		MaxWait:      farmState.Preferences.MaxWait * 60,
+	}, nil
 }
 
 // killMonitorProcess kill monitor process
@@ -82,71 +156,214 @@ func killMonitorProcess() error {
 		return err
 	}
 
-	if !fsutil.IsExist(fmtc.Sprintf("/proc/%d", state.Pid)) {
-		return fmtc.Errorf("Monitor process with pid %d is not found", state.Pid)
+	if !isLocalMonitor(state) {
+		return fmtc.Errorf("Monitor works on host %s and can't be stopped from this host", state.Host)
 	}
 
-	err = signal.Send(state.Pid, signal.USR1)
-
-	if err != nil {
-		return err
+	if !isTerrafarmProcess(state.Pid) {
+		return fmtc.Errorf("Monitor process with pid %d is not found", state.Pid)
 	}
 
-	return nil
-}
-
-// usr1SignalHandler is USR1 signal handler
-func usr1SignalHandler() {
-	log.Info("Got USR1 signal, restarting...")
-	exit(0)
+	return signal.Send(state.Pid, signal.TERM)
 }
 
 // termSignalHandler is TERM signal handler
 func termSignalHandler() {
 	log.Info("Got TERM signal, shutdown...")
-	deleteMonitorStateFile()
+
+	// Supervised monitor must resume from saved state after restart
+	if !isMonitorSupervised() {
+		deleteMonitorStateFile()
+	}
+
+	stopControlServer()
 	exit(0)
 }
 
-// runMonitoringLoop run loop which check farm status
-func runMonitoringLoop(destroyAfter time.Time, maxWait int64) {
-	destroyNotLater := time.Unix(destroyAfter.Unix()+maxWait, 0)
+// waitNotificationSent is true if notification about waiting for
+// builds already sent
+var waitNotificationSent bool
 
-	if maxWait > 0 {
-		log.Info(
-			"Farm will be destroyed during the period %s - %s",
-			timeutil.Format(destroyAfter, "%Y/%m/%d %H:%M:%S"),
-			timeutil.Format(destroyNotLater, "%Y/%m/%d %H:%M:%S"),
-		)
-	} else {
-		log.Info(
-			"Farm will be destroyed after %s",
-			timeutil.Format(destroyAfter, "%Y/%m/%d %H:%M:%S"),
-		)
-	}
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// runMonitoringLoop run loop which check farm status
+func runMonitoringLoop() {
+	var (
+		lastState      MonitorState
+		ttlWarningSent bool
+	)
 
 	for {
+		syncMonitorState()
+
 		if !isTerrafarmActive() {
 			log.Info("Farm destroyed manually. Shutdown monitor...")
 			deleteMonitorStateFile()
+			stopControlServer()
 			exit(0)
 		}
 
-		time.Sleep(time.Minute)
+		state := getCurrentMonitorState()
+
+		if state.DestroyAfter != lastState.DestroyAfter || state.MaxWait != lastState.MaxWait {
+			logDestroyPeriod(state)
+			lastState = state
+			ttlWarningSent = false
+			waitNotificationSent = false
+		}
+
+		if !ttlWarningSent && !state.Paused {
+			ttlWarningSent = checkTTLWarning(state)
+		}
+
+		select {
+		case <-time.After(time.Minute):
+		case <-monitorWakeChan:
+		}
+
+		state = getCurrentMonitorState()
+
+		if state.Paused || !isFarmMustBeDestroyed(state) {
+			continue
+		}
 
-		if !isFarmMustBeDestroyed(destroyAfter, destroyNotLater) {
+		if !lockMonitorState() {
 			continue
 		}
 
-		// Function return true if farm destroyed
-		if destroyFarmByMonitor() {
+		// Function return true if farm destroyed, lock will be released
+		// on exit after removing state files
+		if destroyFarmByMonitor(state) {
 			break
 		}
+
+		unlockState()
+	}
+}
+
+// syncMonitorState apply changes of state made through state backend and
+// save monitor heartbeat, state files are not changed if state is locked,
+// because command which holds lock can work with them right now
+func syncMonitorState() {
+	if acquireStateLock("monitor", 0) != nil {
+		return
+	}
+
+	refreshMonitorState()
+	updateMonitorHeartbeat()
+	unlockState()
+}
+
+// updateMonitorHeartbeat save date of current monitoring loop iteration, so
+// users which share state through state backend can check that monitor works
+func updateMonitorHeartbeat() {
+	monitorStateLock.Lock()
+	defer monitorStateLock.Unlock()
+
+	monitorState.Heartbeat = time.Now().Unix()
+
+	err := saveMonitorState(monitorState)
+
+	if err != nil {
+		log.Error("Can't save monitor state: %v", err)
+	}
+}
+
+// checkMissedDeadlineByMonitor check that farm deadline was missed while
+// monitor wasn't running and force farm check
+func checkMissedDeadlineByMonitor(state *MonitorState) {
+	overdue := time.Now().Unix() - state.DestroyAfter
+
+	if overdue <= 0 {
+		return
+	}
+
+	farmState, err := readFarmState()
+	p := getMonitorPreferences()
+
+	if err == nil && p != nil {
+		extraCost := calculateUsagePrice(
+			getFarmProvider(farmState), p.Token, overdue/60, getFarmNodesCount(farmState),
+			farmState.Preferences.NodeSize,
+		)
+
+		log.Warn(
+			"Farm TTL expired %s ago while monitor wasn't running (extra cost ~ $%.2f)",
+			timeutil.PrettyDuration(overdue), extraCost,
+		)
+	} else {
+		log.Warn(
+			"Farm TTL expired %s ago while monitor wasn't running",
+			timeutil.PrettyDuration(overdue),
+		)
+	}
+
+	wakeMonitoringLoop()
+}
+
+// checkTTLWarning send notification if farm will be destroyed soon, return
+// true if there is no need to check TTL again
+func checkTTLWarning(state MonitorState) bool {
+	p := getMonitorPreferences()
+
+	if p == nil {
+		return false
+	}
+
+	if p.NotifyTTLWarning <= 0 {
+		return true
+	}
+
+	ttlRemain := state.DestroyAfter - time.Now().Unix()
+
+	if ttlRemain <= 0 {
+		return true
+	}
+
+	if ttlRemain > p.NotifyTTLWarning*60 {
+		return false
+	}
+
+	farmState, err := readFarmState()
+
+	if err != nil {
+		return false
+	}
+
+	log.Info("Farm will be destroyed in %s, sending notification...", timeutil.PrettyDuration(ttlRemain))
+
+	sendNotification(p, notifier.NewEvent(
+		notifier.EVENT_TTL_WARNING, farmState.Preferences.Template,
+		fmtc.Sprintf(
+			"Farm will be destroyed in %s, use 'terrafarm prolong' for increasing TTL",
+			timeutil.PrettyDuration(ttlRemain),
+		),
+	))
+
+	return true
+}
+
+// logDestroyPeriod write info about destroy period to log
+func logDestroyPeriod(state MonitorState) {
+	destroyAfter := time.Unix(state.DestroyAfter, 0)
+	destroyNotLater := time.Unix(state.DestroyAfter+state.MaxWait, 0)
+
+	if state.MaxWait > 0 {
+		log.Info(
+			"Farm will be destroyed during the period %s - %s",
+			timeutil.Format(destroyAfter, "%Y/%m/%d %H:%M:%S"),
+			timeutil.Format(destroyNotLater, "%Y/%m/%d %H:%M:%S"),
+		)
+	} else {
+		log.Info(
+			"Farm will be destroyed after %s",
+			timeutil.Format(destroyAfter, "%Y/%m/%d %H:%M:%S"),
+		)
 	}
 }
 
 // destroyFarmByMonitor destroy farm
-func destroyFarmByMonitor() bool {
+func destroyFarmByMonitor(state MonitorState) bool {
 	log.Info("Starting farm destroying...")
 
 	farmState, err := readFarmState()
@@ -156,11 +373,16 @@ func destroyFarmByMonitor() bool {
 		return false
 	}
 
-	prefs := getPreferences()
+	userPrefs := getMonitorPreferences()
+
+	if userPrefs == nil {
+		log.Error("Can't read preferences, destroy postponed")
+		return false
+	}
 
 	p := farmState.Preferences
-	p.Token = prefs.Token
-	p.Password = prefs.Password
+	p.Token = userPrefs.Token
+	p.Password = userPrefs.Password
 
 	vars, err := prefsToArgs(p, "-no-color", "-force")
 
@@ -169,42 +391,71 @@ func destroyFarmByMonitor() bool {
 		return false
 	}
 
-	templateDir := path.Join(getDataDir(), p.Template)
+	nodes, _ := collectNodesInfo(p)
+	err = runHooks(HOOK_PRE_DESTROY, p, nodes, farmState.Started)
+
+	if err != nil {
+		// Without max wait time farm is destroyed only after successful
+		// hook execution
+		if state.MaxWait <= 0 || time.Now().Unix() < state.DestroyAfter+state.MaxWait {
+			log.Error("%v, destroy postponed", err)
+			return false
+		}
+
+		log.Warn("%v, max wait time expired, destroying farm anyway", err)
+	}
+
+	harvestArtifacts(p, nodes)
 
-	fsutil.Push(templateDir)
+	droplets := getStateResources()
+
+	fsutil.Push(getFarmWorkDir(farmState))
 
 	err = execTerraform(true, "destroy", vars)
 
+	fsutil.Pop()
+
+	auditMonitorDestroy(p.Template, droplets, err == nil)
+
 	if err != nil {
 		log.Error("Can't destroy farm - terrafarm return error: %v", err)
+		sendNotification(userPrefs, notifier.NewEvent(
+			notifier.EVENT_DESTROY_FAILED, p.Template,
+			fmtc.Sprintf("Monitor can't destroy farm: %v", err),
+		))
 		return false
 	}
 
-	fsutil.Pop()
-
 	priceMessage, priceMessageComment := getUsagePriceMessage()
 
 	if priceMessage != "" {
 		log.Info("Usage price: %s (%s)", priceMessage, priceMessageComment)
 	}
 
+	sendDestroyNotification(userPrefs, farmState, priceMessage, priceMessageComment)
+
+	err = runHooks(HOOK_POST_DESTROY, p, nodes, farmState.Started)
+
+	if err != nil {
+		log.Error(err.Error())
+	}
+
 	return true
 }
 
 // isFarmMustBeDestroyed return true if farm must be destroyed
-func isFarmMustBeDestroyed(destroyAfter, destroyNotLater time.Time) bool {
+func isFarmMustBeDestroyed(state MonitorState) bool {
 	now := time.Now().Unix()
 
-	if now < destroyAfter.Unix() {
+	if now < state.DestroyAfter {
 		return false
 	}
 
-	// MaxWait == 0
-	if destroyAfter.Unix() == destroyNotLater.Unix() {
+	if state.MaxWait <= 0 {
 		return true
 	}
 
-	if now > destroyNotLater.Unix() {
+	if now > state.DestroyAfter+state.MaxWait {
 		return true
 	}
 
@@ -226,9 +477,45 @@ func isFarmMustBeDestroyed(destroyAfter, destroyNotLater time.Time) bool {
 		strings.Join(activeBuildNodes, ", "),
 	)
 
+	if !waitNotificationSent {
+		event := notifier.NewEvent(
+			notifier.EVENT_WAITING_BUILDS, farmState.Preferences.Template,
+			fmtc.Sprintf(
+				"Farm TTL expired, but %s still have active build processes. Farm will be destroyed after builds finish or in %s.",
+				strings.Join(activeBuildNodes, ", "),
+				timeutil.PrettyDuration(state.DestroyAfter+state.MaxWait-now),
+			),
+		)
+
+		event.Nodes = activeBuildNodes
+
+		p := getMonitorPreferences()
+
+		if p != nil {
+			sendNotification(p, event)
+			waitNotificationSent = true
+		}
+	}
+
 	return false
 }
 
+// getMonitorPreferences return preferences for monitor, unlike getPreferences
+// it doesn't exit on errors, so monitor can try to read preferences later
+func getMonitorPreferences() *prefs.Preferences {
+	p, errs := readTemplatePreferences("")
+
+	if len(errs) != 0 {
+		for _, err := range errs {
+			log.Error("Can't read preferences: %v", err)
+		}
+
+		return nil
+	}
+
+	return p
+}
+
 // getMonitorLogFilePath return path to monitor log file
 func getMonitorLogFilePath() string {
 	return path.Join(getDataDir(), MONITOR_LOG_FILE)
@@ -241,35 +528,20 @@ func getMonitorStateFilePath() string {
 
 // deleteMonitorStateFile remote monitor state file
 func deleteMonitorStateFile() error {
-	return os.Remove(getMonitorStateFilePath())
-}
+	err := os.Remove(getMonitorStateFilePath())
 
-// saveMonitorState save monitor state to file
-func saveMonitorState(state *MonitorState) error {
-	stateFile := getMonitorStateFilePath()
-
-	if fsutil.IsExist(stateFile) {
-		err := os.Remove(stateFile)
+	pushStateFile(MONITOR_STATE_FILE)
 
-		if err != nil {
-			return err
-		}
-	}
-
-	return jsonutil.EncodeToFile(stateFile, state)
+	return err
 }
 
-// updateMonitorPid update
-func updateMonitorPid() error {
-	state, err := readMonitorState()
-
-	if err != nil {
-		return err
-	}
+// saveMonitorState save monitor state to file
+func saveMonitorState(state *MonitorState) error {
+	err := encodeToFileAtomic(getMonitorStateFilePath(), state)
 
-	state.Pid = os.Getpid()
+	pushStateFile(MONITOR_STATE_FILE)
 
-	return saveMonitorState(state)
+	return err
 }
 
 // readMonitorDestroyDate read monitor state from file
@@ -290,10 +562,15 @@ func readMonitorState() (*MonitorState, error) {
 	return state, nil
 }
 
-// startMonitorProcess start or restart monitoring process
-func startMonitorProcess(restart bool) error {
-	cmd := exec.Command("terrafarm", "--monitor")
-	err := cmd.Start()
+// startMonitorProcess start monitoring process
+func startMonitorProcess() error {
+	var err error
+
+	if isMonitorUnitInstalled() {
+		err = startMonitorUnit()
+	} else {
+		err = startDetachedMonitor()
+	}
 
 	if err != nil {
 		return err
@@ -301,7 +578,7 @@ func startMonitorProcess(restart bool) error {
 
 	// 0.125 * 40 = 5 sec
 	for i := 0; i < 40; i++ {
-		if isMonitorActive() {
+		if isMonitorActive() && fsutil.IsExist(getMonitorSocketFilePath()) {
 			return nil
 		}
 
@@ -311,15 +588,67 @@ func startMonitorProcess(restart bool) error {
 	return fmtc.Errorf("Monitor does not start more than 5 seconds")
 }
 
+// startDetachedMonitor start monitor as detached child process
+func startDetachedMonitor() error {
+	binary, err := os.Executable()
+
+	if err != nil {
+		return fmtc.Errorf("Can't find path to terrafarm binary: %v", err)
+	}
+
+	cmd := exec.Command(binary, "--monitor")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	return cmd.Start()
+}
+
 // isMonitorActive return true is monitor process is active
 func isMonitorActive() bool {
 	state, err := readMonitorState()
 
-	if err != nil || state.Pid == 0 {
+	if err != nil || state.Pid == 0 || !isLocalMonitor(state) {
 		return false
 	}
 
-	return fsutil.IsExist("/proc/" + strconv.Itoa(state.Pid))
+	return isTerrafarmProcess(state.Pid)
+}
+
+// isLocalMonitor return true if monitor state saved by monitor on current
+// host, so monitor pid can be checked
+func isLocalMonitor(state *MonitorState) bool {
+	hostname, _ := os.Hostname()
+
+	// Monitor state without host can be created only on current host if
+	// state is not shared through state backend
+	if state.Host == "" {
+		return !isRemoteStateBackend()
+	}
+
+	return state.Host == hostname
+}
+
+// isTerrafarmProcess return true if process with given pid is
+// terrafarm monitor process
+func isTerrafarmProcess(pid int) bool {
+	cmdline, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+
+	if err != nil {
+		return false
+	}
+
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+
+	if !strings.Contains(path.Base(args[0]), "terrafarm") {
+		return false
+	}
+
+	for _, arg := range args[1:] {
+		if arg == "--monitor" || arg == "-m" {
+			return true
+		}
+	}
+
+	return false
 }
 
 // ////////////////////////////////////////////////////////////////////////////////// //
@@ -0,0 +1,67 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"pkg.re/essentialkaos/ek.v9/log"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+
+	"github.com/essentialkaos/terrafarm/notifier"
+	"github.com/essentialkaos/terrafarm/prefs"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getNotificationSinks return slice with notification sinks configured
+// in preferences
+func getNotificationSinks(p *prefs.Preferences) []notifier.Sink {
+	var sinks []notifier.Sink
+
+	if p.NotifyWebhook != "" {
+		sinks = append(sinks, &notifier.WebhookSink{URL: p.NotifyWebhook})
+	}
+
+	if p.NotifySlack != "" {
+		sinks = append(sinks, &notifier.SlackSink{URL: p.NotifySlack})
+	}
+
+	if len(p.NotifyEmail) != 0 && p.NotifySMTP != "" {
+		sinks = append(sinks, &notifier.EmailSink{
+			Server:   p.NotifySMTP,
+			User:     p.NotifySMTPUser,
+			Password: p.NotifySMTPPassword,
+			From:     p.NotifyEmailFrom,
+			To:       p.NotifyEmail,
+		})
+	}
+
+	if p.NotifyDesktop {
+		sinks = append(sinks, &notifier.DesktopSink{})
+	}
+
+	return sinks
+}
+
+// sendNotification send notification about event using all sinks
+// configured in preferences
+func sendNotification(p *prefs.Preferences, event *notifier.Event) {
+	sinks := getNotificationSinks(p)
+
+	if len(sinks) == 0 {
+		return
+	}
+
+	for _, err := range notifier.Send(sinks, event) {
+		if options.GetB(OPT_MONITOR) {
+			log.Error(err.Error())
+		} else {
+			terminal.PrintWarnMessage(err.Error())
+		}
+	}
+}
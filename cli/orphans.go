@@ -0,0 +1,162 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"strconv"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+	"github.com/essentialkaos/terrafarm/provider"
+	"github.com/essentialkaos/terrafarm/terraform"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getFarmDeadline return farm destroy deadline (unix timestamp) and max
+// wait time (in seconds)
+func getFarmDeadline(farmState *FarmState) (int64, int64) {
+	monitorState, err := readMonitorState()
+
+	if err == nil && monitorState.DestroyAfter != 0 {
+		return monitorState.DestroyAfter, monitorState.MaxWait
+	}
+
+	if farmState == nil || farmState.Preferences.TTL <= 0 {
+		return 0, 0
+	}
+
+	return farmState.Started + farmState.Preferences.TTL*60,
+		farmState.Preferences.MaxWait * 60
+}
+
+// getFarmOverdue return number of seconds passed after farm deadline,
+// or 0 if deadline is not passed yet
+func getFarmOverdue(farmState *FarmState) int64 {
+	deadline, _ := getFarmDeadline(farmState)
+
+	if deadline == 0 || deadline > time.Now().Unix() {
+		return 0
+	}
+
+	return time.Now().Unix() - deadline
+}
+
+// checkMissedDeadline check farm deadline and offer destroy farm if
+// deadline was missed while monitor wasn't running
+func checkMissedDeadline(p *prefs.Preferences, farmState *FarmState) {
+	overdue := getFarmOverdue(farmState)
+
+	if overdue == 0 {
+		return
+	}
+
+	extraCost := calculateUsagePrice(
+		getFarmProvider(farmState), p.Token, overdue/60, getFarmNodesCount(farmState),
+		farmState.Preferences.NodeSize,
+	)
+
+	terminal.PrintWarnMessage(
+		"Farm TTL expired %s ago while monitor wasn't running (extra cost ~ $%.2f)",
+		timeutil.PrettyDuration(overdue), extraCost,
+	)
+
+	fmtc.NewLine()
+
+	yes, err := terminal.ReadAnswer("Destroy farm right now?", "n")
+
+	if !yes || err != nil {
+		fmtc.NewLine()
+		return
+	}
+
+	fmtutil.Separator(false)
+
+	destroyFarm(p)
+}
+
+// findOrphanDroplets return slice with terrafarm droplets which are
+// not present in local state
+func findOrphanDroplets(prov provider.Provider, token string) ([]*provider.Machine, error) {
+	droplets, err := prov.GetMachines(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	knownDroplets := getStateDropletIDs()
+
+	var result []*provider.Machine
+
+	for _, droplet := range droplets {
+		if !knownDroplets[droplet.ID] {
+			result = append(result, droplet)
+		}
+	}
+
+	return result, nil
+}
+
+// printOrphanDroplets print info about droplets without local state
+func printOrphanDroplets(droplets []*provider.Machine) {
+	if len(droplets) == 0 {
+		return
+	}
+
+	var totalCost float64
+
+	fmtutil.Separator(false, "ORPHANS")
+
+	for _, droplet := range droplets {
+		age := time.Since(droplet.Created)
+		cost := age.Hours() * droplet.PriceHourly
+		totalCost += cost
+
+		fmtc.Printf(
+			"  {y}%-24s{!} {s-}(ID: %d){!} %s {s-}(%s, ~ $%.2f){!}\n",
+			droplet.Name, droplet.ID, droplet.Size,
+			timeutil.PrettyDuration(age), cost,
+		)
+	}
+
+	fmtc.Printf(
+		"\n  {r}Droplets above have no local state and running cost ~ $%.2f{!}\n",
+		totalCost,
+	)
+}
+
+// getStateDropletIDs return map with IDs of droplets from local
+// terraform state
+func getStateDropletIDs() map[int]bool {
+	result := make(map[int]bool)
+
+	tfState, err := terraform.ReadState(getTerraformStateFilePath())
+
+	if err != nil || len(tfState.Modules) == 0 {
+		return result
+	}
+
+	for _, resource := range getNodeResources(tfState) {
+		if resource.Info == nil {
+			continue
+		}
+
+		id, err := strconv.Atoi(resource.Info.ID)
+
+		if err == nil {
+			result[id] = true
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,315 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/path"
+	"pkg.re/essentialkaos/ek.v9/sliceutil"
+
+	"github.com/essentialkaos/terrafarm/do"
+	"github.com/essentialkaos/terrafarm/prefs"
+	"github.com/essentialkaos/terrafarm/provider"
+	"github.com/essentialkaos/terrafarm/terraform"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// List of pre-flight check statuses
+const (
+	CHECK_OK uint8 = iota
+	CHECK_WARN
+	CHECK_FAIL
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// PreflightCheck contains pre-flight check result
+type PreflightCheck struct {
+	Name    string
+	Message string
+	Status  uint8
+}
+
+// PreflightNode contains info about planned build node
+type PreflightNode struct {
+	Name   string
+	Image  string
+	Region string
+	Size   string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// runPreflightChecks check account limits and resources availability for
+// all nodes in working directory and return false if some checks failed
+func runPreflightChecks(p *prefs.Preferences, workDir string) bool {
+	fmtc.Println("Running pre-flight checks...\n")
+
+	nodes := getPreflightNodes(p, workDir)
+	prov := getTemplateProvider(p.Template)
+
+	var checks []*PreflightCheck
+
+	// Account limits, regions and images can be checked only
+	// through DigitalOcean API
+	if prov.Name() == provider.DIGITALOCEAN {
+		checks = append(checks, checkDropletLimit(p, len(nodes)))
+		checks = append(checks, checkSSHKey(prov, p))
+		checks = append(checks, checkSizesAvailability(p, nodes)...)
+		checks = append(checks, checkImagesAvailability(p, nodes)...)
+	} else if prov.Name() == provider.LOCAL {
+		checks = append(checks, checkContainerRuntime())
+	} else {
+		checks = append(checks, checkSSHKey(prov, p))
+		checks = append(checks, checkProviderSizes(prov, p, nodes)...)
+	}
+
+	result := true
+
+	for _, check := range checks {
+		switch check.Status {
+		case CHECK_OK:
+			fmtc.Printf("  {g}✔ {!} %s", check.Name)
+		case CHECK_WARN:
+			fmtc.Printf("  {y}? {!} %s", check.Name)
+		default:
+			fmtc.Printf("  {r}✘ {!} %s", check.Name)
+			result = false
+		}
+
+		if check.Message != "" {
+			fmtc.Printf(" {s-}(%s){!}", check.Message)
+		}
+
+		fmtc.NewLine()
+	}
+
+	fmtutil.Separator(false)
+
+	return result
+}
+
+// checkDropletLimit check that account droplet limit allows to create
+// all nodes
+func checkDropletLimit(p *prefs.Preferences, planned int) *PreflightCheck {
+	check := &PreflightCheck{Name: "Droplet limit"}
+
+	account, err := do.GetAccount(p.Token)
+
+	if err != nil {
+		check.Status, check.Message = CHECK_WARN, err.Error()
+		return check
+	}
+
+	droplets, err := do.GetDroplets(p.Token)
+
+	if err != nil {
+		check.Status, check.Message = CHECK_WARN, err.Error()
+		return check
+	}
+
+	check.Message = fmtc.Sprintf(
+		"%d of %d used, %d planned",
+		len(droplets), account.DropletLimit, planned,
+	)
+
+	if len(droplets)+planned > account.DropletLimit {
+		check.Status = CHECK_FAIL
+	}
+
+	return check
+}
+
+// checkSSHKey check that SSH key is present in account
+func checkSSHKey(prov provider.Provider, p *prefs.Preferences) *PreflightCheck {
+	check := &PreflightCheck{Name: "SSH key " + p.Fingerprint}
+
+	switch prov.IsFingerprintValid(p.Token, p.Fingerprint) {
+	case provider.STATUS_NOT_OK:
+		check.Status, check.Message = CHECK_FAIL, "key is not added to account"
+	case provider.STATUS_ERROR:
+		check.Status, check.Message = CHECK_WARN, "can't check key"
+	}
+
+	return check
+}
+
+// checkSizesAvailability check that all used sizes available in regions
+func checkSizesAvailability(p *prefs.Preferences, nodes []*PreflightNode) []*PreflightCheck {
+	var result []*PreflightCheck
+
+	regions, err := do.GetRegions(p.Token)
+
+	if err != nil {
+		return []*PreflightCheck{{"Sizes availability", err.Error(), CHECK_WARN}}
+	}
+
+	for _, pair := range getPreflightSizes(nodes) {
+		pairSlice := strings.Split(pair, ":")
+		region, size := pairSlice[0], pairSlice[1]
+		check := &PreflightCheck{Name: fmtc.Sprintf("Size %s in region %s", size, region)}
+		info := findRegion(regions, region)
+
+		switch {
+		case info == nil:
+			check.Status, check.Message = CHECK_FAIL, "unknown region"
+		case !info.Available:
+			check.Status, check.Message = CHECK_FAIL, "region is not available"
+		case !sliceutil.Contains(info.Sizes, size):
+			check.Status, check.Message = CHECK_FAIL, "size is not available in region"
+		}
+
+		result = append(result, check)
+	}
+
+	return result
+}
+
+// checkProviderSizes check that all used sizes and regions are supported
+// by provider
+func checkProviderSizes(prov provider.Provider, p *prefs.Preferences, nodes []*PreflightNode) []*PreflightCheck {
+	var result []*PreflightCheck
+
+	for _, pair := range getPreflightSizes(nodes) {
+		pairSlice := strings.Split(pair, ":")
+		region, size := pairSlice[0], pairSlice[1]
+		check := &PreflightCheck{Name: fmtc.Sprintf("Size %s in region %s", size, region)}
+
+		switch {
+		case prov.IsRegionValid(p.Token, region) != provider.STATUS_OK:
+			check.Status, check.Message = CHECK_FAIL, "unknown region"
+		case prov.IsSizeValid(p.Token, size) != provider.STATUS_OK:
+			check.Status, check.Message = CHECK_FAIL, "unknown size"
+		case !provider.IsSizeAvailable(prov, p.Token, size, region):
+			check.Status, check.Message = CHECK_WARN, "size is not available in region"
+		}
+
+		result = append(result, check)
+	}
+
+	return result
+}
+
+// checkContainerRuntime check that docker or podman is installed for
+// running local build nodes
+func checkContainerRuntime() *PreflightCheck {
+	check := &PreflightCheck{Name: "Container runtime"}
+
+	for _, binary := range []string{"docker", "podman"} {
+		_, err := exec.LookPath(binary)
+
+		if err == nil {
+			check.Message = binary
+			return check
+		}
+	}
+
+	check.Status, check.Message = CHECK_FAIL, "docker or podman is not installed"
+
+	return check
+}
+
+// checkImagesAvailability check that all images used by nodes exist
+func checkImagesAvailability(p *prefs.Preferences, nodes []*PreflightNode) []*PreflightCheck {
+	var result []*PreflightCheck
+	var images []string
+
+	for _, node := range nodes {
+		if node.Image != "" && !sliceutil.Contains(images, node.Image) {
+			images = append(images, node.Image)
+		}
+	}
+
+	sort.Strings(images)
+
+	for _, image := range images {
+		check := &PreflightCheck{Name: "Image " + image}
+
+		switch do.IsImageExist(p.Token, image) {
+		case do.STATUS_NOT_OK:
+			check.Status, check.Message = CHECK_FAIL, "image does not exist"
+		case do.STATUS_ERROR:
+			check.Status, check.Message = CHECK_WARN, "can't check image"
+		}
+
+		result = append(result, check)
+	}
+
+	return result
+}
+
+// getPreflightNodes return info about all nodes in working directory
+func getPreflightNodes(p *prefs.Preferences, workDir string) []*PreflightNode {
+	var result []*PreflightNode
+
+	for builder, name := range getBuilderConfigs(workDir) {
+		data, err := ioutil.ReadFile(path.Join(workDir, builder))
+
+		if err != nil {
+			continue
+		}
+
+		config := string(data)
+
+		result = append(result, &PreflightNode{
+			Name:   name,
+			Image:  getPreflightAttribute(config, "image", ""),
+			Region: getPreflightAttribute(config, "region", p.Region),
+			Size:   getPreflightAttribute(config, "size", p.NodeSize),
+		})
+	}
+
+	return result
+}
+
+// getPreflightSizes return sorted list of unique region:size pairs
+func getPreflightSizes(nodes []*PreflightNode) []string {
+	var result []string
+
+	for _, node := range nodes {
+		pair := node.Region + ":" + node.Size
+
+		if !sliceutil.Contains(result, pair) {
+			result = append(result, pair)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// getPreflightAttribute return value of attribute from configuration or
+// default value if attribute defined by variable
+func getPreflightAttribute(config, name, defvalue string) string {
+	value := terraform.GetAttribute(config, name)
+
+	if value == "" || strings.Contains(value, "${") {
+		return defvalue
+	}
+
+	return value
+}
+
+// findRegion return region with given slug
+func findRegion(regions []*do.Region, slug string) *do.Region {
+	for _, region := range regions {
+		if region.Slug == slug {
+			return region
+		}
+	}
+
+	return nil
+}
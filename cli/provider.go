@@ -0,0 +1,100 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"github.com/essentialkaos/terrafarm/prefs"
+	"github.com/essentialkaos/terrafarm/provider"
+	"github.com/essentialkaos/terrafarm/terraform"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getTemplateProviderName return name of provider declared in template
+// configuration, templates without provider declaration use DigitalOcean
+func getTemplateProviderName(template string) string {
+	if template == "" {
+		return provider.DIGITALOCEAN
+	}
+
+	return provider.TemplateProvider(getTemplateWorkDir(template))
+}
+
+// getTemplateProvider return provider declared in template configuration
+func getTemplateProvider(template string) provider.Provider {
+	return getProvider(getTemplateProviderName(template))
+}
+
+// getFarmProvider return provider used for farm creation
+func getFarmProvider(farmState *FarmState) provider.Provider {
+	if farmState.Provider != "" {
+		return getProvider(farmState.Provider)
+	}
+
+	return getTemplateProvider(farmState.Preferences.Template)
+}
+
+// getActiveProvider return provider of created farm or provider
+// declared by template from preferences
+func getActiveProvider(p *prefs.Preferences) provider.Provider {
+	farmState, err := readFarmState()
+
+	if err == nil {
+		return getFarmProvider(farmState)
+	}
+
+	return getTemplateProvider(p.Template)
+}
+
+// getProvider return provider with given name, DigitalOcean provider is
+// returned for unsupported providers
+func getProvider(name string) provider.Provider {
+	prov := provider.Get(name)
+
+	if prov == nil {
+		return provider.Get(provider.DIGITALOCEAN)
+	}
+
+	return prov
+}
+
+// getNodeResources return resources of build nodes from terraform state,
+// other resources (e.g. data sources with SSH keys) are ignored
+func getNodeResources(tfState *terraform.TFState) map[string]*terraform.TFResource {
+	result := make(map[string]*terraform.TFResource)
+
+	if len(tfState.Modules) == 0 {
+		return result
+	}
+
+	for address, resource := range tfState.Modules[0].Resources {
+		if provider.IsNodeResource(resource.Type) {
+			result[address] = resource
+		}
+	}
+
+	return result
+}
+
+// getSizePrice return hourly price of machine with given size, token can
+// be empty if it is unknown (e.g. for farm state with masked token)
+func getSizePrice(prov provider.Provider, token, size string) float64 {
+	info := provider.GetSize(prov, token, size)
+
+	if info == nil {
+		return 0.0
+	}
+
+	return info.Price
+}
+
+// isProviderSupported return true if provider declared in template
+// is supported
+func isProviderSupported(template string) bool {
+	return provider.Get(getTemplateProviderName(template)) != nil
+}
@@ -0,0 +1,205 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fsutil"
+	"pkg.re/essentialkaos/ek.v9/path"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+	"github.com/essentialkaos/terrafarm/provision"
+	"github.com/essentialkaos/terrafarm/terraform"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// WORK_DIR_PREFIX is prefix of working directory with rendered template
+const WORK_DIR_PREFIX = ".work-"
+
+// AUTH_VAR is terraform variable with encrypted build node user password
+const AUTH_VAR = "${var.auth}"
+
+// SNAPSHOT_PROVISIONER is provisioner used for nodes created from snapshots
+// of templates without provisioning spec, it only updates build user password
+const SNAPSHOT_PROVISIONER = `
+  provisioner "remote-exec" {
+    inline = [
+      "echo 'Updating build node user password...'",
+      "sed -i 's#^builder:[^:]*:#builder:${var.auth}:#' /etc/shadow",
+      "echo 'Build node configuration complete'"
+    ]
+  }
+`
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// prepareWorkDir render template with provisioning spec and baked images to
+// working directory and return path to directory which must be used for
+// running terraform
+func prepareWorkDir(p *prefs.Preferences, workDir string, fromSnapshot bool) (string, error) {
+	templateDir := path.Join(getDataDir(), p.Template)
+	specFile := path.Join(templateDir, provision.SPEC_FILE)
+
+	// Remove previously rendered template
+	os.RemoveAll(workDir)
+
+	if !fsutil.IsExist(specFile) && !fromSnapshot {
+		return templateDir, nil
+	}
+
+	var (
+		err    error
+		spec   *provision.Spec
+		images map[string]*ImageRecord
+	)
+
+	if fsutil.IsExist(specFile) {
+		spec, err = provision.Read(specFile)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	builders := getBuilderConfigs(templateDir)
+
+	if fromSnapshot {
+		var nodes []string
+
+		for _, node := range builders {
+			nodes = append(nodes, node)
+		}
+
+		images, err = getSnapshotImages(p, nodes)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = fsutil.CopyDir(templateDir, workDir)
+
+	if err != nil {
+		return "", fmtc.Errorf("Can't copy template: %v", err)
+	}
+
+	for builder, node := range builders {
+		file := path.Join(workDir, builder)
+
+		data, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			return "", err
+		}
+
+		config := string(data)
+
+		switch {
+		case fromSnapshot:
+			config = terraform.SetAttribute(config, "image", strconv.Itoa(images[node].ID))
+			config = terraform.RemoveProvisioners(config, "remote-exec")
+			config = renderSnapshotProvisioners(config, spec, node)
+		default:
+			config = renderSpecProvisioners(config, spec.ForNode(node))
+		}
+
+		err = ioutil.WriteFile(file, []byte(config), 0644)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return workDir, nil
+}
+
+// renderSpecProvisioners add provisioners for given spec to configuration
+func renderSpecProvisioners(config string, spec *provision.Spec) string {
+	config = terraform.AddBlock(config, terraform.RenderRemoteExec(
+		provision.Script(spec.Steps(AUTH_VAR)),
+	))
+
+	for _, file := range spec.Files {
+		config = terraform.AddBlock(config, terraform.RenderFile(file.Source, file.Destination))
+	}
+
+	postSteps := spec.PostSteps()
+
+	if len(postSteps) != 0 {
+		config = terraform.AddBlock(config, terraform.RenderRemoteExec(
+			provision.Script(postSteps),
+		))
+	}
+
+	return config
+}
+
+// renderSnapshotProvisioners add provisioners for node created from
+// snapshot to configuration
+func renderSnapshotProvisioners(config string, spec *provision.Spec, node string) string {
+	if spec == nil {
+		return terraform.AddBlock(config, SNAPSHOT_PROVISIONER)
+	}
+
+	steps := spec.ForNode(node).PasswordSteps(AUTH_VAR)
+
+	if len(steps) == 0 {
+		return config
+	}
+
+	return terraform.AddBlock(config, terraform.RenderRemoteExec(provision.Script(steps)))
+}
+
+// getBuilderConfigs return map config file -> node name for all builders
+// in template
+func getBuilderConfigs(templateDir string) map[string]string {
+	result := make(map[string]string)
+
+	builders := fsutil.List(
+		templateDir, true,
+		fsutil.ListingFilter{MatchPatterns: []string{"builder*.tf"}},
+	)
+
+	for _, builder := range builders {
+		data, err := ioutil.ReadFile(path.Join(templateDir, builder))
+
+		if err != nil {
+			continue
+		}
+
+		result[builder] = terraform.GetResourceName(string(data))
+	}
+
+	return result
+}
+
+// getFarmWorkDir return path to directory with terraform configuration
+// used for creating farm
+func getFarmWorkDir(farmState *FarmState) string {
+	return getTemplateWorkDir(farmState.Preferences.Template)
+}
+
+// getTemplateWorkDir return path to rendered template directory if it
+// exists or path to template directory
+func getTemplateWorkDir(template string) string {
+	if fsutil.IsDir(getWorkDir(template)) {
+		return getWorkDir(template)
+	}
+
+	return path.Join(getDataDir(), template)
+}
+
+// getWorkDir return path to working directory with rendered template
+func getWorkDir(template string) string {
+	return path.Join(getDataDir(), WORK_DIR_PREFIX+template)
+}
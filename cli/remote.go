@@ -10,6 +10,10 @@ package cli
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -19,68 +23,179 @@ import (
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+// NODE_METRICS_SCRIPT is script used for collecting info about build node state
+// in one SSH round-trip, user name must be passed as format argument
+const NODE_METRICS_SCRIPT = `LOCK=/home/%[1]s/.buildlock
+if [[ -f $LOCK ]] ; then
+  echo "lock:$(stat -c '%%Y' $LOCK)"
+  echo "build:$(head -1 $LOCK)"
+fi
+echo "load:$(cat /proc/loadavg)"
+echo "uptime:$(cat /proc/uptime)"
+df -P -B1 /home/%[1]s | tail -1 | awk '{print "disk:" $2 " " $4}'
+awk '/^MemTotal:/{t=$2} /^(MemFree|Buffers|Cached):/{f+=$2} END {print "mem:" t*1024 " " (t-f)*1024}' /proc/meminfo
+`
+
+// NODE_METRICS_TIMEOUT is max time of collecting metrics from build node
+const NODE_METRICS_TIMEOUT = 10 * time.Second
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
 // getBuildNodesInfo return list of with info about build nodes
 func getBuildNodesInfo(p *prefs.Preferences) []*NodeInfo {
-	keyData, err := ioutil.ReadFile(p.Key)
+	sshConfig, err := getSSHConfig(p)
 
 	if err != nil {
 		fmt.Println(err.Error())
 		return []*NodeInfo{}
 	}
 
-	signer, err := ssh.ParsePrivateKey(keyData)
+	nodes, err := collectNodesInfo(p)
 
 	if err != nil {
 		fmt.Println(err.Error())
 		return []*NodeInfo{}
 	}
 
-	sshConfig := &ssh.ClientConfig{
+	var wg sync.WaitGroup
+
+	// Metrics are collected in parallel, so one slow node doesn't delay
+	// info about others
+	for _, node := range nodes {
+		wg.Add(1)
+
+		go func(node *NodeInfo) {
+			defer wg.Done()
+
+			output, err := execRemoteCommand(
+				node, sshConfig,
+				fmt.Sprintf(NODE_METRICS_SCRIPT, p.User),
+			)
+
+			if err != nil {
+				node.State = STATE_DOWN
+				return
+			}
+
+			parseNodeMetrics(node, output)
+		}(node)
+	}
+
+	wg.Wait()
+
+	return nodes
+}
+
+// getSSHConfig return SSH client config for connecting to build nodes
+func getSSHConfig(p *prefs.Preferences) (*ssh.ClientConfig, error) {
+	keyData, err := ioutil.ReadFile(p.Key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(keyData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
 		User: "root",
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(signer),
 		},
 		Timeout: time.Second,
+	}, nil
+}
+
+// execRemoteCommand execute command on build node and return its output,
+// command execution time is limited by NODE_METRICS_TIMEOUT
+func execRemoteCommand(node *NodeInfo, sshConfig *ssh.ClientConfig, command string) (string, error) {
+	conn, err := net.DialTimeout("tcp", node.SSHAddress(), sshConfig.Timeout)
+
+	if err != nil {
+		return "", err
 	}
 
-	nodes, err := collectNodesInfo(p)
+	// Deadline limits handshake and command execution
+	conn.SetDeadline(time.Now().Add(NODE_METRICS_TIMEOUT))
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, node.SSHAddress(), sshConfig)
 
 	if err != nil {
-		fmt.Println(err.Error())
-		return []*NodeInfo{}
+		conn.Close()
+		return "", err
 	}
 
-	for _, node := range nodes {
-		client, err := ssh.Dial("tcp", node.IP+":22", sshConfig)
+	client := ssh.NewClient(clientConn, chans, reqs)
 
-		if err != nil {
-			node.State = STATE_DOWN
-			continue
-		}
+	defer client.Close()
+
+	session, err := client.NewSession()
+
+	if err != nil {
+		return "", err
+	}
 
-		session, err := client.NewSession()
+	defer session.Close()
 
-		if err != nil {
-			node.State = STATE_DOWN
-			client.Close()
+	output, err := session.Output(command)
+
+	return string(output), err
+}
+
+// parseNodeMetrics parse output of metrics script and fill node info
+func parseNodeMetrics(node *NodeInfo, output string) {
+	node.State = STATE_INACTIVE
+
+	for _, line := range strings.Split(output, "\n") {
+		sepIndex := strings.Index(line, ":")
+
+		if sepIndex == -1 {
 			continue
 		}
 
-		_, err = session.Output(
-			fmt.Sprintf("stat -c '%%Y' /home/%s/.buildlock", p.User),
-		)
+		name := line[:sepIndex]
+		value := strings.TrimSpace(line[sepIndex+1:])
 
-		if err == nil {
+		switch name {
+		case "lock":
 			node.State = STATE_ACTIVE
-		} else {
-			node.State = STATE_INACTIVE
-		}
 
-		session.Close()
-		client.Close()
-	}
+		case "build":
+			node.Build = value
 
-	return nodes
+		case "load":
+			for i, load := range strings.Fields(value) {
+				if i > 2 {
+					break
+				}
+
+				node.Load[i], _ = strconv.ParseFloat(load, 64)
+			}
+
+		case "uptime":
+			uptime, _ := strconv.ParseFloat(strings.Fields(value + " 0")[0], 64)
+			node.Uptime = int64(uptime)
+
+		case "disk":
+			diskInfo := strings.Fields(value)
+
+			if len(diskInfo) == 2 {
+				node.DiskTotal, _ = strconv.ParseUint(diskInfo[0], 10, 64)
+				node.DiskFree, _ = strconv.ParseUint(diskInfo[1], 10, 64)
+			}
+
+		case "mem":
+			memInfo := strings.Fields(value)
+
+			if len(memInfo) == 2 {
+				node.MemTotal, _ = strconv.ParseUint(memInfo[0], 10, 64)
+				node.MemUsed, _ = strconv.ParseUint(memInfo[1], 10, 64)
+			}
+		}
+	}
 }
 
 // getActiveBuildNodesCount return number of build nodes with active
@@ -122,3 +237,23 @@ func getActiveBuildNodesNames(p *prefs.Preferences) []string {
 
 	return result
 }
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// SSHAddress return address of SSH server on build node
+func (n *NodeInfo) SSHAddress() string {
+	if n.Port == 0 {
+		return net.JoinHostPort(n.IP, "22")
+	}
+
+	return net.JoinHostPort(n.IP, strconv.Itoa(n.Port))
+}
+
+// getSSHCommand return command for connecting to build node
+func getSSHCommand(user, ip string, port int) string {
+	if port == 0 || port == 22 {
+		return fmt.Sprintf("ssh %s@%s", user, ip)
+	}
+
+	return fmt.Sprintf("ssh -p %d %s@%s", port, user, ip)
+}
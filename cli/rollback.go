@@ -0,0 +1,238 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/fsutil"
+	"pkg.re/essentialkaos/ek.v9/pluralize"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+
+	"github.com/essentialkaos/terrafarm/notifier"
+	"github.com/essentialkaos/terrafarm/prefs"
+	"github.com/essentialkaos/terrafarm/terraform"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// applyFarm create farm nodes and retry creation of failed nodes if it
+// allowed by preferences
+func applyFarm(p *prefs.Preferences, workDir string, vars []string) error {
+	fsutil.Push(workDir)
+
+	defer fsutil.Pop()
+
+	err := execTerraform(false, "apply", vars)
+
+	if err == nil || p.OnFailure != prefs.FAILURE_RETRY {
+		return err
+	}
+
+	delay := time.Duration(p.RetryDelay) * time.Second
+
+	for attempt := 1; attempt <= p.RetryAttempts; attempt++ {
+		failedNodes := getFailedNodes(workDir)
+
+		if len(failedNodes) == 0 {
+			return err
+		}
+
+		terminal.PrintWarnMessage("\nError while executing terraform: %v", err)
+		fmtutil.Separator(false)
+
+		fmtc.Printf(
+			"Retrying creation of %s in %s {s-}(attempt %d/%d){!}...\n\n",
+			strings.Join(failedNodes, ", "), timeutil.PrettyDuration(delay),
+			attempt, p.RetryAttempts,
+		)
+
+		time.Sleep(delay)
+
+		var targets []string
+
+		// Terraform marks nodes with failed provisioning as tainted, so
+		// they will be recreated
+		for _, node := range failedNodes {
+			targets = append(targets, "-target="+getStateNodeAddress(getTemplateProvider(p.Template), node))
+		}
+
+		err = execTerraform(false, "apply", append(vars, targets...))
+
+		if err == nil {
+			return nil
+		}
+
+		delay *= 2
+	}
+
+	return err
+}
+
+// getFailedNodes return names of resources which were not created or
+// were not provisioned
+func getFailedNodes(workDir string) []string {
+	var result []string
+
+	resources := make(map[string]bool)
+	tfState, err := terraform.ReadState(getTerraformStateFilePath())
+
+	if err == nil && len(tfState.Modules) != 0 {
+		for address, resource := range getNodeResources(tfState) {
+			if resource.Info != nil {
+				resources[address[strings.Index(address, ".")+1:]] = !resource.Info.Tainted
+			}
+		}
+	}
+
+	for _, name := range getBuilderConfigs(workDir) {
+		if !resources[name] {
+			result = append(result, name)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// resumeCommand continue creation of partially created farm
+func resumeCommand(userPrefs *prefs.Preferences) {
+	if !isTerrafarmActive() {
+		terminal.PrintWarnMessage("Farm is not created, nothing to resume")
+		exit(1)
+	}
+
+	p := userPrefs
+	fromSnapshot := false
+
+	// Current moment + 90 seconds for starting droplets
+	farmStartTime := time.Now().Unix() + 90
+
+	farmState, err := readFarmState()
+
+	if err == nil {
+		p = farmState.Preferences
+		p.Token = userPrefs.Token
+		p.Password = userPrefs.Password
+		fromSnapshot = farmState.FromSnapshot
+		farmStartTime = farmState.Started
+	}
+
+	workDir := getTemplateWorkDir(p.Template)
+	failedNodes := getFailedNodes(workDir)
+
+	if len(failedNodes) == 0 && farmState != nil && !farmState.Failed {
+		terminal.PrintWarnMessage("All farm nodes are created, nothing to resume")
+		exit(1)
+	}
+
+	// Monitor can be started for destroying failed farm
+	if isMonitorActive() {
+		killMonitorProcess()
+		deleteMonitorStateFile()
+	}
+
+	fmtutil.Separator(false)
+
+	fmtc.Printf(
+		"Resuming creation of farm {*}%s{!} {s-}(%s){!}...\n\n",
+		p.Template, pluralize.Pluralize(len(failedNodes), "failed node", "failed nodes"),
+	)
+
+	vars, err := prefsToArgs(p)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't parse preferences: %v", err)
+		exit(1)
+	}
+
+	err = applyFarm(p, workDir, vars)
+
+	if err != nil {
+		terminal.PrintErrorMessage("\nError while executing terraform: %v", err)
+		fmtutil.Separator(false)
+		rollbackFarm(p, workDir, farmStartTime, fromSnapshot)
+		notify()
+		exit(1)
+	}
+
+	fmtutil.Separator(false)
+
+	if farmState != nil {
+		deleteFarmStateFile()
+	}
+
+	completeFarmCreation(p, farmStartTime, fromSnapshot)
+}
+
+// rollbackFarm destroy all created nodes after failed farm creation, if
+// nodes can't be destroyed, farm state will be saved and monitor will
+// try to destroy nodes later
+func rollbackFarm(p *prefs.Preferences, workDir string, farmStartTime int64, fromSnapshot bool) {
+	if !isTerrafarmActive() {
+		return
+	}
+
+	fmtc.Println("Destroying created droplets...\n")
+
+	vars, err := prefsToArgs(p, "-force")
+
+	if err == nil {
+		printDebug("EXEC → terraform destroy %s", strings.Join(vars, " "))
+
+		fsutil.Push(workDir)
+		err = execTerraform(false, "destroy", vars)
+		fsutil.Pop()
+	}
+
+	fmtutil.Separator(false)
+
+	if err == nil {
+		fmtc.Println("{g}Created droplets successfully destroyed{!}")
+		fmtutil.Separator(false)
+		return
+	}
+
+	terminal.PrintErrorMessage("Can't destroy created droplets: %v", err)
+
+	sendNotification(p, notifier.NewEvent(
+		notifier.EVENT_DESTROY_FAILED, p.Template,
+		fmtc.Sprintf("Can't destroy droplets after failed farm creation: %v", err),
+	))
+
+	farmState := saveState(p, farmStartTime, fromSnapshot)
+	farmState.Failed = true
+
+	err = updateFarmState(farmState)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't save farm state: %v", err)
+		return
+	}
+
+	err = saveMonitorState(&MonitorState{DestroyAfter: time.Now().Unix()})
+
+	if err == nil {
+		err = startMonitorProcess()
+	}
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't start monitor: %v", err)
+		terminal.PrintWarnMessage("Use destroy command for destroying created droplets")
+	} else {
+		terminal.PrintWarnMessage("Monitor started and will try to destroy created droplets")
+	}
+
+	fmtutil.Separator(false)
+}
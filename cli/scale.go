@@ -0,0 +1,509 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/fsutil"
+	"pkg.re/essentialkaos/ek.v9/options"
+	"pkg.re/essentialkaos/ek.v9/path"
+	"pkg.re/essentialkaos/ek.v9/pluralize"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+	"github.com/essentialkaos/terrafarm/provider"
+	"github.com/essentialkaos/terrafarm/terraform"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ScaleAddition contains info about nodes which must be added to farm
+type ScaleAddition struct {
+	Kind  string // Builder config name without "builder-" prefix
+	Count int
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// scaledNodeSuffix is regexp for numeric suffix of nodes added by scale command
+var scaledNodeSuffix = regexp.MustCompile(`-[0-9]+$`)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// scaleCommand is scale command handler
+func scaleCommand(prefs *prefs.Preferences) {
+	if !isTerrafarmActive() {
+		terminal.PrintWarnMessage("Terrafarm does not works, nothing to scale")
+		exit(1)
+	}
+
+	if !options.Has(OPT_ADD) && !options.Has(OPT_REMOVE) {
+		terminal.PrintErrorMessage("You must define nodes for adding (--add) or removing (--remove)")
+		exit(1)
+	}
+
+	farmState, err := readFarmState()
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't read farm state: %v", err)
+		exit(1)
+	}
+
+	p := farmState.Preferences
+	p.Token = prefs.Token
+	p.Password = prefs.Password
+
+	additions, err := parseScaleAdditions(p.Template, options.GetS(OPT_ADD))
+
+	if err != nil {
+		terminal.PrintErrorMessage("%v", err)
+		exit(1)
+	}
+
+	removals, err := parseScaleRemovals(options.GetS(OPT_REMOVE))
+
+	if err != nil {
+		terminal.PrintErrorMessage("%v", err)
+		exit(1)
+	}
+
+	if len(removals) != 0 {
+		checkRemovedNodesBuilds(p, removals)
+	}
+
+	if !options.GetB(OPT_FORCE) {
+		yes, err := terminal.ReadAnswer(
+			fmtc.Sprintf(
+				"Add %s and remove %s?",
+				pluralize.Pluralize(getScaleAdditionsCount(additions), "node", "nodes"),
+				pluralize.Pluralize(len(removals), "node", "nodes"),
+			), "n",
+		)
+
+		if !yes || err != nil {
+			fmtc.NewLine()
+			cancelAudit()
+			return
+		}
+
+		fmtutil.Separator(false)
+	}
+
+	// Fill info about nodes for states created by previous versions
+	fillFarmNodes(p.Token, farmState, farmState.Started)
+
+	workDir, err := prepareScaleWorkDir(farmState)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't prepare template: %v", err)
+		exit(1)
+	}
+
+	if len(removals) != 0 {
+		err = removeFarmNodes(p, farmState, workDir, removals)
+
+		if err != nil {
+			terminal.PrintErrorMessage("\nError while removing nodes: %v", err)
+			notify()
+			exit(1)
+		}
+	}
+
+	if len(additions) != 0 {
+		err = addFarmNodes(p, farmState, workDir, additions)
+
+		if err != nil {
+			terminal.PrintErrorMessage("\nError while adding nodes: %v", err)
+			notify()
+			exit(1)
+		}
+	}
+
+	notify()
+}
+
+// removeFarmNodes destroy nodes with given names (droplet name -> resource
+// name) and remove their configuration
+func removeFarmNodes(p *prefs.Preferences, farmState *FarmState, workDir string, removals map[string]string) error {
+	var targets []string
+
+	for _, resource := range removals {
+		targets = append(targets, "-target="+getStateNodeAddress(getFarmProvider(farmState), resource))
+	}
+
+	sort.Strings(targets)
+
+	vars, err := prefsToArgs(p, append([]string{"-force"}, targets...)...)
+
+	if err != nil {
+		return fmtc.Errorf("Can't parse prefs: %v", err)
+	}
+
+	printDebug("EXEC → terraform destroy %s", strings.Join(vars, " "))
+
+	fsutil.Push(workDir)
+
+	err = execTerraform(false, "destroy", vars)
+
+	fsutil.Pop()
+
+	if err != nil {
+		return err
+	}
+
+	fmtutil.Separator(false)
+
+	destroyed := time.Now().Unix()
+
+	for builder, resource := range getBuilderConfigs(workDir) {
+		if !isMapValue(removals, resource) {
+			continue
+		}
+
+		err = os.Remove(path.Join(workDir, builder))
+
+		if err != nil {
+			return fmtc.Errorf("Can't remove configuration %s: %v", builder, err)
+		}
+	}
+
+	for _, node := range farmState.Nodes {
+		if node.Destroyed == 0 && isMapValue(removals, node.Name) {
+			node.Destroyed = destroyed
+		}
+	}
+
+	err = updateFarmState(farmState)
+
+	if err != nil {
+		return fmtc.Errorf("Can't save farm state: %v", err)
+	}
+
+	for name := range removals {
+		fmtc.Printf("  {g}✔ {!} %s removed\n", name)
+	}
+
+	fmtutil.Separator(false)
+
+	return nil
+}
+
+// addFarmNodes create configuration for new nodes and create them
+func addFarmNodes(p *prefs.Preferences, farmState *FarmState, workDir string, additions []*ScaleAddition) error {
+	var resources, targets []string
+
+	configs := make(map[string]string)
+
+	for _, addition := range additions {
+		for i := 0; i < addition.Count; i++ {
+			resource, config, err := addNodeConfig(p.Template, workDir, addition.Kind)
+
+			if err != nil {
+				return err
+			}
+
+			configs[resource] = config
+			resources = append(resources, resource)
+			targets = append(targets, "-target="+getStateNodeAddress(getFarmProvider(farmState), resource))
+		}
+	}
+
+	vars, err := prefsToArgs(p, targets...)
+
+	if err != nil {
+		return fmtc.Errorf("Can't parse prefs: %v", err)
+	}
+
+	printDebug("EXEC → terraform apply %s", strings.Join(vars, " "))
+
+	// Current moment + 90 seconds for starting droplets
+	started := time.Now().Unix() + 90
+
+	fsutil.Push(workDir)
+
+	err = execTerraform(false, "apply", vars)
+
+	fsutil.Pop()
+
+	// Nodes can be partially created, so we save info about all nodes
+	// which exist in terraform state
+	fillFarmNodes(p.Token, farmState, started)
+
+	stateNodes := getStateNodes()
+
+	for _, resource := range resources {
+		if !isMapValue(stateNodes, resource) {
+			os.Remove(configs[resource])
+		}
+	}
+
+	saveErr := updateFarmState(farmState)
+
+	if err != nil {
+		return err
+	}
+
+	if saveErr != nil {
+		return fmtc.Errorf("Can't save farm state: %v", saveErr)
+	}
+
+	fmtutil.Separator(false)
+
+	nodes := getAddedNodesInfo(p, resources)
+
+	fmtc.Println("Access credentials for added build nodes:\n")
+
+	for _, node := range nodes {
+		fmtc.Printf(
+			"  {*}%20s{!}: %s {s-}(Password: %s){!}\n",
+			node.Name, getSSHCommand(node.User, node.IP, node.Port), node.Password,
+		)
+	}
+
+	fmtutil.Separator(false)
+
+	applyExtras(p, nodes)
+
+	return nil
+}
+
+// addNodeConfig create configuration for new node with given kind and
+// return name of terraform resource and path to configuration file
+func addNodeConfig(template, workDir, kind string) (string, string, error) {
+	index := 2
+
+	for fsutil.IsExist(getNodeConfigPath(workDir, kind, index)) {
+		index++
+	}
+
+	return writeNodeConfig(template, workDir, kind, index)
+}
+
+// writeNodeConfig create configuration for node with given kind and index
+// and return name of terraform resource and path to configuration file
+func writeNodeConfig(template, workDir, kind string, index int) (string, string, error) {
+	baseData, err := ioutil.ReadFile(path.Join(getDataDir(), template, "builder-"+kind+".tf"))
+
+	if err != nil {
+		return "", "", err
+	}
+
+	baseResource := terraform.GetResourceName(string(baseData))
+	baseDroplet := terraform.GetAttribute(string(baseData), "name")
+
+	source := findNodeConfig(workDir, kind)
+
+	if source == "" {
+		return "", "", fmtc.Errorf("Can't find configuration for nodes %s", kind)
+	}
+
+	data, err := ioutil.ReadFile(source)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	suffix := "-" + strconv.Itoa(index)
+	config := terraform.SetResourceName(string(data), baseResource+suffix)
+	config = terraform.SetAttribute(config, "name", baseDroplet+suffix)
+
+	file := getNodeConfigPath(workDir, kind, index)
+	err = ioutil.WriteFile(file, []byte(config), 0644)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return baseResource + suffix, file, nil
+}
+
+// getNodeConfigPath return path to configuration of node added by scale
+// command
+func getNodeConfigPath(workDir, kind string, index int) string {
+	return path.Join(workDir, fmt.Sprintf("builder-%s-%d.tf", kind, index))
+}
+
+// findNodeConfig return path to rendered configuration of node with given
+// kind in working directory
+func findNodeConfig(workDir, kind string) string {
+	builders := fsutil.List(
+		workDir, true,
+		fsutil.ListingFilter{MatchPatterns: []string{"builder-" + kind + "*.tf"}},
+	)
+
+	sort.Strings(builders)
+
+	for _, builder := range builders {
+		name := strings.TrimSuffix(strings.TrimPrefix(builder, "builder-"), ".tf")
+
+		if name == kind || scaledNodeSuffix.ReplaceAllString(name, "") == kind {
+			return path.Join(workDir, builder)
+		}
+	}
+
+	return ""
+}
+
+// prepareScaleWorkDir return path to working directory of farm, if farm was
+// created right from template directory, template will be copied to working
+// directory
+func prepareScaleWorkDir(farmState *FarmState) (string, error) {
+	workDir := getWorkDir(farmState.Preferences.Template)
+
+	if fsutil.IsDir(workDir) {
+		return workDir, nil
+	}
+
+	err := fsutil.CopyDir(path.Join(getDataDir(), farmState.Preferences.Template), workDir)
+
+	if err != nil {
+		return "", fmtc.Errorf("Can't copy template: %v", err)
+	}
+
+	return workDir, nil
+}
+
+// checkRemovedNodesBuilds check that removed nodes have no active builds
+func checkRemovedNodesBuilds(p *prefs.Preferences, removals map[string]string) {
+	if options.GetB(OPT_FORCE) {
+		return
+	}
+
+	for _, node := range getBuildNodesInfo(p) {
+		if removals[node.Name] == "" || node.State != STATE_ACTIVE {
+			continue
+		}
+
+		terminal.PrintErrorMessage("Node %s has active build process", node.Name)
+		terminal.PrintWarnMessage("Use --force option for removing nodes with active builds")
+		exit(1)
+	}
+}
+
+// parseScaleAdditions parse list of nodes for adding (kind=num)
+func parseScaleAdditions(template, data string) ([]*ScaleAddition, error) {
+	var result []*ScaleAddition
+
+	if data == "" {
+		return nil, nil
+	}
+
+	for _, item := range strings.Split(data, ",") {
+		item = strings.TrimSpace(item)
+		addition := &ScaleAddition{Kind: item, Count: 1}
+
+		if strings.Contains(item, "=") {
+			count, err := strconv.Atoi(item[strings.Index(item, "=")+1:])
+
+			if err != nil || count <= 0 {
+				return nil, fmtc.Errorf("Number of nodes in %s is not valid", item)
+			}
+
+			addition.Kind = item[:strings.Index(item, "=")]
+			addition.Count = count
+		}
+
+		if !fsutil.IsExist(path.Join(getDataDir(), template, "builder-"+addition.Kind+".tf")) {
+			return nil, fmtc.Errorf("Template %s doesn't contain nodes %s", template, addition.Kind)
+		}
+
+		result = append(result, addition)
+	}
+
+	return result, nil
+}
+
+// parseScaleRemovals parse list of nodes for removing and return map
+// droplet name -> resource name
+func parseScaleRemovals(data string) (map[string]string, error) {
+	result := make(map[string]string)
+
+	if data == "" {
+		return result, nil
+	}
+
+	stateNodes := getStateNodes()
+
+	for _, name := range strings.Split(data, ",") {
+		name = strings.TrimSpace(name)
+
+		switch {
+		case stateNodes[name] != "":
+			result[name] = stateNodes[name]
+		case isMapValue(stateNodes, name):
+			for droplet, resource := range stateNodes {
+				if resource == name {
+					result[droplet] = resource
+				}
+			}
+		default:
+			return nil, fmtc.Errorf("Farm doesn't contain node %s", name)
+		}
+	}
+
+	if len(result) >= len(stateNodes) && !options.Has(OPT_ADD) {
+		return nil, fmtc.Errorf("Can't remove all nodes from farm, use destroy command instead")
+	}
+
+	return result, nil
+}
+
+// getAddedNodesInfo return info about nodes with given resource names
+func getAddedNodesInfo(p *prefs.Preferences, resources []string) []*NodeInfo {
+	var result []*NodeInfo
+
+	nodes, _ := collectNodesInfo(p)
+	stateNodes := getStateNodes()
+
+	for _, node := range nodes {
+		for _, resource := range resources {
+			if stateNodes[node.Name] == resource {
+				result = append(result, node)
+			}
+		}
+	}
+
+	return result
+}
+
+// getScaleAdditionsCount return total number of added nodes
+func getScaleAdditionsCount(additions []*ScaleAddition) int {
+	var result int
+
+	for _, addition := range additions {
+		result += addition.Count
+	}
+
+	return result
+}
+
+// getStateNodeAddress return terraform address of node resource
+func getStateNodeAddress(prov provider.Provider, resource string) string {
+	return prov.ResourceType() + "." + resource
+}
+
+// isMapValue return true if map contains given value
+func isMapValue(data map[string]string, value string) bool {
+	for _, v := range data {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,653 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/jsonutil"
+	"pkg.re/essentialkaos/ek.v9/log"
+	"pkg.re/essentialkaos/ek.v9/passwd"
+	"pkg.re/essentialkaos/ek.v9/path"
+	"pkg.re/essentialkaos/ek.v9/sliceutil"
+	"pkg.re/essentialkaos/ek.v9/terminal"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// SERVER_STATE_FILE is name of file with server state
+const SERVER_STATE_FILE = ".server-state"
+
+// EV_SERVER_USER is environment variable with name of server user which
+// executes command
+const EV_SERVER_USER = "TERRAFARM_SERVER_USER"
+
+// List of server API methods
+const (
+	API_TEMPLATES   = "/api/templates"
+	API_STATUS      = "/api/status"
+	API_CREDENTIALS = "/api/credentials"
+	API_CREATE      = "/api/create"
+	API_PROLONG     = "/api/prolong"
+	API_DESTROY     = "/api/destroy"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// ServerUser contains info about server API user
+type ServerUser struct {
+	Name  string
+	Token string
+	Quota int64 // Max farm time per month in minutes, 0 if unlimited
+	Admin bool  // Admin can manage farms created by other users
+}
+
+// ServerState contains info about farm owner and users quotas usage
+type ServerState struct {
+	Owner    string                  `json:"owner,omitempty"`
+	Password string                  `json:"password,omitempty"`
+	Usage    map[string]*ServerUsage `json:"usage"`
+}
+
+// ServerUsage contains info about user quota usage
+type ServerUsage struct {
+	Month   string `json:"month"`
+	Minutes int64  `json:"minutes"`
+}
+
+// ServerRequest contains server API request
+type ServerRequest struct {
+	Template string `json:"template,omitempty"`
+	TTL      string `json:"ttl,omitempty"`
+	MaxWait  string `json:"max_wait,omitempty"`
+	NodeSize string `json:"node_size,omitempty"`
+	Region   string `json:"region,omitempty"`
+}
+
+// ServerResponse contains server API response
+type ServerResponse struct {
+	Error     string            `json:"error,omitempty"`
+	Output    string            `json:"output,omitempty"`
+	Status    *ServerStatus     `json:"status,omitempty"`
+	Templates []*ServerTemplate `json:"templates,omitempty"`
+	Nodes     []*ServerNode     `json:"nodes,omitempty"`
+
+	code int // HTTP status code for response with error
+}
+
+// ServerStatus contains info about farm
+type ServerStatus struct {
+	Active       bool    `json:"active"`
+	Template     string  `json:"template,omitempty"`
+	Owner        string  `json:"owner,omitempty"`
+	Nodes        int     `json:"nodes,omitempty"`
+	Region       string  `json:"region,omitempty"`
+	NodeSize     string  `json:"node_size,omitempty"`
+	Started      int64   `json:"started,omitempty"`
+	DestroyAfter int64   `json:"destroy_after,omitempty"`
+	MaxWait      int64   `json:"max_wait,omitempty"`
+	Price        float64 `json:"price,omitempty"`
+}
+
+// ServerTemplate contains info about farm template
+type ServerTemplate struct {
+	Name  string `json:"name"`
+	Nodes int    `json:"nodes"`
+}
+
+// ServerNode contains build node access credentials
+type ServerNode struct {
+	Name     string `json:"name"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port,omitempty"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// serverUsers contains server API users
+var serverUsers []*ServerUser
+
+// serverPrefs contains server preferences
+var serverPrefs *prefs.Preferences
+
+// serverArgRegex is regexp for validation of node size and region
+var serverArgRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// serverLock is used for serializing state changing requests
+var serverLock sync.Mutex
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// serveCommand is serve command handler
+func serveCommand(p *prefs.Preferences) {
+	var err error
+
+	if p.ServerUsers == "" {
+		terminal.PrintErrorMessage("Property %s must be set for server mode", prefs.SERVER_USERS)
+		exit(1)
+	}
+
+	serverUsers, err = readServerUsers(p.ServerUsers)
+
+	if err != nil {
+		terminal.PrintErrorMessage("Can't read users file: %v", err)
+		exit(1)
+	}
+
+	serverPrefs = p
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(API_TEMPLATES, getServerHandler(false, templatesHandler))
+	mux.HandleFunc(API_STATUS, getServerHandler(false, statusHandler))
+	mux.HandleFunc(API_CREDENTIALS, getServerHandler(false, credentialsHandler))
+	mux.HandleFunc(API_CREATE, getServerHandler(true, createHandler))
+	mux.HandleFunc(API_PROLONG, getServerHandler(true, prolongHandler))
+	mux.HandleFunc(API_DESTROY, getServerHandler(true, destroyHandler))
+
+	log.Aux(SEPARATOR)
+	log.Aux("Terrafarm %s server started on %s", VER, p.ServerListen)
+
+	if p.ServerCert != "" {
+		err = http.ListenAndServeTLS(p.ServerListen, p.ServerCert, p.ServerKey, mux)
+	} else {
+		err = http.ListenAndServe(p.ServerListen, mux)
+	}
+
+	if err != nil {
+		log.Crit("Can't start server: %v", err)
+		exit(1)
+	}
+}
+
+// getServerHandler return HTTP handler which authenticates user and
+// calls given API method handler
+func getServerHandler(post bool, handler func(*ServerUser, *ServerRequest) *ServerResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var response *ServerResponse
+
+		user := authenticateServerUser(r)
+		request := &ServerRequest{}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case user == nil:
+			w.WriteHeader(http.StatusUnauthorized)
+			response = &ServerResponse{Error: "Unauthorized"}
+
+		case post && r.Method != http.MethodPost:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			response = &ServerResponse{Error: "Method not allowed"}
+
+		case post && json.NewDecoder(r.Body).Decode(request) != nil:
+			w.WriteHeader(http.StatusBadRequest)
+			response = &ServerResponse{Error: "Can't decode request"}
+
+		default:
+			log.Info("%s → %s %s", user.Name, r.Method, r.URL.Path)
+
+			if post {
+				serverLock.Lock()
+				response = handler(user, request)
+				serverLock.Unlock()
+			} else {
+				response = handler(user, request)
+			}
+
+			if response.Error != "" {
+				log.Error("%s → %s: %s", user.Name, r.URL.Path, response.Error)
+
+				if response.code == 0 {
+					response.code = http.StatusConflict
+				}
+
+				w.WriteHeader(response.code)
+			}
+		}
+
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// templatesHandler is templates API method handler
+func templatesHandler(user *ServerUser, request *ServerRequest) *ServerResponse {
+	response := &ServerResponse{}
+
+	for _, template := range getTemplates() {
+		response.Templates = append(response.Templates, &ServerTemplate{
+			Name:  template,
+			Nodes: getBuildNodesCount(template),
+		})
+	}
+
+	return response
+}
+
+// statusHandler is status API method handler
+func statusHandler(user *ServerUser, request *ServerRequest) *ServerResponse {
+	if isRemoteStateBackend() {
+		pullState()
+	}
+
+	status := &ServerStatus{Active: isTerrafarmActive()}
+
+	if !status.Active {
+		return &ServerResponse{Status: status}
+	}
+
+	farmState, err := readFarmState()
+
+	if err != nil {
+		return &ServerResponse{Error: err.Error()}
+	}
+
+	status.Template = farmState.Preferences.Template
+	status.Owner = readServerState().Owner
+	status.Nodes = getFarmNodesCount(farmState)
+	status.Region = farmState.Preferences.Region
+	status.NodeSize = farmState.Preferences.NodeSize
+	status.Started = farmState.Started
+	status.Price = getFarmUsagePrice(farmState)
+
+	state, err := readMonitorState()
+
+	if err == nil {
+		status.DestroyAfter = state.DestroyAfter
+		status.MaxWait = state.MaxWait
+	}
+
+	return &ServerResponse{Status: status}
+}
+
+// credentialsHandler is credentials API method handler
+func credentialsHandler(user *ServerUser, request *ServerRequest) *ServerResponse {
+	farmState, err := readFarmState()
+
+	if err != nil {
+		return &ServerResponse{Error: "Farm is not created"}
+	}
+
+	if !isServerFarmOwner(user) {
+		return getForbiddenResponse()
+	}
+
+	p := farmState.Preferences
+	p.Password = readServerState().Password
+
+	nodes, err := collectNodesInfo(p)
+
+	if err != nil {
+		return &ServerResponse{Error: err.Error()}
+	}
+
+	response := &ServerResponse{}
+
+	for _, node := range nodes {
+		response.Nodes = append(response.Nodes, &ServerNode{
+			Name:     node.Name,
+			IP:       node.IP,
+			Port:     node.Port,
+			User:     node.User,
+			Password: node.Password,
+		})
+	}
+
+	return response
+}
+
+// createHandler is create API method handler
+func createHandler(user *ServerUser, request *ServerRequest) *ServerResponse {
+	if request.Template == "" {
+		return getBadRequestResponse("Template name must be set")
+	}
+
+	if !sliceutil.Contains(getTemplates(), request.Template) {
+		return getBadRequestResponse("Template " + request.Template + " does not exist")
+	}
+
+	ttl := serverPrefs.TTL
+
+	if request.TTL != "" {
+		ttl = timeutil.ParseDuration(request.TTL) / 60
+
+		if ttl <= 0 {
+			return getBadRequestResponse("TTL " + request.TTL + " is not valid")
+		}
+	}
+
+	err := validateServerRequest(request)
+
+	if err != nil {
+		return getBadRequestResponse(err.Error())
+	}
+
+	if ttl <= 0 && user.Quota > 0 {
+		return &ServerResponse{Error: "Farm without TTL can't be created by user with quota"}
+	}
+
+	// Farm created by another user must not change owner
+	if isTerrafarmActive() {
+		return &ServerResponse{Error: "Farm already active"}
+	}
+
+	state := readServerState()
+	err = checkServerQuota(user, state, ttl)
+
+	if err != nil {
+		return &ServerResponse{Error: err.Error()}
+	}
+
+	args := []string{CMD_CREATE, request.Template, "--ttl", fmtc.Sprintf("%dm", ttl)}
+
+	if request.MaxWait != "" {
+		args = append(args, "--max-wait", request.MaxWait)
+	}
+
+	if request.NodeSize != "" {
+		args = append(args, "--node-size", request.NodeSize)
+	}
+
+	if request.Region != "" {
+		args = append(args, "--region", request.Region)
+	}
+
+	// Password is passed through environment, so it not visible in
+	// processes list
+	password := passwd.GenPassword(18, passwd.STRENGTH_MEDIUM)
+	output, err := runServerCommand(user, []string{prefs.EV_PASSWORD + "=" + password}, args...)
+
+	// Farm can be partially created even if command failed
+	if err == nil || isTerrafarmActive() {
+		state.Owner = user.Name
+		state.Password = password
+
+		addServerUsage(user, state, ttl)
+	}
+
+	if err != nil {
+		return &ServerResponse{Output: output, Error: err.Error()}
+	}
+
+	return &ServerResponse{Output: output}
+}
+
+// prolongHandler is prolong API method handler
+func prolongHandler(user *ServerUser, request *ServerRequest) *ServerResponse {
+	ttl := timeutil.ParseDuration(request.TTL) / 60
+
+	if ttl <= 0 {
+		return getBadRequestResponse("Prolongation time must be greater than zero")
+	}
+
+	err := validateServerRequest(request)
+
+	if err != nil {
+		return getBadRequestResponse(err.Error())
+	}
+
+	if !isServerFarmOwner(user) {
+		return getForbiddenResponse()
+	}
+
+	state := readServerState()
+	err = checkServerQuota(user, state, ttl)
+
+	if err != nil {
+		return &ServerResponse{Error: err.Error()}
+	}
+
+	args := []string{CMD_PROLONG, request.TTL}
+
+	if request.MaxWait != "" {
+		args = append(args, request.MaxWait)
+	}
+
+	output, err := runServerCommand(user, nil, args...)
+
+	if err != nil {
+		return &ServerResponse{Output: output, Error: err.Error()}
+	}
+
+	addServerUsage(user, state, ttl)
+
+	return &ServerResponse{Output: output}
+}
+
+// destroyHandler is destroy API method handler
+func destroyHandler(user *ServerUser, request *ServerRequest) *ServerResponse {
+	if !isServerFarmOwner(user) {
+		return getForbiddenResponse()
+	}
+
+	output, err := runServerCommand(user, nil, CMD_DESTROY)
+
+	if err != nil {
+		return &ServerResponse{Output: output, Error: err.Error()}
+	}
+
+	state := readServerState()
+	state.Owner, state.Password = "", ""
+
+	saveServerState(state)
+
+	return &ServerResponse{Output: output}
+}
+
+// runServerCommand execute terrafarm command on behalf of server user,
+// commands are executed by terrafarm binary, so server uses the same
+// code as CLI
+func runServerCommand(user *ServerUser, env []string, args ...string) (string, error) {
+	binary, err := os.Executable()
+
+	if err != nil {
+		return "", fmtc.Errorf("Can't find path to terrafarm binary: %v", err)
+	}
+
+	cmd := exec.Command(binary, append(args, "--force", "--no-color")...)
+	cmd.Env = append(os.Environ(), EV_SERVER_USER+"="+user.Name)
+	cmd.Env = append(cmd.Env, env...)
+
+	output, err := cmd.CombinedOutput()
+
+	if err != nil {
+		return string(output), fmtc.Errorf("Command %s finished with error", args[0])
+	}
+
+	return string(output), nil
+}
+
+// authenticateServerUser return user with token from request
+func authenticateServerUser(r *http.Request) *ServerUser {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if token == "" {
+		return nil
+	}
+
+	for _, user := range serverUsers {
+		if subtle.ConstantTimeCompare([]byte(user.Token), []byte(token)) == 1 {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// isServerFarmOwner return true if user created current farm through
+// server or user is admin
+func isServerFarmOwner(user *ServerUser) bool {
+	if user.Admin {
+		return true
+	}
+
+	return readServerState().Owner == user.Name
+}
+
+// validateServerRequest check that request properties can be safely passed
+// as command arguments
+func validateServerRequest(request *ServerRequest) error {
+	if request.MaxWait != "" && timeutil.ParseDuration(request.MaxWait) < 60 {
+		return fmtc.Errorf("Max wait time %s is not valid", request.MaxWait)
+	}
+
+	if request.NodeSize != "" && !serverArgRegex.MatchString(request.NodeSize) {
+		return fmtc.Errorf("Node size %s is not valid", request.NodeSize)
+	}
+
+	if request.Region != "" && !serverArgRegex.MatchString(request.Region) {
+		return fmtc.Errorf("Region %s is not valid", request.Region)
+	}
+
+	return nil
+}
+
+// getBadRequestResponse return response for request with wrong properties
+func getBadRequestResponse(message string) *ServerResponse {
+	return &ServerResponse{Error: message, code: http.StatusBadRequest}
+}
+
+// getForbiddenResponse return response for user which is not farm owner
+func getForbiddenResponse() *ServerResponse {
+	return &ServerResponse{
+		Error: "Farm was created by another user",
+		code:  http.StatusForbidden,
+	}
+}
+
+// checkServerQuota check that user can use farm for given time
+func checkServerQuota(user *ServerUser, state *ServerState, ttl int64) error {
+	if user.Quota <= 0 {
+		return nil
+	}
+
+	usage := getServerUsage(user, state)
+
+	if usage.Minutes+ttl > user.Quota {
+		return fmtc.Errorf(
+			"Quota exceeded: %s of %s used this month",
+			timeutil.PrettyDuration(usage.Minutes*60),
+			timeutil.PrettyDuration(user.Quota*60),
+		)
+	}
+
+	return nil
+}
+
+// addServerUsage add farm time to user quota usage and save server state
+func addServerUsage(user *ServerUser, state *ServerState, ttl int64) {
+	getServerUsage(user, state).Minutes += ttl
+
+	err := saveServerState(state)
+
+	if err != nil {
+		log.Error("Can't save server state: %v", err)
+	}
+}
+
+// getServerUsage return user quota usage for current month
+func getServerUsage(user *ServerUser, state *ServerState) *ServerUsage {
+	month := time.Now().Format("2006-01")
+	usage := state.Usage[user.Name]
+
+	if usage == nil || usage.Month != month {
+		usage = &ServerUsage{Month: month}
+		state.Usage[user.Name] = usage
+	}
+
+	return usage
+}
+
+// readServerUsers read users from file, every line contains user name,
+// token, optional monthly quota and admin flag (e.g. "john 5ab1f0c3d9e2a7b8 40h admin")
+func readServerUsers(file string) ([]*ServerUser, error) {
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ServerUser
+
+	for index, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) < 2 || len(fields[1]) < 16 {
+			return nil, fmtc.Errorf("Line %d has wrong format or token is too short", index+1)
+		}
+
+		user := &ServerUser{Name: fields[0], Token: fields[1]}
+
+		for _, field := range fields[2:] {
+			if field == "admin" {
+				user.Admin = true
+			} else {
+				user.Quota = timeutil.ParseDuration(field) / 60
+			}
+		}
+
+		result = append(result, user)
+	}
+
+	if len(result) == 0 {
+		return nil, fmtc.Errorf("File %s doesn't contain any users", file)
+	}
+
+	return result, nil
+}
+
+// readServerState read server state from file
+func readServerState() *ServerState {
+	state := &ServerState{}
+
+	jsonutil.DecodeFile(getServerStateFilePath(), state)
+
+	if state.Usage == nil {
+		state.Usage = make(map[string]*ServerUsage)
+	}
+
+	return state
+}
+
+// saveServerState save server state to file
+func saveServerState(state *ServerState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	// State contains build nodes password
+	return writeFileAtomic(getServerStateFilePath(), data, 0600)
+}
+
+// getServerStateFilePath return path to server state file
+func getServerStateFilePath() string {
+	return path.Join(getDataDir(), SERVER_STATE_FILE)
+}
@@ -0,0 +1,180 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+
+	"pkg.re/essentialkaos/ek.v9/env"
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fsutil"
+	"pkg.re/essentialkaos/ek.v9/path"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// MONITOR_UNIT_NAME is name of systemd user unit for monitor
+const MONITOR_UNIT_NAME = "terrafarm-monitor.service"
+
+// EV_SUPERVISOR is environment variable with name of monitor supervisor
+const EV_SUPERVISOR = "TERRAFARM_SUPERVISOR"
+
+// SUPERVISOR_SYSTEMD is name of systemd supervisor
+const SUPERVISOR_SYSTEMD = "systemd"
+
+// MONITOR_UNIT_TEMPLATE is template of systemd user unit for monitor
+const MONITOR_UNIT_TEMPLATE = `[Unit]
+Description=Terrafarm farm monitor
+After=network-online.target
+
+[Service]
+Type=simple
+WorkingDirectory=%%h
+Environment=GOPATH=%s
+Environment=TERRAFARM_DATA=%s
+Environment=TERRAFARM_SUPERVISOR=%s
+ExecStart=%s --monitor --foreground --no-color
+Restart=on-failure
+RestartSec=30
+
+[Install]
+WantedBy=default.target
+`
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// installMonitorUnit generate and enable systemd user unit for monitor
+func installMonitorUnit() error {
+	if env.Which("systemctl") == "" {
+		return fmtc.Errorf("Can't find systemctl, looks like systemd is not used on this system")
+	}
+
+	binary, err := os.Executable()
+
+	if err != nil {
+		return fmtc.Errorf("Can't find path to terrafarm binary: %v", err)
+	}
+
+	unitDir := path.Dir(getMonitorUnitFilePath())
+
+	err = os.MkdirAll(unitDir, 0755)
+
+	if err != nil {
+		return fmtc.Errorf("Can't create directory %s: %v", unitDir, err)
+	}
+
+	unitData := fmtc.Sprintf(
+		MONITOR_UNIT_TEMPLATE,
+		envMap["GOPATH"], getDataDir(), SUPERVISOR_SYSTEMD, binary,
+	)
+
+	err = ioutil.WriteFile(getMonitorUnitFilePath(), []byte(unitData), 0644)
+
+	if err != nil {
+		return fmtc.Errorf("Can't save unit file: %v", err)
+	}
+
+	err = execSystemctl("daemon-reload")
+
+	if err != nil {
+		return err
+	}
+
+	return execSystemctl("enable", MONITOR_UNIT_NAME)
+}
+
+// uninstallMonitorUnit disable and remove systemd user unit for monitor
+func uninstallMonitorUnit() error {
+	if !isMonitorUnitInstalled() {
+		return fmtc.Errorf("Monitor unit is not installed")
+	}
+
+	err := execSystemctl("disable", MONITOR_UNIT_NAME)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(getMonitorUnitFilePath())
+
+	if err != nil {
+		return fmtc.Errorf("Can't remove unit file: %v", err)
+	}
+
+	return execSystemctl("daemon-reload")
+}
+
+// enableUserLinger enable lingering for current user, so user services
+// keep working after logout
+func enableUserLinger() error {
+	if env.Which("loginctl") == "" {
+		return fmtc.Errorf("Can't find loginctl")
+	}
+
+	curUser, err := user.Current()
+
+	if err != nil {
+		return err
+	}
+
+	output, err := exec.Command("loginctl", "enable-linger", curUser.Username).CombinedOutput()
+
+	if err != nil {
+		return errors.New(strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// startMonitorUnit start monitor using systemd
+func startMonitorUnit() error {
+	return execSystemctl("restart", MONITOR_UNIT_NAME)
+}
+
+// isMonitorUnitInstalled return true if systemd user unit for
+// monitor is installed
+func isMonitorUnitInstalled() bool {
+	return fsutil.IsExist(getMonitorUnitFilePath())
+}
+
+// isMonitorSupervised return true if current process is monitor
+// started by supervisor
+func isMonitorSupervised() bool {
+	return envMap[EV_SUPERVISOR] != ""
+}
+
+// execSystemctl execute systemctl command for user services manager
+func execSystemctl(args ...string) error {
+	cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	output, err := cmd.CombinedOutput()
+
+	if err != nil {
+		return fmtc.Errorf(
+			"systemctl %s failed: %s", strings.Join(args, " "),
+			strings.TrimSpace(string(output)),
+		)
+	}
+
+	return nil
+}
+
+// getMonitorUnitFilePath return path to systemd user unit file
+func getMonitorUnitFilePath() string {
+	configDir := envMap["XDG_CONFIG_HOME"]
+
+	if configDir == "" {
+		configDir = path.Join(envMap["HOME"], ".config")
+	}
+
+	return path.Join(configDir, "systemd", "user", MONITOR_UNIT_NAME)
+}
@@ -0,0 +1,255 @@
+package cli
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/fmtc"
+	"pkg.re/essentialkaos/ek.v9/fmtutil"
+	"pkg.re/essentialkaos/ek.v9/mathutil"
+	"pkg.re/essentialkaos/ek.v9/pluralize"
+	"pkg.re/essentialkaos/ek.v9/signal"
+	"pkg.re/essentialkaos/ek.v9/terminal/window"
+	"pkg.re/essentialkaos/ek.v9/timeutil"
+
+	"github.com/essentialkaos/terrafarm/prefs"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// WATCH_INTERVAL is interval between dashboard updates
+const WATCH_INTERVAL = 5 * time.Second
+
+// WATCH_LOG_TAIL_SIZE is max size of monitor log tail in bytes
+const WATCH_LOG_TAIL_SIZE = 32 * 1024
+
+// List of escape sequences used for dashboard rendering
+const (
+	ESC_ALT_SCREEN_ON  = "\033[?1049h"
+	ESC_ALT_SCREEN_OFF = "\033[?1049l"
+	ESC_CURSOR_HIDE    = "\033[?25l"
+	ESC_CURSOR_SHOW    = "\033[?25h"
+	ESC_CLEAR_SCREEN   = "\033[H\033[2J"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// WatchData contains data shown on dashboard
+type WatchData struct {
+	Preferences  *prefs.Preferences
+	FarmState    *FarmState
+	MonitorState *MonitorState
+	Nodes        []*NodeInfo
+	FarmActive   bool
+	Updated      time.Time
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// watchCommand is watch command handler
+func watchCommand(p *prefs.Preferences) {
+	redrawChan := make(chan bool, 1)
+
+	signal.Handlers{
+		signal.INT:  watchInterruptHandler,
+		signal.TERM: watchInterruptHandler,
+		signal.WINCH: func() {
+			select {
+			case redrawChan <- true:
+			default:
+			}
+		},
+	}.TrackAsync()
+
+	fmtc.Printf(ESC_ALT_SCREEN_ON + ESC_CURSOR_HIDE)
+
+	dataChan := make(chan *WatchData)
+	ticker := time.NewTicker(WATCH_INTERVAL)
+	data := &WatchData{Preferences: p, FarmActive: isTerrafarmActive()}
+
+	go runWatchDataCollector(p, dataChan)
+
+	// Dashboard is redrawn with last collected data, so monitor log is
+	// updated even if some nodes are slow or unreachable
+	for {
+		renderWatchDashboard(data)
+
+		select {
+		case data = <-dataChan:
+		case <-ticker.C:
+		case <-redrawChan:
+		}
+	}
+}
+
+// runWatchDataCollector collect data for dashboard in background
+func runWatchDataCollector(p *prefs.Preferences, dataChan chan<- *WatchData) {
+	for {
+		dataChan <- collectWatchData(p)
+		time.Sleep(WATCH_INTERVAL)
+	}
+}
+
+// watchInterruptHandler is INT and TERM signal handler for dashboard
+func watchInterruptHandler() {
+	fmtc.Printf(ESC_CURSOR_SHOW + ESC_ALT_SCREEN_OFF)
+	exit(0)
+}
+
+// collectWatchData collect data for dashboard
+func collectWatchData(p *prefs.Preferences) *WatchData {
+	data := &WatchData{
+		Preferences: p,
+		FarmActive:  isTerrafarmActive(),
+		Updated:     time.Now(),
+	}
+
+	if !data.FarmActive {
+		return data
+	}
+
+	farmState, err := readFarmState()
+
+	if err == nil {
+		data.FarmState = farmState
+		data.Preferences = farmState.Preferences
+	}
+
+	if isMonitorActive() {
+		data.MonitorState, _ = readMonitorState()
+	}
+
+	data.Nodes = getBuildNodesInfo(data.Preferences)
+
+	return data
+}
+
+// renderWatchDashboard render dashboard with given data
+func renderWatchDashboard(data *WatchData) {
+	p := data.Preferences
+	width, height := window.GetSize()
+
+	fmtc.Printf(ESC_CLEAR_SCREEN)
+
+	fmtutil.Separator(false, "TERRAFARM")
+
+	if data.Updated.IsZero() {
+		fmtc.Printf("  {*}%-16s{!} %s {s-}(collecting data...){!}\n", "Template:", p.Template)
+	} else {
+		fmtc.Printf(
+			"  {*}%-16s{!} %s {s-}(updated %s){!}\n", "Template:", p.Template,
+			timeutil.Format(data.Updated, "%H:%M:%S"),
+		)
+	}
+
+	linesUsed := 2
+
+	if !data.FarmActive {
+		fmtc.Printf("  {*}%-16s{!} {s}stopped{!}\n", "State:")
+		linesUsed++
+	} else {
+		fmtc.Printf("  {*}%-16s{!} {g}works{!}", "State:")
+
+		if data.FarmState != nil {
+			usageMinutes := int64(time.Since(time.Unix(data.FarmState.Started, 0)).Minutes())
+
+			fmtc.Printf(
+				" {s-}($%.2f for %s){!}",
+				getFarmUsagePrice(data.FarmState),
+				pluralize.Pluralize(int(usageMinutes), "minute", "minutes"),
+			)
+		}
+
+		fmtc.NewLine()
+
+		fmtc.Printf("  {*}%-16s{!} %s\n", "Monitor:", getWatchMonitorStatus(data.MonitorState))
+		fmtc.Printf("  {*}%-16s{!} "+getBuildBullets(data.Nodes)+"\n", "Nodes Statuses:")
+
+		printNodesMetrics(data.Nodes)
+
+		linesUsed += 5 + mathutil.Max(len(data.Nodes), 1)
+	}
+
+	fmtutil.Separator(false, "MONITOR LOG")
+
+	linesUsed += 2
+
+	for _, line := range readMonitorLogTail(height - linesUsed) {
+		if width > 4 && len(line) > width-4 {
+			line = line[:width-4]
+		}
+
+		fmtc.Printf("  {s}%s{!}\n", line)
+	}
+}
+
+// getWatchMonitorStatus return colored monitor status
+func getWatchMonitorStatus(state *MonitorState) string {
+	if state == nil {
+		return "{r}stopped{!}"
+	}
+
+	ttlRemain := state.DestroyAfter - time.Now().Unix()
+
+	switch {
+	case state.Paused:
+		return "{g}works{!} {y}(paused){!}"
+	case ttlRemain > 0:
+		return fmtc.Sprintf(
+			"{g}works{!} {s-}(%s to destroy){!}",
+			timeutil.PrettyDuration(ttlRemain),
+		)
+	case state.MaxWait > 0 && ttlRemain+state.MaxWait > 0:
+		return fmtc.Sprintf(
+			"{g}works{!} {y}(waiting, no longer than %s){!}",
+			timeutil.PrettyDuration(ttlRemain+state.MaxWait),
+		)
+	default:
+		return "{g}works{!} {y}(destroying){!}"
+	}
+}
+
+// readMonitorLogTail return last lines from monitor log
+func readMonitorLogTail(lines int) []string {
+	if lines <= 0 {
+		return nil
+	}
+
+	fd, err := os.Open(getMonitorLogFilePath())
+
+	if err != nil {
+		return nil
+	}
+
+	defer fd.Close()
+
+	info, err := fd.Stat()
+
+	if err != nil {
+		return nil
+	}
+
+	if info.Size() > WATCH_LOG_TAIL_SIZE {
+		fd.Seek(-WATCH_LOG_TAIL_SIZE, io.SeekEnd)
+	}
+
+	buf := make([]byte, WATCH_LOG_TAIL_SIZE)
+	n, _ := io.ReadFull(fd, buf)
+
+	data := strings.Split(strings.TrimRight(string(buf[:n]), "\n"), "\n")
+
+	if len(data) > lines {
+		data = data[len(data)-lines:]
+	}
+
+	return data
+}
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"pkg.re/essentialkaos/ek.v9/req"
 )
@@ -27,6 +28,14 @@ const (
 // DO_API is DO API url
 const DO_API = "https://api.digitalocean.com/v2"
 
+// ACTION_TIMEOUT is max time of waiting for droplet action completion
+const ACTION_TIMEOUT = time.Hour
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// API is URL of used API, it can be redefined for working with fake API
+var API = DO_API
+
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 // StatusCode status code
@@ -34,7 +43,8 @@ type StatusCode uint8
 
 // Account contains account status
 type Account struct {
-	Status string `json:"status"`
+	Status       string `json:"status"`
+	DropletLimit int    `json:"droplet_limit"`
 }
 
 // AccountInfo contains account info
@@ -57,19 +67,29 @@ type RegionsInfo struct {
 	Regions []*Region `json:"regions"`
 }
 
-// Region contains region slug
+// Region contains region info
 type Region struct {
-	Slug string `json:"slug"`
+	Slug      string   `json:"slug"`
+	Name      string   `json:"name"`
+	Sizes     []string `json:"sizes"`
+	Available bool     `json:"available"`
 }
 
 // SizesInfo contains info about supported droplet sizes
 type SizesInfo struct {
-	Sizes []*Region `json:"sizes"`
+	Sizes []*Size `json:"sizes"`
 }
 
-// Size contains droplet size slug
+// Size contains droplet size info
 type Size struct {
-	Slug string `json:"slug"`
+	Slug         string   `json:"slug"`
+	Memory       int      `json:"memory"` // Memory in MB
+	VCPUs        int      `json:"vcpus"`
+	Disk         int      `json:"disk"`
+	PriceHourly  float64  `json:"price_hourly"`
+	PriceMonthly float64  `json:"price_monthly"`
+	Regions      []string `json:"regions"`
+	Available    bool     `json:"available"`
 }
 
 // DropletsInfo contains info about droplets
@@ -77,10 +97,45 @@ type DropletsInfo struct {
 	Droplets []*Droplet `json:"droplets"`
 }
 
-// Droplet contains droplet ID and name
+// Droplet contains basic droplet info
 type Droplet struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	Status   string   `json:"status"`
+	Created  string   `json:"created_at"`
+	SizeSlug string   `json:"size_slug"`
+	Size     *Size    `json:"size"`
+	Tags     []string `json:"tags"`
+}
+
+// ImageInfo contains info about image
+type ImageInfo struct {
+	Image *Image `json:"image"`
+}
+
+// SnapshotsInfo contains info about droplet snapshots
+type SnapshotsInfo struct {
+	Snapshots []*Image `json:"snapshots"`
+}
+
+// Image contains basic image (snapshot) info
+type Image struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Regions []string `json:"regions"`
+	Created string   `json:"created_at"`
+	Size    float64  `json:"size_gigabytes"`
+}
+
+// ActionInfo contains info about droplet action
+type ActionInfo struct {
+	Action *Action `json:"action"`
+}
+
+// Action contains basic droplet action info
+type Action struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
 }
 
 // ////////////////////////////////////////////////////////////////////////////////// //
@@ -92,7 +147,7 @@ func IsValidToken(token string) StatusCode {
 	}
 
 	resp, err := req.Request{
-		URL:         DO_API + "/account",
+		URL:         API + "/account",
 		ContentType: req.CONTENT_TYPE_JSON,
 		Headers:     getAuthHeaders(token),
 	}.Get()
@@ -128,7 +183,7 @@ func IsFingerprintValid(token, fingerprint string) StatusCode {
 	}
 
 	resp, err := req.Request{
-		URL:         DO_API + "/account/keys",
+		URL:         API + "/account/keys",
 		ContentType: req.CONTENT_TYPE_JSON,
 		Headers:     getAuthHeaders(token),
 	}.Get()
@@ -166,7 +221,7 @@ func IsRegionValid(token, slug string) StatusCode {
 	}
 
 	resp, err := req.Request{
-		URL:         DO_API + "/regions",
+		URL:         API + "/regions",
 		ContentType: req.CONTENT_TYPE_JSON,
 		Headers:     getAuthHeaders(token),
 	}.Get()
@@ -204,7 +259,7 @@ func IsSizeValid(token, slug string) StatusCode {
 	}
 
 	resp, err := req.Request{
-		URL:         DO_API + "/sizes",
+		URL:         API + "/sizes",
 		ContentType: req.CONTENT_TYPE_JSON,
 		Headers:     getAuthHeaders(token),
 	}.Get()
@@ -251,22 +306,34 @@ func DestroyTerrafarmDroplets(token string) error {
 	}
 
 	for dropletName, dropletID := range droplets {
-		resp, err := req.Request{
-			URL:         DO_API + "/droplets/" + strconv.Itoa(dropletID),
-			ContentType: req.CONTENT_TYPE_JSON,
-			Headers:     getAuthHeaders(token),
-		}.Delete()
+		err = DestroyDroplet(token, dropletID)
 
 		if err != nil {
-			return fmt.Errorf("Can't send request to DigitalOcean API: %v", err)
+			return fmt.Errorf("Can't destroy droplet %s: %v", dropletName, err)
 		}
+	}
 
-		if resp.StatusCode != 204 {
-			return fmt.Errorf(
-				"Can't destroy droplet %s - DigitalOcean return status code %d",
-				dropletName, resp.StatusCode,
-			)
-		}
+	return nil
+}
+
+// DestroyDroplet destroy droplet with given ID
+func DestroyDroplet(token string, dropletID int) error {
+	if !isWellFormatedToken(token) {
+		return fmt.Errorf("Token is misformatted")
+	}
+
+	resp, err := req.Request{
+		URL:         API + "/droplets/" + strconv.Itoa(dropletID),
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     getAuthHeaders(token),
+	}.Delete()
+
+	if err != nil {
+		return fmt.Errorf("Can't send request to DigitalOcean API: %v", err)
+	}
+
+	if resp.StatusCode != 204 {
+		return fmt.Errorf("DigitalOcean return status code %d", resp.StatusCode)
 	}
 
 	return nil
@@ -274,14 +341,49 @@ func DestroyTerrafarmDroplets(token string) error {
 
 // GetTerrafarmDropletsList return map name->id
 func GetTerrafarmDropletsList(token string) (map[string]int, error) {
-	if !isWellFormatedToken(token) {
-		return nil, fmt.Errorf("Token is misformatted")
+	droplets, err := GetTerrafarmDroplets(token)
+
+	if err != nil {
+		return nil, err
 	}
 
 	var result = make(map[string]int)
 
+	for _, droplet := range droplets {
+		result[droplet.Name] = droplet.ID
+	}
+
+	return result, nil
+}
+
+// GetTerrafarmDroplets return info about all droplets with terrafarm
+// prefix or tag
+func GetTerrafarmDroplets(token string) ([]*Droplet, error) {
+	droplets, err := GetDroplets(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*Droplet
+
+	for _, droplet := range droplets {
+		if droplet.IsTerrafarmDroplet() {
+			result = append(result, droplet)
+		}
+	}
+
+	return result, nil
+}
+
+// GetDroplets return info about all droplets in account
+func GetDroplets(token string) ([]*Droplet, error) {
+	if !isWellFormatedToken(token) {
+		return nil, fmt.Errorf("Token is misformatted")
+	}
+
 	resp, err := req.Request{
-		URL:         DO_API + "/droplets",
+		URL:         API + "/droplets",
 		ContentType: req.CONTENT_TYPE_JSON,
 
 		Query: req.Query{
@@ -293,7 +395,11 @@ func GetTerrafarmDropletsList(token string) (map[string]int, error) {
 	}.Get()
 
 	if err != nil {
-		return result, fmt.Errorf("Can't fetch droplets list from DigitalOcean API: %v", err)
+		return nil, fmt.Errorf("Can't fetch droplets list from DigitalOcean API: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("DigitalOcean return status code %d", resp.StatusCode)
 	}
 
 	dropletsInfo := &DropletsInfo{}
@@ -301,20 +407,395 @@ func GetTerrafarmDropletsList(token string) (map[string]int, error) {
 	err = resp.JSON(dropletsInfo)
 
 	if err != nil {
-		return result, fmt.Errorf("Can't decode DigitalOcean API response: %v", err)
+		return nil, fmt.Errorf("Can't decode DigitalOcean API response: %v", err)
+	}
+
+	return dropletsInfo.Droplets, nil
+}
+
+// GetAccount return info about account
+func GetAccount(token string) (*Account, error) {
+	if !isWellFormatedToken(token) {
+		return nil, fmt.Errorf("Token is misformatted")
+	}
+
+	resp, err := req.Request{
+		URL:         API + "/account",
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     getAuthHeaders(token),
+	}.Get()
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't send request to DigitalOcean API: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("DigitalOcean return status code %d", resp.StatusCode)
+	}
+
+	accountInfo := &AccountInfo{}
+
+	err = resp.JSON(accountInfo)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode DigitalOcean API response: %v", err)
+	}
+
+	return accountInfo.Account, nil
+}
+
+// GetRegions return info about all regions
+func GetRegions(token string) ([]*Region, error) {
+	if !isWellFormatedToken(token) {
+		return nil, fmt.Errorf("Token is misformatted")
+	}
+
+	resp, err := req.Request{
+		URL:         API + "/regions",
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     getAuthHeaders(token),
+	}.Get()
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't send request to DigitalOcean API: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("DigitalOcean return status code %d", resp.StatusCode)
+	}
+
+	regionsInfo := &RegionsInfo{}
+
+	err = resp.JSON(regionsInfo)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode DigitalOcean API response: %v", err)
+	}
+
+	return regionsInfo.Regions, nil
+}
+
+// GetSizes return info about all droplet sizes
+func GetSizes(token string) ([]*Size, error) {
+	if !isWellFormatedToken(token) {
+		return nil, fmt.Errorf("Token is misformatted")
+	}
+
+	resp, err := req.Request{
+		URL:         API + "/sizes",
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     getAuthHeaders(token),
+		Query:       req.Query{"per_page": "200"},
+	}.Get()
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't send request to DigitalOcean API: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("DigitalOcean return status code %d", resp.StatusCode)
+	}
+
+	sizesInfo := &SizesInfo{}
+
+	err = resp.JSON(sizesInfo)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode DigitalOcean API response: %v", err)
+	}
+
+	return sizesInfo.Sizes, nil
+}
+
+// IsImageExist return true if image with given slug or ID exists
+func IsImageExist(token, image string) StatusCode {
+	if !isWellFormatedToken(token) {
+		return STATUS_NOT_OK
+	}
+
+	resp, err := req.Request{
+		URL:         API + "/images/" + image,
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     getAuthHeaders(token),
+	}.Get()
+
+	if err != nil {
+		return STATUS_ERROR
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return STATUS_OK
+	case 404:
+		return STATUS_NOT_OK
+	}
+
+	return STATUS_ERROR
+}
+
+// AddKey add public SSH key to account
+func AddKey(token, name, publicKey string) error {
+	if !isWellFormatedToken(token) {
+		return fmt.Errorf("Token is misformatted")
+	}
+
+	resp, err := req.Request{
+		URL:         API + "/account/keys",
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     getAuthHeaders(token),
+		Body:        map[string]string{"name": name, "public_key": publicKey},
+	}.Post()
+
+	if err != nil {
+		return fmt.Errorf("Can't send request to DigitalOcean API: %v", err)
+	}
+
+	if resp.StatusCode != 201 {
+		return fmt.Errorf("DigitalOcean return status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// PowerOffDroplet power off droplet and wait until droplet is off
+func PowerOffDroplet(token string, dropletID int) error {
+	action, err := execDropletAction(token, dropletID, map[string]string{"type": "power_off"})
+
+	if err != nil {
+		return err
+	}
+
+	return waitAction(token, action.ID)
+}
+
+// SnapshotDroplet create snapshot of droplet and return info about
+// created image
+func SnapshotDroplet(token string, dropletID int, name string) (*Image, error) {
+	action, err := execDropletAction(
+		token, dropletID,
+		map[string]string{"type": "snapshot", "name": name},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = waitAction(token, action.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := req.Request{
+		URL:         API + "/droplets/" + strconv.Itoa(dropletID) + "/snapshots",
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     getAuthHeaders(token),
+	}.Get()
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't fetch snapshots list from DigitalOcean API: %v", err)
+	}
+
+	snapshotsInfo := &SnapshotsInfo{}
+
+	err = resp.JSON(snapshotsInfo)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode DigitalOcean API response: %v", err)
 	}
 
-	for _, droplet := range dropletsInfo.Droplets {
-		if strings.HasPrefix(strings.ToLower(droplet.Name), "terrafarm") {
-			result[droplet.Name] = droplet.ID
+	for _, image := range snapshotsInfo.Snapshots {
+		if image.Name == name {
+			return image, nil
 		}
 	}
 
-	return result, nil
+	return nil, fmt.Errorf("Can't find snapshot %s", name)
+}
+
+// GetImage return info about image with given ID, or nil if image
+// does not exist
+func GetImage(token string, imageID int) (*Image, error) {
+	if !isWellFormatedToken(token) {
+		return nil, fmt.Errorf("Token is misformatted")
+	}
+
+	resp, err := req.Request{
+		URL:         API + "/images/" + strconv.Itoa(imageID),
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     getAuthHeaders(token),
+	}.Get()
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't send request to DigitalOcean API: %v", err)
+	}
+
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("DigitalOcean return status code %d", resp.StatusCode)
+	}
+
+	imageInfo := &ImageInfo{}
+
+	err = resp.JSON(imageInfo)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode DigitalOcean API response: %v", err)
+	}
+
+	return imageInfo.Image, nil
+}
+
+// DeleteImage delete image with given ID
+func DeleteImage(token string, imageID int) error {
+	if !isWellFormatedToken(token) {
+		return fmt.Errorf("Token is misformatted")
+	}
+
+	resp, err := req.Request{
+		URL:         API + "/images/" + strconv.Itoa(imageID),
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     getAuthHeaders(token),
+	}.Delete()
+
+	if err != nil {
+		return fmt.Errorf("Can't send request to DigitalOcean API: %v", err)
+	}
+
+	if resp.StatusCode != 204 && resp.StatusCode != 404 {
+		return fmt.Errorf(
+			"Can't delete image %d - DigitalOcean return status code %d",
+			imageID, resp.StatusCode,
+		)
+	}
+
+	return nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// IsTerrafarmDroplet return true if droplet have terrafarm prefix or tag
+func (d *Droplet) IsTerrafarmDroplet() bool {
+	if strings.HasPrefix(strings.ToLower(d.Name), "terrafarm") {
+		return true
+	}
+
+	for _, tag := range d.Tags {
+		if strings.HasPrefix(strings.ToLower(tag), "terrafarm") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreationDate return droplet creation date
+func (d *Droplet) CreationDate() time.Time {
+	date, err := time.Parse(time.RFC3339, d.Created)
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	return date
+}
+
+// PriceHourly return droplet hourly price
+func (d *Droplet) PriceHourly() float64 {
+	if d.Size == nil {
+		return 0.0
+	}
+
+	return d.Size.PriceHourly
+}
+
+// CreationDate return image creation date
+func (i *Image) CreationDate() time.Time {
+	date, err := time.Parse(time.RFC3339, i.Created)
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	return date
 }
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
+// execDropletAction send request for droplet action
+func execDropletAction(token string, dropletID int, action map[string]string) (*Action, error) {
+	if !isWellFormatedToken(token) {
+		return nil, fmt.Errorf("Token is misformatted")
+	}
+
+	resp, err := req.Request{
+		URL:         API + "/droplets/" + strconv.Itoa(dropletID) + "/actions",
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     getAuthHeaders(token),
+		Body:        action,
+	}.Post()
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't send request to DigitalOcean API: %v", err)
+	}
+
+	if resp.StatusCode != 201 {
+		return nil, fmt.Errorf(
+			"Can't execute action %s - DigitalOcean return status code %d",
+			action["type"], resp.StatusCode,
+		)
+	}
+
+	actionInfo := &ActionInfo{}
+
+	err = resp.JSON(actionInfo)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode DigitalOcean API response: %v", err)
+	}
+
+	return actionInfo.Action, nil
+}
+
+// waitAction wait until action is completed
+func waitAction(token string, actionID int) error {
+	deadline := time.Now().Add(ACTION_TIMEOUT)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+
+		resp, err := req.Request{
+			URL:         API + "/actions/" + strconv.Itoa(actionID),
+			ContentType: req.CONTENT_TYPE_JSON,
+			Headers:     getAuthHeaders(token),
+		}.Get()
+
+		if err != nil {
+			continue
+		}
+
+		actionInfo := &ActionInfo{}
+
+		err = resp.JSON(actionInfo)
+
+		if err != nil || actionInfo.Action == nil {
+			continue
+		}
+
+		switch actionInfo.Action.Status {
+		case "completed":
+			return nil
+		case "errored":
+			return fmt.Errorf("Action %d failed", actionID)
+		}
+	}
+
+	return fmt.Errorf("Action %d is not completed after %v", actionID, ACTION_TIMEOUT)
+}
+
 func isWellFormatedToken(token string) bool {
 	if len(token) != 64 {
 		return false
@@ -0,0 +1,234 @@
+// Package notifier provides methods for sending notifications about farm events
+package notifier
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"pkg.re/essentialkaos/ek.v9/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// List of supported events
+const (
+	EVENT_FARM_CREATED   = "farm-created"
+	EVENT_TTL_WARNING    = "ttl-warning"
+	EVENT_WAITING_BUILDS = "waiting-builds"
+	EVENT_FARM_DESTROYED = "farm-destroyed"
+	EVENT_DESTROY_FAILED = "destroy-failed"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Event contains info about farm event
+type Event struct {
+	Type      string   `json:"event"`
+	Template  string   `json:"template"`
+	Message   string   `json:"message"`
+	Host      string   `json:"host"`
+	Nodes     []string `json:"nodes,omitempty"`
+	Cost      float64  `json:"cost,omitempty"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+// Sink is notification sink
+type Sink interface {
+	// Name return sink name
+	Name() string
+
+	// Send send notification about event
+	Send(event *Event) error
+}
+
+// WebhookSink is generic HTTP webhook sink which sends event as JSON
+type WebhookSink struct {
+	URL string
+}
+
+// SlackSink is Slack-compatible incoming webhook sink
+type SlackSink struct {
+	URL string
+}
+
+// EmailSink is SMTP email sink
+type EmailSink struct {
+	Server   string // SMTP server address (host:port)
+	User     string
+	Password string
+	From     string
+	To       []string
+}
+
+// DesktopSink is desktop notifications sink (uses notify-send)
+type DesktopSink struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// SlackMessage contains Slack incoming webhook payload
+type SlackMessage struct {
+	Username string `json:"username"`
+	Text     string `json:"text"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// NewEvent create new event
+func NewEvent(eventType, template, message string) *Event {
+	hostname, _ := os.Hostname()
+
+	return &Event{
+		Type:      eventType,
+		Template:  template,
+		Message:   message,
+		Host:      hostname,
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// Send send event to all given sinks
+func Send(sinks []Sink, event *Event) []error {
+	var errs []error
+
+	for _, sink := range sinks {
+		err := sink.Send(event)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Can't send notification using %s: %v", sink.Name(), err))
+		}
+	}
+
+	return errs
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Name return sink name
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Send send event as JSON to webhook
+func (s *WebhookSink) Send(event *Event) error {
+	return postJSON(s.URL, event)
+}
+
+// Name return sink name
+func (s *SlackSink) Name() string {
+	return "slack"
+}
+
+// Send send event to Slack-compatible incoming webhook
+func (s *SlackSink) Send(event *Event) error {
+	return postJSON(s.URL, &SlackMessage{
+		Username: "terrafarm",
+		Text:     event.Title() + "\n" + event.Message,
+	})
+}
+
+// Name return sink name
+func (s *EmailSink) Name() string {
+	return "email"
+}
+
+// Send send event as email
+func (s *EmailSink) Send(event *Event) error {
+	var auth smtp.Auth
+
+	if s.User != "" {
+		host, _, err := net.SplitHostPort(s.Server)
+
+		if err != nil {
+			return err
+		}
+
+		auth = smtp.PlainAuth("", s.User, s.Password, host)
+	}
+
+	message := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		s.From, strings.Join(s.To, ", "), event.Title(), event.Message,
+	)
+
+	return smtp.SendMail(s.Server, auth, s.From, s.To, []byte(message))
+}
+
+// Name return sink name
+func (s *DesktopSink) Name() string {
+	return "desktop"
+}
+
+// Send show desktop notification
+func (s *DesktopSink) Send(event *Event) error {
+	urgency := "normal"
+
+	if event.Type == EVENT_DESTROY_FAILED || event.Type == EVENT_TTL_WARNING {
+		urgency = "critical"
+	}
+
+	cmd := exec.Command("notify-send", "-u", urgency, event.Title(), event.Message)
+
+	return cmd.Run()
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Title return event title
+func (e *Event) Title() string {
+	var title string
+
+	switch e.Type {
+	case EVENT_FARM_CREATED:
+		title = "Farm created"
+	case EVENT_TTL_WARNING:
+		title = "Farm will be destroyed soon"
+	case EVENT_WAITING_BUILDS:
+		title = "Waiting for builds"
+	case EVENT_FARM_DESTROYED:
+		title = "Farm destroyed"
+	case EVENT_DESTROY_FAILED:
+		title = "Farm destroy failed"
+	default:
+		title = e.Type
+	}
+
+	if e.Template == "" {
+		return "Terrafarm: " + title
+	}
+
+	return "Terrafarm: " + title + " (" + e.Template + ")"
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// postJSON send given data encoded as JSON to given URL
+func postJSON(url string, data interface{}) error {
+	resp, err := req.Request{
+		URL:         url,
+		ContentType: req.CONTENT_TYPE_JSON,
+		Body:        data,
+		AutoDiscard: true,
+	}.Post()
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Server return status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"crypto"
 	"fmt"
 	"io/ioutil"
+	"strconv"
 	"strings"
 
 	"pkg.re/essentialkaos/ek.v9/env"
@@ -22,6 +23,8 @@ import (
 	"gopkg.in/hlandau/passlib.v1/hash/sha2crypt"
 
 	sshkey "github.com/yosida95/golang-sshkey"
+
+	"github.com/essentialkaos/terrafarm/provider"
 )
 
 // ////////////////////////////////////////////////////////////////////////////////// //
@@ -38,6 +41,14 @@ const (
 	EV_NODE_SIZE = "TERRAFARM_NODE_SIZE"
 	EV_USER      = "TERRAFARM_USER"
 	EV_PASSWORD  = "TERRAFARM_PASSWORD"
+	EV_ACCOUNT   = "TERRAFARM_ACCOUNT"
+
+	EV_STATE_BACKEND    = "TERRAFARM_STATE_BACKEND"
+	EV_STATE_URL        = "TERRAFARM_STATE_URL"
+	EV_STATE_ACCESS_KEY = "TERRAFARM_STATE_ACCESS_KEY"
+	EV_STATE_SECRET_KEY = "TERRAFARM_STATE_SECRET_KEY"
+
+	EV_SERVER_TOKEN = "TERRAFARM_SERVER_TOKEN"
 )
 
 // List of supported preferences
@@ -52,6 +63,69 @@ const (
 	NODE_SIZE = "node-size"
 	USER      = "user"
 	PASSWORD  = "password"
+	ACCOUNT   = "account"
+
+	NOTIFY_WEBHOOK       = "notify-webhook"
+	NOTIFY_SLACK         = "notify-slack"
+	NOTIFY_EMAIL         = "notify-email"
+	NOTIFY_EMAIL_FROM    = "notify-email-from"
+	NOTIFY_SMTP          = "notify-smtp"
+	NOTIFY_SMTP_USER     = "notify-smtp-user"
+	NOTIFY_SMTP_PASSWORD = "notify-smtp-password"
+	NOTIFY_DESKTOP       = "notify-desktop"
+	NOTIFY_TTL_WARNING   = "notify-ttl-warning"
+
+	HOOK_PRE_CREATE   = "hook-pre-create"
+	HOOK_POST_CREATE  = "hook-post-create"
+	HOOK_PRE_DESTROY  = "hook-pre-destroy"
+	HOOK_POST_DESTROY = "hook-post-destroy"
+	HOOK_TIMEOUT      = "hook-timeout"
+
+	HARVEST         = "harvest"
+	HARVEST_PATHS   = "harvest-paths"
+	HARVEST_DIR     = "harvest-dir"
+	HARVEST_TIMEOUT = "harvest-timeout"
+
+	EXTRA_REPOS    = "extra-repos"
+	EXTRA_PACKAGES = "extra-packages"
+
+	ON_FAILURE         = "on-failure"
+	RETRY_ATTEMPTS     = "retry-attempts"
+	RETRY_DELAY        = "retry-delay"
+	CONNECTION_TIMEOUT = "connection-timeout"
+
+	STATE_BACKEND    = "state-backend"
+	STATE_URL        = "state-url"
+	STATE_REGION     = "state-region"
+	STATE_ACCESS_KEY = "state-access-key"
+	STATE_SECRET_KEY = "state-secret-key"
+
+	SERVER_LISTEN = "server-listen"
+	SERVER_USERS  = "server-users"
+	SERVER_CERT   = "server-cert"
+	SERVER_KEY    = "server-key"
+	SERVER_TOKEN  = "server-token"
+)
+
+// List of supported account properties (account.<name>.<property>)
+const (
+	ACCOUNT_TOKEN     = "token"
+	ACCOUNT_KEY       = "key"
+	ACCOUNT_REGION    = "region"
+	ACCOUNT_TEMPLATES = "templates"
+)
+
+// List of supported actions on farm creation failure
+const (
+	FAILURE_DESTROY = "destroy"
+	FAILURE_RETRY   = "retry"
+)
+
+// List of supported state backends
+const (
+	BACKEND_LOCAL = "local"
+	BACKEND_S3    = "s3"
+	BACKEND_HTTP  = "http"
 )
 
 // List of supported command-line arguments
@@ -64,7 +138,11 @@ const (
 	OPT_NODE_SIZE = "N:node-size"
 	OPT_USER      = "U:user"
 	OPT_PASSWORD  = "P:password"
+	OPT_ACCOUNT   = "account"
 	OPT_MAX_WAIT  = "w:max-wait"
+	OPT_REPO      = "repo"
+	OPT_PACKAGE   = "package"
+	OPT_FILE      = "file"
 )
 
 // ////////////////////////////////////////////////////////////////////////////////// //
@@ -82,21 +160,144 @@ type Preferences struct {
 	User        string `json:"user"`
 	Password    string `json:"password"`
 	Template    string `json:"template"`
+
+	NotifyWebhook      string   `json:"notify_webhook,omitempty"`
+	NotifySlack        string   `json:"notify_slack,omitempty"`
+	NotifyEmail        []string `json:"notify_email,omitempty"`
+	NotifyEmailFrom    string   `json:"notify_email_from,omitempty"`
+	NotifySMTP         string   `json:"notify_smtp,omitempty"`
+	NotifySMTPUser     string   `json:"notify_smtp_user,omitempty"`
+	NotifySMTPPassword string   `json:"notify_smtp_password,omitempty"`
+	NotifyDesktop      bool     `json:"notify_desktop,omitempty"`
+	NotifyTTLWarning   int64    `json:"notify_ttl_warning,omitempty"`
+
+	HookPreCreate   string `json:"hook_pre_create,omitempty"`
+	HookPostCreate  string `json:"hook_post_create,omitempty"`
+	HookPreDestroy  string `json:"hook_pre_destroy,omitempty"`
+	HookPostDestroy string `json:"hook_post_destroy,omitempty"`
+	HookTimeout     int64  `json:"hook_timeout,omitempty"`
+
+	Harvest        bool     `json:"harvest"`
+	HarvestPaths   []string `json:"harvest_paths,omitempty"`
+	HarvestDir     string   `json:"harvest_dir,omitempty"`
+	HarvestTimeout int64    `json:"harvest_timeout,omitempty"`
+
+	ExtraRepos    []string `json:"extra_repos,omitempty"`
+	ExtraPackages []string `json:"extra_packages,omitempty"`
+	ExtraFiles    []string `json:"extra_files,omitempty"`
+
+	OnFailure         string `json:"on_failure,omitempty"`
+	RetryAttempts     int    `json:"retry_attempts,omitempty"`
+	RetryDelay        int64  `json:"retry_delay,omitempty"`
+	ConnectionTimeout int64  `json:"connection_timeout,omitempty"`
+
+	// Accounts are not saved to farm state, name of used account
+	// saved to farm state separately
+	Account  string              `json:"-"`
+	Accounts map[string]*Account `json:"-"`
+
+	// State backend preferences are not saved to farm state
+	StateBackend   string `json:"-"`
+	StateURL       string `json:"-"`
+	StateRegion    string `json:"-"`
+	StateAccessKey string `json:"-"`
+	StateSecretKey string `json:"-"`
+
+	// Server preferences are not saved to farm state
+	ServerListen string `json:"-"`
+	ServerUsers  string `json:"-"`
+	ServerCert   string `json:"-"`
+	ServerKey    string `json:"-"`
+	ServerToken  string `json:"-"`
+
+	accountDefined bool     // Account defined by env variable or argument
+	regionDefined  bool     // Region defined by env variable or argument
+	global         *Account // Credentials defined outside of accounts
+}
+
+// Account contains credentials of DigitalOcean account (team)
+type Account struct {
+	Name      string
+	Token     string
+	Key       string
+	Region    string
+	Templates []string // Templates which use this account by default
 }
 
 // ////////////////////////////////////////////////////////////////////////////////// //
 
-// FindAndReadPreferences read preferences from file and command-line arguments
-func FindAndReadPreferences(dataDir string) (*Preferences, []error) {
+// FindAndReadPreferences read preferences from file and command-line arguments,
+// template is used for choosing account if account is not defined by env
+// variable or argument
+func FindAndReadPreferences(dataDir, template string) (*Preferences, []error) {
+	return findAndReadPreferences(dataDir, template, func(p *Preferences) error {
+		return p.SelectAccount()
+	})
+}
+
+// FindAndReadFarmPreferences read preferences for created farm, credentials
+// of account used for farm creation are always used
+func FindAndReadFarmPreferences(dataDir, template, account string) (*Preferences, []error) {
+	return findAndReadPreferences(dataDir, template, func(p *Preferences) error {
+		return p.SelectFarmAccount(account)
+	})
+}
+
+// findAndReadPreferences read preferences, apply account and validate result
+func findAndReadPreferences(dataDir, template string, selectAccount func(*Preferences) error) (*Preferences, []error) {
+	prefs, err := ReadPreferences()
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	if template != "" {
+		prefs.Template = template
+	}
+
+	err = selectAccount(prefs)
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	fingerprint, err := getFingerprint(prefs.Key + ".pub")
+
+	if err == nil {
+		prefs.Fingerprint = fingerprint
+	}
+
+	errs := prefs.Validate(dataDir, true)
+
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return prefs, nil
+}
+
+// ReadPreferences read preferences from file, environment variables and
+// command-line arguments without validation
+func ReadPreferences() (*Preferences, error) {
 	var err error
 
 	// Create preferences width default values
 	prefs := &Preferences{
-		TTL:      240,
-		Region:   "fra1",
-		NodeSize: "16gb",
-		User:     "builder",
-		Password: passwd.GenPassword(18, passwd.STRENGTH_MEDIUM),
+		TTL:       240,
+		Region:    "fra1",
+		NodeSize:  "16gb",
+		User:      "builder",
+		Password:  passwd.GenPassword(18, passwd.STRENGTH_MEDIUM),
+		Harvest:   true,
+		OnFailure: FAILURE_DESTROY,
+
+		RetryAttempts: 3,
+		RetryDelay:    30,
+
+		StateBackend: BACKEND_LOCAL,
+		StateRegion:  "us-east-1",
+
+		ServerListen: "127.0.0.1:33100",
 	}
 
 	prefsFile := fsutil.ProperPath("FRS", []string{
@@ -108,32 +309,20 @@ func FindAndReadPreferences(dataDir string) (*Preferences, []error) {
 		err = applyPreferencesFromFile(prefs, prefsFile)
 
 		if err != nil {
-			return nil, []error{err}
+			return nil, err
 		}
 	}
 
 	err = applyPreferencesFromEnvironment(prefs)
 
 	if err != nil {
-		return nil, []error{err}
+		return nil, err
 	}
 
 	err = applyPreferencesFromArgs(prefs)
 
 	if err != nil {
-		return nil, []error{err}
-	}
-
-	fingerprint, err := getFingerprint(prefs.Key + ".pub")
-
-	if err == nil {
-		prefs.Fingerprint = fingerprint
-	}
-
-	errs := prefs.Validate(dataDir, true)
-
-	if len(errs) != 0 {
-		return nil, errs
+		return nil, err
 	}
 
 	return prefs, nil
@@ -165,6 +354,16 @@ func applyPreferencesFromFile(prefs *Preferences, file string) error {
 		propName := propSlice[0]
 		propVal := strings.TrimSpace(strings.Join(propSlice[1:], ":"))
 
+		if strings.HasPrefix(strings.ToLower(propName), ACCOUNT+".") {
+			err = applyAccountProperty(prefs, propName, propVal)
+
+			if err != nil {
+				return fmt.Errorf("%v in %s file", err, file)
+			}
+
+			continue
+		}
+
 		switch strings.ToLower(propName) {
 		case TTL:
 			prefs.TTL = timeutil.ParseDuration(propVal) / 60
@@ -201,6 +400,155 @@ func applyPreferencesFromFile(prefs *Preferences, file string) error {
 		case TEMPLATE:
 			prefs.Template = propVal
 
+		case ACCOUNT:
+			prefs.Account = propVal
+
+		case NOTIFY_WEBHOOK:
+			prefs.NotifyWebhook = propVal
+
+		case NOTIFY_SLACK:
+			prefs.NotifySlack = propVal
+
+		case NOTIFY_EMAIL:
+			prefs.NotifyEmail = parseList(propVal)
+
+		case NOTIFY_EMAIL_FROM:
+			prefs.NotifyEmailFrom = propVal
+
+		case NOTIFY_SMTP:
+			prefs.NotifySMTP = propVal
+
+		case NOTIFY_SMTP_USER:
+			prefs.NotifySMTPUser = propVal
+
+		case NOTIFY_SMTP_PASSWORD:
+			prefs.NotifySMTPPassword = propVal
+
+		case NOTIFY_DESKTOP:
+			prefs.NotifyDesktop = parseBool(propVal)
+
+		case NOTIFY_TTL_WARNING:
+			prefs.NotifyTTLWarning = timeutil.ParseDuration(propVal) / 60
+
+			if prefs.NotifyTTLWarning == 0 {
+				return fmt.Errorf("Incorrect %s property in %s file", NOTIFY_TTL_WARNING, file)
+			}
+
+		case HOOK_PRE_CREATE:
+			prefs.HookPreCreate = fsutil.ProperPath("FRX", []string{propVal})
+
+			if prefs.HookPreCreate == "" {
+				return fmt.Errorf("Hook %s must be an executable file", propVal)
+			}
+
+		case HOOK_POST_CREATE:
+			prefs.HookPostCreate = fsutil.ProperPath("FRX", []string{propVal})
+
+			if prefs.HookPostCreate == "" {
+				return fmt.Errorf("Hook %s must be an executable file", propVal)
+			}
+
+		case HOOK_PRE_DESTROY:
+			prefs.HookPreDestroy = fsutil.ProperPath("FRX", []string{propVal})
+
+			if prefs.HookPreDestroy == "" {
+				return fmt.Errorf("Hook %s must be an executable file", propVal)
+			}
+
+		case HOOK_POST_DESTROY:
+			prefs.HookPostDestroy = fsutil.ProperPath("FRX", []string{propVal})
+
+			if prefs.HookPostDestroy == "" {
+				return fmt.Errorf("Hook %s must be an executable file", propVal)
+			}
+
+		case HOOK_TIMEOUT:
+			prefs.HookTimeout = timeutil.ParseDuration(propVal) / 60
+
+			if prefs.HookTimeout == 0 {
+				return fmt.Errorf("Incorrect %s property in %s file", HOOK_TIMEOUT, file)
+			}
+
+		case HARVEST:
+			prefs.Harvest = parseBool(propVal)
+
+		case HARVEST_PATHS:
+			prefs.HarvestPaths = parseList(propVal)
+
+		case HARVEST_DIR:
+			prefs.HarvestDir = propVal
+
+		case HARVEST_TIMEOUT:
+			prefs.HarvestTimeout = timeutil.ParseDuration(propVal) / 60
+
+			if prefs.HarvestTimeout == 0 {
+				return fmt.Errorf("Incorrect %s property in %s file", HARVEST_TIMEOUT, file)
+			}
+
+		case EXTRA_REPOS:
+			prefs.ExtraRepos = parseList(propVal)
+
+		case EXTRA_PACKAGES:
+			prefs.ExtraPackages = parseList(propVal)
+
+		case ON_FAILURE:
+			prefs.OnFailure = strings.ToLower(propVal)
+
+			if prefs.OnFailure != FAILURE_DESTROY && prefs.OnFailure != FAILURE_RETRY {
+				return fmt.Errorf("Incorrect %s property in %s file", ON_FAILURE, file)
+			}
+
+		case RETRY_ATTEMPTS:
+			prefs.RetryAttempts, _ = strconv.Atoi(propVal)
+
+			if prefs.RetryAttempts <= 0 {
+				return fmt.Errorf("Incorrect %s property in %s file", RETRY_ATTEMPTS, file)
+			}
+
+		case RETRY_DELAY:
+			prefs.RetryDelay = timeutil.ParseDuration(propVal)
+
+			if prefs.RetryDelay == 0 {
+				return fmt.Errorf("Incorrect %s property in %s file", RETRY_DELAY, file)
+			}
+
+		case CONNECTION_TIMEOUT:
+			prefs.ConnectionTimeout = timeutil.ParseDuration(propVal) / 60
+
+			if prefs.ConnectionTimeout == 0 {
+				return fmt.Errorf("Incorrect %s property in %s file", CONNECTION_TIMEOUT, file)
+			}
+
+		case STATE_BACKEND:
+			prefs.StateBackend = strings.ToLower(propVal)
+
+		case STATE_URL:
+			prefs.StateURL = propVal
+
+		case STATE_REGION:
+			prefs.StateRegion = propVal
+
+		case STATE_ACCESS_KEY:
+			prefs.StateAccessKey = propVal
+
+		case STATE_SECRET_KEY:
+			prefs.StateSecretKey = propVal
+
+		case SERVER_LISTEN:
+			prefs.ServerListen = propVal
+
+		case SERVER_USERS:
+			prefs.ServerUsers = propVal
+
+		case SERVER_CERT:
+			prefs.ServerCert = propVal
+
+		case SERVER_KEY:
+			prefs.ServerKey = propVal
+
+		case SERVER_TOKEN:
+			prefs.ServerToken = propVal
+
 		default:
 			return fmt.Errorf("Unknown property %s in %s file", propName, file)
 		}
@@ -241,6 +589,12 @@ func applyPreferencesFromArgs(prefs *Preferences) error {
 
 	if options.Has(OPT_REGION) {
 		prefs.Region = options.GetS(OPT_REGION)
+		prefs.regionDefined = true
+	}
+
+	if options.Has(OPT_ACCOUNT) {
+		prefs.Account = options.GetS(OPT_ACCOUNT)
+		prefs.accountDefined = true
 	}
 
 	if options.Has(OPT_NODE_SIZE) {
@@ -255,6 +609,18 @@ func applyPreferencesFromArgs(prefs *Preferences) error {
 		prefs.Password = options.GetS(OPT_PASSWORD)
 	}
 
+	if options.Has(OPT_REPO) {
+		prefs.ExtraRepos = append(prefs.ExtraRepos, parseList(options.GetS(OPT_REPO))...)
+	}
+
+	if options.Has(OPT_PACKAGE) {
+		prefs.ExtraPackages = append(prefs.ExtraPackages, parseList(options.GetS(OPT_PACKAGE))...)
+	}
+
+	if options.Has(OPT_FILE) {
+		prefs.ExtraFiles = append(prefs.ExtraFiles, parseList(options.GetS(OPT_FILE))...)
+	}
+
 	return nil
 }
 
@@ -291,6 +657,12 @@ func applyPreferencesFromEnvironment(prefs *Preferences) error {
 
 	if envMap[EV_REGION] != "" {
 		prefs.Region = envMap[EV_REGION]
+		prefs.regionDefined = true
+	}
+
+	if envMap[EV_ACCOUNT] != "" {
+		prefs.Account = envMap[EV_ACCOUNT]
+		prefs.accountDefined = true
 	}
 
 	if envMap[EV_NODE_SIZE] != "" {
@@ -309,9 +681,92 @@ func applyPreferencesFromEnvironment(prefs *Preferences) error {
 		prefs.Template = envMap[EV_TEMPLATE]
 	}
 
+	if envMap[EV_STATE_BACKEND] != "" {
+		prefs.StateBackend = strings.ToLower(envMap[EV_STATE_BACKEND])
+	}
+
+	if envMap[EV_STATE_URL] != "" {
+		prefs.StateURL = envMap[EV_STATE_URL]
+	}
+
+	if envMap[EV_STATE_ACCESS_KEY] != "" {
+		prefs.StateAccessKey = envMap[EV_STATE_ACCESS_KEY]
+	}
+
+	if envMap[EV_STATE_SECRET_KEY] != "" {
+		prefs.StateSecretKey = envMap[EV_STATE_SECRET_KEY]
+	}
+
+	if envMap[EV_SERVER_TOKEN] != "" {
+		prefs.ServerToken = envMap[EV_SERVER_TOKEN]
+	}
+
+	return nil
+}
+
+// applyAccountProperty add account property (account.<name>.<property>)
+// to preferences struct
+func applyAccountProperty(prefs *Preferences, propName, propVal string) error {
+	propSlice := strings.Split(propName, ".")
+
+	if len(propSlice) != 3 || propSlice[1] == "" {
+		return fmt.Errorf("Unknown property %s", propName)
+	}
+
+	name := propSlice[1]
+
+	if prefs.Accounts == nil {
+		prefs.Accounts = make(map[string]*Account)
+	}
+
+	account := prefs.Accounts[name]
+
+	if account == nil {
+		account = &Account{Name: name}
+		prefs.Accounts[name] = account
+	}
+
+	switch strings.ToLower(propSlice[2]) {
+	case ACCOUNT_TOKEN:
+		account.Token = propVal
+	case ACCOUNT_KEY:
+		account.Key = propVal
+	case ACCOUNT_REGION:
+		account.Region = propVal
+	case ACCOUNT_TEMPLATES:
+		account.Templates = parseList(propVal)
+	default:
+		return fmt.Errorf("Unknown property %s", propName)
+	}
+
 	return nil
 }
 
+// parseList parse comma-separated list of values
+func parseList(data string) []string {
+	var result []string
+
+	for _, item := range strings.Split(data, ",") {
+		item = strings.TrimSpace(item)
+
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// parseBool parse boolean property value
+func parseBool(data string) bool {
+	switch strings.ToLower(data) {
+	case "true", "yes", "y", "1":
+		return true
+	}
+
+	return false
+}
+
 // getFingerprint return fingerprint for public key
 func getFingerprint(key string) (string, error) {
 	data, err := ioutil.ReadFile(key)
@@ -335,12 +790,15 @@ func getFingerprint(key string) (string, error) {
 func (p *Preferences) Validate(dataDir string, allowEmptyTemplate bool) []error {
 	var errs []error
 
-	if p.Token == "" {
-		errs = append(errs, fmt.Errorf("Property token must be set"))
-	}
+	// Local templates don't require token
+	if p.Template == "" || provider.TemplateProvider(dataDir+"/"+p.Template) != provider.LOCAL {
+		if p.Token == "" {
+			errs = append(errs, fmt.Errorf("Property token must be set"))
+		}
 
-	if len(p.Token) != 64 {
-		errs = append(errs, fmt.Errorf("Property token must is misformatted"))
+		if len(p.Token) != 64 {
+			errs = append(errs, fmt.Errorf("Property token must is misformatted"))
+		}
 	}
 
 	if p.Region == "" {
@@ -383,6 +841,33 @@ func (p *Preferences) Validate(dataDir string, allowEmptyTemplate bool) []error
 		}
 	}
 
+	if len(p.NotifyEmail) != 0 {
+		if p.NotifySMTP == "" {
+			errs = append(errs, fmt.Errorf("Property %s must be set for email notifications", NOTIFY_SMTP))
+		}
+
+		if p.NotifyEmailFrom == "" {
+			errs = append(errs, fmt.Errorf("Property %s must be set for email notifications", NOTIFY_EMAIL_FROM))
+		}
+	}
+
+	err := p.ValidateStateBackend()
+
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, file := range p.ExtraFiles {
+		fileSlice := strings.Split(file, ":")
+
+		switch {
+		case len(fileSlice) != 2 || fileSlice[0] == "" || !strings.HasPrefix(fileSlice[1], "/"):
+			errs = append(errs, fmt.Errorf("File %s must be defined as local:remote (remote path must be absolute)", file))
+		case !fsutil.CheckPerms("FR", fileSlice[0]):
+			errs = append(errs, fmt.Errorf("File %s does not exist or not readable", fileSlice[0]))
+		}
+	}
+
 	if p.Template == "" && !allowEmptyTemplate {
 		errs = append(errs, fmt.Errorf("You must define template name"))
 	} else {
@@ -408,6 +893,124 @@ func (p *Preferences) Validate(dataDir string, allowEmptyTemplate bool) []error
 	return errs
 }
 
+// SelectAccount choose account and apply its credentials to preferences.
+// Account defined by env variable or argument has the highest priority,
+// then account which declares current template and then default account
+// from file.
+func (p *Preferences) SelectAccount() error {
+	name := p.Account
+
+	if !p.accountDefined && p.TemplateAccount(p.Template) != "" {
+		name = p.TemplateAccount(p.Template)
+	}
+
+	return p.applyAccount(name)
+}
+
+// SelectFarmAccount apply credentials of account used for farm creation
+// (empty name means global credentials), account defined by env variable
+// or argument must be the same as farm account
+func (p *Preferences) SelectFarmAccount(account string) error {
+	if p.accountDefined && p.Account != account {
+		if account == "" {
+			return fmt.Errorf("Farm was created without account, but account %s is requested", p.Account)
+		}
+
+		return fmt.Errorf("Farm was created with account %s, but account %s is requested", account, p.Account)
+	}
+
+	return p.applyAccount(account)
+}
+
+// applyAccount apply credentials of account with given name, global
+// credentials are used if name is empty
+func (p *Preferences) applyAccount(name string) error {
+	if p.global == nil {
+		p.global = &Account{Token: p.Token, Key: p.Key, Region: p.Region}
+	}
+
+	// Restore credentials for the case of repeated account selection
+	p.Token, p.Key, p.Account = p.global.Token, p.global.Key, ""
+
+	if !p.regionDefined {
+		p.Region = p.global.Region
+	}
+
+	if name == "" {
+		return nil
+	}
+
+	info := p.Accounts[name]
+
+	if info == nil {
+		return fmt.Errorf("Account %s is not defined in preferences", name)
+	}
+
+	p.Account = name
+
+	if info.Token != "" {
+		p.Token = info.Token
+	}
+
+	if info.Key != "" {
+		p.Key = info.Key
+	}
+
+	if info.Region != "" && !p.regionDefined {
+		p.Region = info.Region
+	}
+
+	fingerprint, err := getFingerprint(p.Key + ".pub")
+
+	if err == nil {
+		p.Fingerprint = fingerprint
+	}
+
+	return nil
+}
+
+// TemplateAccount return name of account which declares given template
+func (p *Preferences) TemplateAccount(template string) string {
+	if template == "" {
+		return ""
+	}
+
+	for name, account := range p.Accounts {
+		for _, accountTemplate := range account.Templates {
+			if accountTemplate == template {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+// ValidateStateBackend validate state backend preferences
+func (p *Preferences) ValidateStateBackend() error {
+	switch p.StateBackend {
+	case BACKEND_LOCAL:
+		return nil
+
+	case BACKEND_S3:
+		if p.StateAccessKey == "" || p.StateSecretKey == "" {
+			return fmt.Errorf("Properties %s and %s must be set for S3 state backend", STATE_ACCESS_KEY, STATE_SECRET_KEY)
+		}
+
+	case BACKEND_HTTP:
+		// credentials are optional
+
+	default:
+		return fmt.Errorf("Unsupported state backend %s", p.StateBackend)
+	}
+
+	if !strings.HasPrefix(p.StateURL, "http://") && !strings.HasPrefix(p.StateURL, "https://") {
+		return fmt.Errorf("Property %s must be set to valid URL for %s state backend", STATE_URL, p.StateBackend)
+	}
+
+	return nil
+}
+
 // GetVariablesData return encoded preferencies
 func (p *Preferences) GetVariablesData() (string, error) {
 	var result string
@@ -439,6 +1042,10 @@ func (p *Preferences) GetVariablesData() (string, error) {
 		result += fmt.Sprintf("%s = \"%s\"\n", "node_size", p.NodeSize)
 	}
 
+	if p.ConnectionTimeout > 0 {
+		result += fmt.Sprintf("%s = \"%dm\"\n", "connection_timeout", p.ConnectionTimeout)
+	}
+
 	return result, nil
 }
 
@@ -0,0 +1,199 @@
+package provider
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/essentialkaos/terrafarm/do"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// DigitalOcean is DigitalOcean provider
+type DigitalOcean struct {
+	sizes     []*Size // Droplet sizes fetched from API
+	sizesLock sync.Mutex
+
+	regions     []*Region // Regions fetched from API
+	regionsLock sync.Mutex
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// doRegionAreas contains areas of regions, API provides only datacenter name,
+// so area is defined by region slug prefix
+var doRegionAreas = map[string]string{
+	"nyc": "US East",
+	"sfo": "US West",
+	"tor": "Canada",
+	"lon": "UK",
+	"ams": "Europe",
+	"fra": "Europe",
+	"blr": "Asia Pacific",
+	"sgp": "Asia Pacific",
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Name return name of provider
+func (p *DigitalOcean) Name() string {
+	return DIGITALOCEAN
+}
+
+// Title return human readable name of provider
+func (p *DigitalOcean) Title() string {
+	return "DigitalOcean"
+}
+
+// ResourceType return terraform resource type used for build nodes
+func (p *DigitalOcean) ResourceType() string {
+	return "digitalocean_droplet"
+}
+
+// IsValidToken check that token is valid and account is active
+func (p *DigitalOcean) IsValidToken(token string) StatusCode {
+	return StatusCode(do.IsValidToken(token))
+}
+
+// IsFingerprintValid check that SSH key with given fingerprint is
+// added to account
+func (p *DigitalOcean) IsFingerprintValid(token, fingerprint string) StatusCode {
+	return StatusCode(do.IsFingerprintValid(token, fingerprint))
+}
+
+// IsRegionValid check that region is available
+func (p *DigitalOcean) IsRegionValid(token, region string) StatusCode {
+	return StatusCode(do.IsRegionValid(token, region))
+}
+
+// IsSizeValid check that droplet size is available
+func (p *DigitalOcean) IsSizeValid(token, size string) StatusCode {
+	return StatusCode(do.IsSizeValid(token, size))
+}
+
+// AddSSHKey add public SSH key to account
+func (p *DigitalOcean) AddSSHKey(token, name, publicKey string) error {
+	return do.AddKey(token, name, publicKey)
+}
+
+// GetMachines return all droplets created by terrafarm
+func (p *DigitalOcean) GetMachines(token string) ([]*Machine, error) {
+	droplets, err := do.GetTerrafarmDroplets(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*Machine
+
+	for _, droplet := range droplets {
+		machine := &Machine{
+			ID:      droplet.ID,
+			Name:    droplet.Name,
+			Status:  droplet.Status,
+			Size:    droplet.SizeSlug,
+			Created: droplet.CreationDate(),
+			Tags:    droplet.Tags,
+		}
+
+		if droplet.Size != nil {
+			machine.Size = droplet.Size.Slug
+			machine.PriceHourly = droplet.Size.PriceHourly
+			machine.PriceMonthly = droplet.Size.PriceMonthly
+		}
+
+		result = append(result, machine)
+	}
+
+	return result, nil
+}
+
+// DestroyMachine destroy droplet with given ID
+func (p *DigitalOcean) DestroyMachine(token string, id int) error {
+	return do.DestroyDroplet(token, id)
+}
+
+// Sizes return info about available droplet sizes and their prices fetched
+// from API, info is fetched only once
+func (p *DigitalOcean) Sizes(token string) []*Size {
+	p.sizesLock.Lock()
+	defer p.sizesLock.Unlock()
+
+	if p.sizes != nil {
+		return p.sizes
+	}
+
+	sizes, err := do.GetSizes(token)
+
+	if err != nil {
+		return nil
+	}
+
+	for _, size := range sizes {
+		if !size.Available {
+			continue
+		}
+
+		p.sizes = append(p.sizes, &Size{
+			Name:    size.Slug,
+			Price:   size.PriceHourly,
+			CPU:     size.VCPUs,
+			Memory:  float64(size.Memory) / 1024.0,
+			Disk:    size.Disk,
+			Regions: append([]string{}, size.Regions...),
+		})
+	}
+
+	return p.sizes
+}
+
+// Regions return info about available regions fetched from API,
+// info is fetched only once
+func (p *DigitalOcean) Regions(token string) []*Region {
+	p.regionsLock.Lock()
+	defer p.regionsLock.Unlock()
+
+	if p.regions != nil {
+		return p.regions
+	}
+
+	regions, err := do.GetRegions(token)
+
+	if err != nil {
+		return nil
+	}
+
+	for _, region := range regions {
+		if !region.Available {
+			continue
+		}
+
+		p.regions = append(p.regions, &Region{
+			Name:       region.Slug,
+			DCName:     region.Name,
+			RegionName: getDORegionArea(region.Slug),
+		})
+	}
+
+	return p.regions
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// getDORegionArea return area of region with given slug
+func getDORegionArea(slug string) string {
+	for prefix, area := range doRegionAreas {
+		if strings.HasPrefix(slug, prefix) {
+			return area
+		}
+	}
+
+	return "Unknown"
+}
@@ -0,0 +1,265 @@
+package provider
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/essentialkaos/terrafarm/do"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+var testDOToken = strings.Repeat("a", 64)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func TestDigitalOceanGetMachines(t *testing.T) {
+	server := newTestDigitalOcean(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/droplets" || r.Method != "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Fprint(w, `{
+			"droplets": [
+				{
+					"id": 1, "name": "terrafarm-c7-x64", "status": "active",
+					"size": {"slug": "2gb", "price_hourly": 0.02976, "price_monthly": 20.0}
+				},
+				{"id": 2, "name": "web", "status": "active"},
+				{"id": 3, "name": "builder", "status": "off", "tags": ["terrafarm"], "size_slug": "1gb"}
+			]
+		}`)
+	})
+
+	defer server.Close()
+
+	p := &DigitalOcean{}
+
+	machines, err := p.GetMachines(testDOToken)
+
+	if err != nil {
+		t.Fatalf("GetMachines returned error: %v", err)
+	}
+
+	if len(machines) != 2 {
+		t.Fatalf("GetMachines must return 2 terrafarm machines, got %d", len(machines))
+	}
+
+	if machines[0].ID != 1 || machines[0].Size != "2gb" || machines[0].PriceHourly != 0.02976 {
+		t.Fatalf("GetMachines returned unexpected machine: %+v", machines[0])
+	}
+
+	if machines[1].ID != 3 || machines[1].Size != "1gb" || machines[1].PriceHourly != 0 {
+		t.Fatalf("GetMachines returned unexpected machine: %+v", machines[1])
+	}
+
+	_, err = p.GetMachines("test")
+
+	if err == nil {
+		t.Fatal("GetMachines with misformatted token must return error")
+	}
+}
+
+func TestDigitalOceanGetMachinesError(t *testing.T) {
+	server := newTestDigitalOcean(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"id": "server_error"}`)
+	})
+
+	defer server.Close()
+
+	p := &DigitalOcean{}
+
+	_, err := p.GetMachines(testDOToken)
+
+	if err == nil {
+		t.Fatal("GetMachines must return error for non-200 status")
+	}
+}
+
+func TestDigitalOceanIsFingerprintValid(t *testing.T) {
+	server := newTestDigitalOcean(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/account/keys" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Fprint(w, `{"ssh_keys": [{"fingerprint": "aa:bb"}]}`)
+	})
+
+	defer server.Close()
+
+	p := &DigitalOcean{}
+
+	if p.IsFingerprintValid(testDOToken, "aa:bb") != STATUS_OK {
+		t.Fatal("Fingerprint aa:bb must be valid")
+	}
+
+	if p.IsFingerprintValid(testDOToken, "cc:dd") != STATUS_NOT_OK {
+		t.Fatal("Fingerprint cc:dd must be invalid")
+	}
+
+	if p.IsFingerprintValid("test", "aa:bb") != STATUS_NOT_OK {
+		t.Fatal("Fingerprint check with misformatted token must fail")
+	}
+}
+
+func TestDigitalOceanDestroyMachine(t *testing.T) {
+	server := newTestDigitalOcean(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/droplets/1":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	defer server.Close()
+
+	p := &DigitalOcean{}
+
+	if err := p.DestroyMachine(testDOToken, 1); err != nil {
+		t.Fatalf("DestroyMachine with 204 status returned error: %v", err)
+	}
+
+	if err := p.DestroyMachine(testDOToken, 2); err == nil {
+		t.Fatal("DestroyMachine with 404 status must return error")
+	}
+}
+
+func TestDigitalOceanSizes(t *testing.T) {
+	var requests int
+
+	server := newTestDigitalOcean(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.URL.Path != "/sizes" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Fprint(w, `{
+			"sizes": [
+				{
+					"slug": "s-1vcpu-2gb", "memory": 2048, "vcpus": 1, "disk": 50,
+					"price_hourly": 0.01488, "regions": ["ams3", "fra1"], "available": true
+				},
+				{"slug": "512mb", "memory": 512, "vcpus": 1, "disk": 20, "available": false}
+			]
+		}`)
+	})
+
+	defer server.Close()
+
+	p := &DigitalOcean{}
+
+	if p.Sizes("test") != nil {
+		t.Fatal("Sizes with misformatted token must return nil")
+	}
+
+	sizes := p.Sizes(testDOToken)
+
+	if len(sizes) != 1 {
+		t.Fatalf("Sizes must return 1 available size, got %d", len(sizes))
+	}
+
+	size := sizes[0]
+
+	if size.Name != "s-1vcpu-2gb" || size.CPU != 1 || size.Memory != 2.0 || size.Disk != 50 || size.Price != 0.01488 {
+		t.Fatalf("Sizes returned unexpected size: %+v", size)
+	}
+
+	if len(size.Regions) != 2 || size.Regions[0] != "ams3" || size.Regions[1] != "fra1" {
+		t.Fatalf("Sizes returned unexpected regions: %v", size.Regions)
+	}
+
+	if IsSizeAvailable(p, testDOToken, "s-1vcpu-2gb", "nyc1") {
+		t.Fatal("Size s-1vcpu-2gb must not be available in nyc1")
+	}
+
+	p.Sizes(testDOToken)
+
+	if requests != 1 {
+		t.Fatalf("Sizes must be fetched only once, got %d requests", requests)
+	}
+}
+
+func TestDigitalOceanRegions(t *testing.T) {
+	var requests int
+
+	server := newTestDigitalOcean(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.URL.Path != "/regions" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Fprint(w, `{
+			"regions": [
+				{"slug": "nyc3", "name": "New York 3", "available": true},
+				{"slug": "xyz1", "name": "Somewhere", "available": true},
+				{"slug": "sfo1", "name": "San Francisco 1", "available": false}
+			]
+		}`)
+	})
+
+	defer server.Close()
+
+	p := &DigitalOcean{}
+
+	regions := p.Regions(testDOToken)
+
+	if len(regions) != 2 {
+		t.Fatalf("Regions must return 2 available regions, got %d", len(regions))
+	}
+
+	if regions[0].Name != "nyc3" || regions[0].DCName != "New York 3" || regions[0].RegionName != "US East" {
+		t.Fatalf("Regions returned unexpected region: %+v", regions[0])
+	}
+
+	if regions[1].RegionName != "Unknown" {
+		t.Fatalf("Regions returned unexpected area for unknown region: %s", regions[1].RegionName)
+	}
+
+	p.Regions(testDOToken)
+
+	if requests != 1 {
+		t.Fatalf("Regions must be fetched only once, got %d requests", requests)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// newTestDigitalOcean start fake DigitalOcean API server and redirect
+// API requests to it
+func newTestDigitalOcean(handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testDOToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}))
+
+	do.API = server.URL
+
+	return server
+}
@@ -0,0 +1,441 @@
+package provider
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"pkg.re/essentialkaos/ek.v9/req"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// HETZNER_API is Hetzner Cloud API url
+const HETZNER_API = "https://api.hetzner.cloud/v1"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Hetzner is Hetzner Cloud provider
+type Hetzner struct {
+	URL string // API URL, can be redefined for working with fake API
+
+	sizes     []*Size // Server types fetched from API
+	sizesLock sync.Mutex
+}
+
+// hetznerServersInfo contains info about servers
+type hetznerServersInfo struct {
+	Servers []*hetznerServer `json:"servers"`
+	Meta    *hetznerMeta     `json:"meta"`
+}
+
+// hetznerServer contains basic server info
+type hetznerServer struct {
+	ID         int                `json:"id"`
+	Name       string             `json:"name"`
+	Status     string             `json:"status"`
+	Created    string             `json:"created"`
+	ServerType *hetznerServerType `json:"server_type"`
+	Datacenter *hetznerDatacenter `json:"datacenter"`
+	Labels     map[string]string  `json:"labels"`
+}
+
+// hetznerServerType contains info about server type
+type hetznerServerType struct {
+	Name   string          `json:"name"`
+	Cores  int             `json:"cores"`
+	Memory float64         `json:"memory"`
+	Disk   int             `json:"disk"`
+	Prices []*hetznerPrice `json:"prices"`
+}
+
+// hetznerPrice contains server type price in location
+type hetznerPrice struct {
+	Location     string              `json:"location"`
+	PriceHourly  *hetznerPriceAmount `json:"price_hourly"`
+	PriceMonthly *hetznerPriceAmount `json:"price_monthly"`
+}
+
+// hetznerPriceAmount contains price amount
+type hetznerPriceAmount struct {
+	Gross string `json:"gross"`
+}
+
+// hetznerDatacenter contains info about datacenter
+type hetznerDatacenter struct {
+	Location *hetznerLocation `json:"location"`
+}
+
+// hetznerLocation contains info about location
+type hetznerLocation struct {
+	Name string `json:"name"`
+}
+
+// hetznerLocationsInfo contains info about locations
+type hetznerLocationsInfo struct {
+	Locations []*hetznerLocation `json:"locations"`
+}
+
+// hetznerServerTypesInfo contains info about server types
+type hetznerServerTypesInfo struct {
+	ServerTypes []*hetznerServerType `json:"server_types"`
+}
+
+// hetznerKeysInfo contains info about SSH keys
+type hetznerKeysInfo struct {
+	Keys []*hetznerKey `json:"ssh_keys"`
+}
+
+// hetznerKey contains key fingerprint
+type hetznerKey struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+// hetznerMeta contains response metadata
+type hetznerMeta struct {
+	Pagination *hetznerPagination `json:"pagination"`
+}
+
+// hetznerPagination contains info about pages
+type hetznerPagination struct {
+	NextPage int `json:"next_page"`
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// hetznerRegions contains info about locations
+var hetznerRegions = []*Region{
+	{"fsn1", "Falkenstein DC 1", "Germany"},
+	{"nbg1", "Nuremberg DC 3", "Germany"},
+	{"hel1", "Helsinki DC 1", "Finland"},
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Name return name of provider
+func (p *Hetzner) Name() string {
+	return HETZNER
+}
+
+// Title return human readable name of provider
+func (p *Hetzner) Title() string {
+	return "Hetzner Cloud"
+}
+
+// ResourceType return terraform resource type used for build nodes
+func (p *Hetzner) ResourceType() string {
+	return "hcloud_server"
+}
+
+// IsValidToken check that token is valid
+func (p *Hetzner) IsValidToken(token string) StatusCode {
+	if token == "" {
+		return STATUS_NOT_OK
+	}
+
+	resp, err := p.request(req.GET, token, "/servers", req.Query{"per_page": "1"}, nil)
+
+	if err != nil {
+		return STATUS_ERROR
+	}
+
+	resp.Discard()
+
+	if resp.StatusCode != 200 {
+		return STATUS_NOT_OK
+	}
+
+	return STATUS_OK
+}
+
+// IsFingerprintValid check that SSH key with given fingerprint is
+// added to project
+func (p *Hetzner) IsFingerprintValid(token, fingerprint string) StatusCode {
+	keysInfo := &hetznerKeysInfo{}
+
+	status := p.fetch(token, "/ssh_keys", req.Query{"fingerprint": fingerprint}, keysInfo)
+
+	if status != STATUS_OK {
+		return status
+	}
+
+	for _, key := range keysInfo.Keys {
+		if key.Fingerprint == fingerprint {
+			return STATUS_OK
+		}
+	}
+
+	return STATUS_NOT_OK
+}
+
+// IsRegionValid check that location is available
+func (p *Hetzner) IsRegionValid(token, region string) StatusCode {
+	locationsInfo := &hetznerLocationsInfo{}
+
+	status := p.fetch(token, "/locations", req.Query{"name": region}, locationsInfo)
+
+	if status != STATUS_OK {
+		return status
+	}
+
+	for _, location := range locationsInfo.Locations {
+		if location.Name == region {
+			return STATUS_OK
+		}
+	}
+
+	return STATUS_NOT_OK
+}
+
+// IsSizeValid check that server type is available
+func (p *Hetzner) IsSizeValid(token, size string) StatusCode {
+	typesInfo := &hetznerServerTypesInfo{}
+
+	status := p.fetch(token, "/server_types", req.Query{"name": size}, typesInfo)
+
+	if status != STATUS_OK {
+		return status
+	}
+
+	for _, serverType := range typesInfo.ServerTypes {
+		if serverType.Name == size {
+			return STATUS_OK
+		}
+	}
+
+	return STATUS_NOT_OK
+}
+
+// AddSSHKey add public SSH key to project
+func (p *Hetzner) AddSSHKey(token, name, publicKey string) error {
+	resp, err := p.request(
+		req.POST, token, "/ssh_keys", nil,
+		map[string]string{"name": name, "public_key": publicKey},
+	)
+
+	if err != nil {
+		return fmt.Errorf("Can't send request to Hetzner Cloud API: %v", err)
+	}
+
+	resp.Discard()
+
+	if resp.StatusCode != 201 {
+		return fmt.Errorf("Hetzner Cloud return status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// GetMachines return all servers created by terrafarm
+func (p *Hetzner) GetMachines(token string) ([]*Machine, error) {
+	var result []*Machine
+
+	for page := 1; page != 0; {
+		resp, err := p.request(
+			req.GET, token, "/servers",
+			req.Query{"page": strconv.Itoa(page), "per_page": "50"}, nil,
+		)
+
+		if err != nil {
+			return nil, fmt.Errorf("Can't fetch servers list from Hetzner Cloud API: %v", err)
+		}
+
+		if resp.StatusCode != 200 {
+			resp.Discard()
+			return nil, fmt.Errorf("Hetzner Cloud return status code %d", resp.StatusCode)
+		}
+
+		serversInfo := &hetznerServersInfo{}
+
+		err = resp.JSON(serversInfo)
+
+		if err != nil {
+			return nil, fmt.Errorf("Can't decode Hetzner Cloud API response: %v", err)
+		}
+
+		for _, server := range serversInfo.Servers {
+			machine := server.toMachine()
+
+			if machine.IsTerrafarmMachine() {
+				result = append(result, machine)
+			}
+		}
+
+		page = 0
+
+		if serversInfo.Meta != nil && serversInfo.Meta.Pagination != nil {
+			page = serversInfo.Meta.Pagination.NextPage
+		}
+	}
+
+	return result, nil
+}
+
+// DestroyMachine destroy server with given ID
+func (p *Hetzner) DestroyMachine(token string, id int) error {
+	resp, err := p.request(req.DELETE, token, "/servers/"+strconv.Itoa(id), nil, nil)
+
+	if err != nil {
+		return fmt.Errorf("Can't send request to Hetzner Cloud API: %v", err)
+	}
+
+	resp.Discard()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return fmt.Errorf("Hetzner Cloud return status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sizes return info about server types and their prices fetched from API,
+// info is fetched only once
+func (p *Hetzner) Sizes(token string) []*Size {
+	p.sizesLock.Lock()
+	defer p.sizesLock.Unlock()
+
+	if p.sizes != nil {
+		return p.sizes
+	}
+
+	typesInfo := &hetznerServerTypesInfo{}
+
+	status := p.fetch(token, "/server_types", req.Query{"per_page": "50"}, typesInfo)
+
+	if status != STATUS_OK {
+		return nil
+	}
+
+	for _, serverType := range typesInfo.ServerTypes {
+		p.sizes = append(p.sizes, serverType.toSize())
+	}
+
+	return p.sizes
+}
+
+// Regions return info about supported locations
+func (p *Hetzner) Regions(token string) []*Region {
+	return hetznerRegions
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// fetch send GET request to API and decode response
+func (p *Hetzner) fetch(token, method string, query req.Query, v interface{}) StatusCode {
+	if token == "" {
+		return STATUS_NOT_OK
+	}
+
+	resp, err := p.request(req.GET, token, method, query, nil)
+
+	if err != nil {
+		return STATUS_ERROR
+	}
+
+	if resp.StatusCode != 200 {
+		resp.Discard()
+		return STATUS_NOT_OK
+	}
+
+	if resp.JSON(v) != nil {
+		return STATUS_ERROR
+	}
+
+	return STATUS_OK
+}
+
+// request send request to API
+func (p *Hetzner) request(httpMethod, token, method string, query req.Query, body interface{}) (*req.Response, error) {
+	url := p.URL
+
+	if url == "" {
+		url = HETZNER_API
+	}
+
+	r := req.Request{
+		Method:      httpMethod,
+		URL:         url + method,
+		Query:       query,
+		ContentType: req.CONTENT_TYPE_JSON,
+		Headers:     req.Headers{"Authorization": "Bearer " + token},
+	}
+
+	if body != nil {
+		r.Body = body
+	}
+
+	return r.Do()
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// toSize convert server type info to size info, hourly price in first
+// location is used as size price
+func (t *hetznerServerType) toSize() *Size {
+	size := &Size{
+		Name:    t.Name,
+		CPU:     t.Cores,
+		Memory:  t.Memory,
+		Disk:    t.Disk,
+		Regions: []string{},
+	}
+
+	for _, price := range t.Prices {
+		size.Regions = append(size.Regions, price.Location)
+
+		if size.Price == 0 && price.PriceHourly != nil {
+			size.Price, _ = strconv.ParseFloat(price.PriceHourly.Gross, 64)
+		}
+	}
+
+	return size
+}
+
+// toMachine convert server info to machine info
+func (s *hetznerServer) toMachine() *Machine {
+	machine := &Machine{
+		ID:      s.ID,
+		Name:    s.Name,
+		Status:  s.Status,
+		Created: parseDate(s.Created),
+	}
+
+	for label := range s.Labels {
+		machine.Tags = append(machine.Tags, label)
+	}
+
+	if s.ServerType == nil {
+		return machine
+	}
+
+	machine.Size = s.ServerType.Name
+
+	var location string
+
+	if s.Datacenter != nil && s.Datacenter.Location != nil {
+		location = s.Datacenter.Location.Name
+	}
+
+	for _, price := range s.ServerType.Prices {
+		if price.Location != location {
+			continue
+		}
+
+		if price.PriceHourly != nil {
+			machine.PriceHourly, _ = strconv.ParseFloat(price.PriceHourly.Gross, 64)
+		}
+
+		if price.PriceMonthly != nil {
+			machine.PriceMonthly, _ = strconv.ParseFloat(price.PriceMonthly.Gross, 64)
+		}
+	}
+
+	return machine
+}
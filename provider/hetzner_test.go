@@ -0,0 +1,226 @@
+package provider
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+const testHetznerToken = "test1234"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+func TestHetznerGetMachines(t *testing.T) {
+	p, server := newTestHetzner(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/servers" || r.Method != "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch r.URL.Query().Get("page") {
+		case "1":
+			fmt.Fprint(w, `{
+				"servers": [
+					{"id": 1, "name": "terrafarm-c7-x64", "status": "running"},
+					{"id": 2, "name": "web", "status": "running"}
+				],
+				"meta": {"pagination": {"next_page": 2}}
+			}`)
+		case "2":
+			fmt.Fprint(w, `{
+				"servers": [
+					{
+						"id": 3, "name": "builder", "status": "off",
+						"labels": {"terrafarm": "true"},
+						"server_type": {
+							"name": "cx11",
+							"prices": [
+								{"location": "fsn1", "price_hourly": {"gross": "0.0060"}, "price_monthly": {"gross": "2.9631"}},
+								{"location": "hel1", "price_hourly": {"gross": "0.0070"}, "price_monthly": {"gross": "3.5000"}}
+							]
+						},
+						"datacenter": {"location": {"name": "hel1"}}
+					}
+				],
+				"meta": {"pagination": {"next_page": null}}
+			}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	defer server.Close()
+
+	machines, err := p.GetMachines(testHetznerToken)
+
+	if err != nil {
+		t.Fatalf("GetMachines returned error: %v", err)
+	}
+
+	if len(machines) != 2 {
+		t.Fatalf("GetMachines must return 2 terrafarm machines, got %d", len(machines))
+	}
+
+	if machines[0].ID != 1 || machines[1].ID != 3 {
+		t.Fatalf("GetMachines returned unexpected machines: %d, %d", machines[0].ID, machines[1].ID)
+	}
+
+	if machines[1].Size != "cx11" || machines[1].PriceHourly != 0.007 || machines[1].PriceMonthly != 3.5 {
+		t.Fatalf("Price must be taken from server location, got %s %g %g",
+			machines[1].Size, machines[1].PriceHourly, machines[1].PriceMonthly)
+	}
+}
+
+func TestHetznerGetMachinesError(t *testing.T) {
+	p, server := newTestHetzner(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	defer server.Close()
+
+	_, err := p.GetMachines(testHetznerToken)
+
+	if err == nil {
+		t.Fatal("GetMachines must return error for non-200 status")
+	}
+}
+
+func TestHetznerIsFingerprintValid(t *testing.T) {
+	p, server := newTestHetzner(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ssh_keys" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("fingerprint") == "aa:bb" {
+			fmt.Fprint(w, `{"ssh_keys": [{"fingerprint": "aa:bb"}]}`)
+		} else {
+			fmt.Fprint(w, `{"ssh_keys": []}`)
+		}
+	})
+
+	defer server.Close()
+
+	if p.IsFingerprintValid(testHetznerToken, "aa:bb") != STATUS_OK {
+		t.Fatal("Fingerprint aa:bb must be valid")
+	}
+
+	if p.IsFingerprintValid(testHetznerToken, "cc:dd") != STATUS_NOT_OK {
+		t.Fatal("Fingerprint cc:dd must be invalid")
+	}
+
+	if p.IsFingerprintValid("", "aa:bb") != STATUS_NOT_OK {
+		t.Fatal("Fingerprint check with empty token must fail")
+	}
+}
+
+func TestHetznerDestroyMachine(t *testing.T) {
+	p, server := newTestHetzner(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/servers/1":
+			fmt.Fprint(w, `{"action": {"id": 10, "status": "running"}}`)
+		case "/servers/2":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	defer server.Close()
+
+	if err := p.DestroyMachine(testHetznerToken, 1); err != nil {
+		t.Fatalf("DestroyMachine with 200 status returned error: %v", err)
+	}
+
+	if err := p.DestroyMachine(testHetznerToken, 2); err != nil {
+		t.Fatalf("DestroyMachine with 204 status returned error: %v", err)
+	}
+
+	if err := p.DestroyMachine(testHetznerToken, 3); err == nil {
+		t.Fatal("DestroyMachine with 404 status must return error")
+	}
+}
+
+func TestHetznerSizes(t *testing.T) {
+	var requests int
+
+	p, server := newTestHetzner(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.URL.Path != "/server_types" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Fprint(w, `{
+			"server_types": [
+				{
+					"name": "cx11", "cores": 1, "memory": 2.0, "disk": 20,
+					"prices": [
+						{"location": "fsn1", "price_hourly": {"gross": "0.0060"}},
+						{"location": "nbg1", "price_hourly": {"gross": "0.0060"}}
+					]
+				}
+			]
+		}`)
+	})
+
+	defer server.Close()
+
+	if p.Sizes("") != nil {
+		t.Fatal("Sizes without token must return nil")
+	}
+
+	sizes := p.Sizes(testHetznerToken)
+
+	if len(sizes) != 1 {
+		t.Fatalf("Sizes must return 1 size, got %d", len(sizes))
+	}
+
+	size := sizes[0]
+
+	if size.Name != "cx11" || size.CPU != 1 || size.Memory != 2.0 || size.Disk != 20 || size.Price != 0.006 {
+		t.Fatalf("Sizes returned unexpected size: %+v", size)
+	}
+
+	if len(size.Regions) != 2 || size.Regions[0] != "fsn1" || size.Regions[1] != "nbg1" {
+		t.Fatalf("Sizes returned unexpected regions: %v", size.Regions)
+	}
+
+	p.Sizes(testHetznerToken)
+
+	if requests != 1 {
+		t.Fatalf("Sizes must be fetched only once, got %d requests", requests)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// newTestHetzner create Hetzner provider connected to fake API server
+func newTestHetzner(handler http.HandlerFunc) (*Hetzner, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testHetznerToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}))
+
+	return &Hetzner{URL: server.URL}, server
+}
@@ -0,0 +1,94 @@
+package provider
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"fmt"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Local is provider for build nodes created as local containers (docker
+// or podman with docker-compatible API) through terraform docker provider
+type Local struct{}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// localRegions contains info about regions
+var localRegions = []*Region{
+	{"local", "Local machine", "Local"},
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Name return name of provider
+func (p *Local) Name() string {
+	return LOCAL
+}
+
+// Title return human readable name of provider
+func (p *Local) Title() string {
+	return "Local containers"
+}
+
+// ResourceType return terraform resource type used for build nodes
+func (p *Local) ResourceType() string {
+	return "docker_container"
+}
+
+// IsValidToken always return STATUS_OK because local provider
+// doesn't use token
+func (p *Local) IsValidToken(token string) StatusCode {
+	return STATUS_OK
+}
+
+// IsFingerprintValid always return STATUS_OK because public key
+// is uploaded to containers on creation
+func (p *Local) IsFingerprintValid(token, fingerprint string) StatusCode {
+	return STATUS_OK
+}
+
+// IsRegionValid always return STATUS_OK because region is ignored
+// by local templates
+func (p *Local) IsRegionValid(token, region string) StatusCode {
+	return STATUS_OK
+}
+
+// IsSizeValid always return STATUS_OK because containers use all
+// available resources of local machine
+func (p *Local) IsSizeValid(token, size string) StatusCode {
+	return STATUS_OK
+}
+
+// AddSSHKey do nothing because public key is uploaded to containers
+// on creation
+func (p *Local) AddSSHKey(token, name, publicKey string) error {
+	return nil
+}
+
+// GetMachines return empty list because containers are managed only
+// through terraform state
+func (p *Local) GetMachines(token string) ([]*Machine, error) {
+	return nil, nil
+}
+
+// DestroyMachine return error because containers can be destroyed only
+// through terraform
+func (p *Local) DestroyMachine(token string, id int) error {
+	return fmt.Errorf("Local containers can be destroyed only with destroy command")
+}
+
+// Sizes return nil because containers don't have fixed sizes
+func (p *Local) Sizes(token string) []*Size {
+	return nil
+}
+
+// Regions return info about supported regions
+func (p *Local) Regions(token string) []*Region {
+	return localRegions
+}
@@ -0,0 +1,243 @@
+// Package provider provides common interface for cloud providers
+package provider
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// List of check statuses
+const (
+	STATUS_OK     StatusCode = 0
+	STATUS_NOT_OK            = 1
+	STATUS_ERROR             = 2
+)
+
+// List of supported providers (names are the same as terraform provider names)
+const (
+	DIGITALOCEAN = "digitalocean"
+	HETZNER      = "hcloud"
+	LOCAL        = "local"
+)
+
+// CONFIG_FILE is name of template file with provider configuration
+const CONFIG_FILE = "provider.tf"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// StatusCode is status of check
+type StatusCode uint8
+
+// Provider is cloud provider
+type Provider interface {
+	// Name return name of provider
+	Name() string
+
+	// Title return human readable name of provider
+	Title() string
+
+	// ResourceType return terraform resource type used for build nodes
+	ResourceType() string
+
+	// IsValidToken check that token is valid and account is active
+	IsValidToken(token string) StatusCode
+
+	// IsFingerprintValid check that SSH key with given fingerprint is
+	// added to account
+	IsFingerprintValid(token, fingerprint string) StatusCode
+
+	// IsRegionValid check that region is available
+	IsRegionValid(token, region string) StatusCode
+
+	// IsSizeValid check that machine size is available
+	IsSizeValid(token, size string) StatusCode
+
+	// AddSSHKey add public SSH key to account
+	AddSSHKey(token, name, publicKey string) error
+
+	// GetMachines return all machines created by terrafarm
+	GetMachines(token string) ([]*Machine, error)
+
+	// DestroyMachine destroy machine with given ID
+	DestroyMachine(token string, id int) error
+
+	// Sizes return info about supported machine sizes, token is used by
+	// providers which fetch sizes info from API
+	Sizes(token string) []*Size
+
+	// Regions return info about supported regions, token is used by
+	// providers which fetch regions info from API
+	Regions(token string) []*Region
+}
+
+// Size contains info about machine size
+type Size struct {
+	Name    string
+	Price   float64 // Hourly price
+	CPU     int
+	Memory  float64
+	Disk    int
+	Regions []string // Regions where size is available, nil if size available everywhere
+}
+
+// Region contains info about region
+type Region struct {
+	Name       string
+	DCName     string
+	RegionName string
+}
+
+// Machine contains basic info about machine (droplet, server)
+type Machine struct {
+	ID           int
+	Name         string
+	Status       string
+	Size         string
+	Created      time.Time
+	PriceHourly  float64
+	PriceMonthly float64
+	Tags         []string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// providers contains all supported providers
+var providers = map[string]Provider{
+	DIGITALOCEAN: &DigitalOcean{},
+	HETZNER:      &Hetzner{},
+	LOCAL:        &Local{},
+}
+
+// aliases contains names of terraform providers used by providers
+// with other names
+var aliases = map[string]string{
+	"docker": LOCAL,
+}
+
+// configRegex is regexp for extracting provider name from configuration
+var configRegex = regexp.MustCompile(`provider\s+"([a-z0-9_-]+)"`)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Get return provider with given name or nil if provider is not supported
+func Get(name string) Provider {
+	if aliases[name] != "" {
+		return providers[aliases[name]]
+	}
+
+	return providers[name]
+}
+
+// IsNodeResource return true if terraform resource with given type is
+// build node of some provider
+func IsNodeResource(resourceType string) bool {
+	for _, p := range providers {
+		if p.ResourceType() == resourceType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TemplateProvider return name of provider declared in configuration
+// of template in given directory, templates without provider declaration
+// use DigitalOcean
+func TemplateProvider(templateDir string) string {
+	data, err := ioutil.ReadFile(templateDir + "/" + CONFIG_FILE)
+
+	if err != nil {
+		return DIGITALOCEAN
+	}
+
+	match := configRegex.FindStringSubmatch(string(data))
+
+	if len(match) != 2 {
+		return DIGITALOCEAN
+	}
+
+	if aliases[match[1]] != "" {
+		return aliases[match[1]]
+	}
+
+	return match[1]
+}
+
+// GetSize return info about size with given name or nil if size is
+// not supported by provider
+func GetSize(p Provider, token, name string) *Size {
+	for _, size := range p.Sizes(token) {
+		if size.Name == name {
+			return size
+		}
+	}
+
+	return nil
+}
+
+// IsSizeAvailable return true if size is available in given region, all
+// sizes are available for providers without sizes info
+func IsSizeAvailable(p Provider, token, name, region string) bool {
+	if len(p.Sizes(token)) == 0 {
+		return true
+	}
+
+	size := GetSize(p, token, name)
+
+	if size == nil {
+		return false
+	}
+
+	if size.Regions == nil {
+		return true
+	}
+
+	for _, sizeRegion := range size.Regions {
+		if sizeRegion == region {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// IsTerrafarmMachine return true if machine have terrafarm prefix or tag
+func (m *Machine) IsTerrafarmMachine() bool {
+	if strings.HasPrefix(strings.ToLower(m.Name), "terrafarm") {
+		return true
+	}
+
+	for _, tag := range m.Tags {
+		if strings.HasPrefix(strings.ToLower(tag), "terrafarm") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// parseDate parse RFC3339 date
+func parseDate(date string) time.Time {
+	result, err := time.Parse(time.RFC3339, date)
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	return result
+}
@@ -0,0 +1,356 @@
+// Package provision provides methods for working with build node provisioning spec
+package provision
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+//                                                                                    //
+//                     Copyright (c) 2009-2017 ESSENTIAL KAOS                         //
+//        Essential Kaos Open Source License <https://essentialkaos.com/ekol>         //
+//                                                                                    //
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// SPEC_FILE is name of file with provisioning spec in template directory
+const SPEC_FILE = "provision.yml"
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Spec contains build node provisioning spec
+type Spec struct {
+	Update    bool              `yaml:"update"`
+	Repos     []*Repo           `yaml:"repos"`
+	Packages  []string          `yaml:"packages"`
+	Files     []*File           `yaml:"files"`
+	RPMMacros map[string]string `yaml:"rpmmacros"`
+	Users     []*User           `yaml:"users"`
+	Nodes     map[string]*Spec  `yaml:"nodes"`
+}
+
+// Repo contains info about yum repository
+type Repo struct {
+	Package string `yaml:"package"` // URL of repository package
+	File    string `yaml:"file"`    // URL of .repo file
+	Key     string `yaml:"key"`     // URL of GPG key
+}
+
+// File contains info about file which must be copied to build node
+type File struct {
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+	Owner       string `yaml:"owner"`
+	Mode        string `yaml:"mode"`
+}
+
+// User contains info about build node user
+type User struct {
+	Name     string `yaml:"name"`
+	Password bool   `yaml:"password"`
+}
+
+// Step is provisioning step
+type Step struct {
+	Name     string
+	Commands []string
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Read read and parse provisioning spec
+func Read(file string) (*Spec, error) {
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{}
+
+	err = yaml.UnmarshalStrict(data, spec)
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse %s: %v", file, err)
+	}
+
+	err = spec.Validate()
+
+	if err != nil {
+		return nil, fmt.Errorf("Spec %s is not valid: %v", file, err)
+	}
+
+	return spec, nil
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Validate validate spec
+func (s *Spec) Validate() error {
+	for _, spec := range append([]*Spec{s}, s.getNodeSpecs()...) {
+		for _, repo := range spec.Repos {
+			if repo.Package == "" && repo.File == "" {
+				return fmt.Errorf("Repository must have package or file URL")
+			}
+		}
+
+		for _, file := range spec.Files {
+			if file.Source == "" || file.Destination == "" {
+				return fmt.Errorf("File must have source and destination")
+			}
+
+			if !filepath.IsAbs(file.Destination) {
+				return fmt.Errorf("File destination %s must be absolute path", file.Destination)
+			}
+		}
+
+		for _, user := range spec.Users {
+			if user.Name == "" {
+				return fmt.Errorf("User must have name")
+			}
+		}
+	}
+
+	return nil
+}
+
+// ForNode return spec for node with given name (common spec merged with
+// node specific spec)
+func (s *Spec) ForNode(node string) *Spec {
+	result := &Spec{
+		Update:    s.Update,
+		Repos:     append([]*Repo{}, s.Repos...),
+		Packages:  append([]string{}, s.Packages...),
+		Files:     append([]*File{}, s.Files...),
+		RPMMacros: make(map[string]string),
+		Users:     append([]*User{}, s.Users...),
+	}
+
+	for name, value := range s.RPMMacros {
+		result.RPMMacros[name] = value
+	}
+
+	nodeSpec := s.Nodes[node]
+
+	if nodeSpec == nil {
+		return result
+	}
+
+	result.Update = result.Update || nodeSpec.Update
+	result.Repos = append(result.Repos, nodeSpec.Repos...)
+	result.Packages = append(result.Packages, nodeSpec.Packages...)
+	result.Files = append(result.Files, nodeSpec.Files...)
+	result.Users = append(result.Users, nodeSpec.Users...)
+
+	for name, value := range nodeSpec.RPMMacros {
+		result.RPMMacros[name] = value
+	}
+
+	return result
+}
+
+// Steps return provisioning steps which must be executed before copying
+// files, auth is encrypted password for users
+func (s *Spec) Steps(auth string) []*Step {
+	var steps []*Step
+
+	steps = append(steps, &Step{
+		Name:     "Cleaning yum cache",
+		Commands: []string{"yum -y -q clean expire-cache"},
+	})
+
+	if s.Update {
+		steps = append(steps, &Step{
+			Name:     "Updating system packages",
+			Commands: []string{"yum -y -q update"},
+		})
+	}
+
+	for _, repo := range s.Repos {
+		steps = append(steps, repo.step())
+	}
+
+	if len(s.Repos) != 0 && s.Update {
+		steps = append(steps, &Step{
+			Name:     "Updating packages",
+			Commands: []string{"yum -y -q update"},
+		})
+	}
+
+	if len(s.Packages) != 0 {
+		steps = append(steps, &Step{
+			Name:     "Installing packages",
+			Commands: []string{"yum -y -q install " + strings.Join(s.Packages, " ")},
+		})
+	}
+
+	if len(s.Users) != 0 {
+		steps = append(steps, &Step{
+			Name:     "Configuring users",
+			Commands: s.usersCommands(auth),
+		})
+	}
+
+	if len(s.RPMMacros) != 0 && len(s.Users) != 0 {
+		steps = append(steps, &Step{
+			Name:     "Configuring RPM macros",
+			Commands: s.rpmMacrosCommands(),
+		})
+	}
+
+	return steps
+}
+
+// PostSteps return provisioning steps which must be executed after
+// copying files
+func (s *Spec) PostSteps() []*Step {
+	var commands []string
+
+	for _, file := range s.Files {
+		if file.Owner != "" {
+			commands = append(commands, fmt.Sprintf("chown %s %s", file.Owner, file.Destination))
+		}
+
+		if file.Mode != "" {
+			commands = append(commands, fmt.Sprintf("chmod %s %s", file.Mode, file.Destination))
+		}
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	return []*Step{{Name: "Fixing files permissions", Commands: commands}}
+}
+
+// PasswordSteps return steps which only update users passwords (used for
+// nodes created from provisioned images)
+func (s *Spec) PasswordSteps(auth string) []*Step {
+	var commands []string
+
+	for _, user := range s.Users {
+		if user.Password {
+			commands = append(commands, getPasswordCommand(user.Name, auth))
+		}
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	return []*Step{{Name: "Updating users passwords", Commands: commands}}
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// Script convert steps to list of shell commands with progress reporting
+func Script(steps []*Step) []string {
+	result := []string{"export PATH=$PATH:/usr/bin"}
+
+	for index, step := range steps {
+		result = append(result, fmt.Sprintf(
+			"echo '[%d/%d] %s...'", index+1, len(steps), step.Name,
+		))
+
+		result = append(result, step.Commands...)
+	}
+
+	return result
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// step return step for repository installation
+func (r *Repo) step() *Step {
+	step := &Step{}
+
+	if r.Key != "" {
+		step.Commands = append(step.Commands, "rpm --import "+r.Key)
+	}
+
+	if r.Package != "" {
+		step.Name = "Installing repository package " + filepath.Base(r.Package)
+		step.Commands = append(step.Commands, "yum -y -q install "+r.Package)
+	} else {
+		step.Name = "Installing repository " + filepath.Base(r.File)
+		step.Commands = append(step.Commands, fmt.Sprintf(
+			"curl -sS -o /etc/yum.repos.d/%s %s",
+			filepath.Base(r.File), r.File,
+		))
+	}
+
+	return step
+}
+
+// usersCommands return commands for users configuration
+func (s *Spec) usersCommands(auth string) []string {
+	var result []string
+
+	for _, user := range s.Users {
+		result = append(result, fmt.Sprintf("id %s &>/dev/null || useradd -m %s", user.Name, user.Name))
+
+		if user.Password {
+			result = append(result, getPasswordCommand(user.Name, auth))
+		}
+	}
+
+	return result
+}
+
+// rpmMacrosCommands return commands for writing RPM macros for all users
+func (s *Spec) rpmMacrosCommands() []string {
+	var macros []string
+
+	for name := range s.RPMMacros {
+		macros = append(macros, name)
+	}
+
+	// Sort macros for stable output
+	sort.Strings(macros)
+
+	var result []string
+
+	for _, user := range s.Users {
+		file := fmt.Sprintf("/home/%s/.rpmmacros", user.Name)
+
+		result = append(result, fmt.Sprintf("echo '## TERRAFARM DEFAULT MACRO' > %s", file))
+
+		for _, name := range macros {
+			result = append(result, fmt.Sprintf(
+				"echo '%%%s %s' >> %s",
+				strings.TrimPrefix(name, "%"),
+				strings.Replace(s.RPMMacros[name], "'", "'\\''", -1),
+				file,
+			))
+		}
+
+		result = append(result, fmt.Sprintf("chown %s:%s %s", user.Name, user.Name, file))
+	}
+
+	return result
+}
+
+// getNodeSpecs return slice with all node specific specs
+func (s *Spec) getNodeSpecs() []*Spec {
+	var result []*Spec
+
+	for _, spec := range s.Nodes {
+		if spec != nil {
+			result = append(result, spec)
+		}
+	}
+
+	return result
+}
+
+// getPasswordCommand return command for updating user password
+func getPasswordCommand(user, auth string) string {
+	return fmt.Sprintf("sed -i 's#^%s:[^:]*:#%s:%s:#' /etc/shadow", user, user, auth)
+}
@@ -21,3 +21,7 @@ variable region {
 variable node_size {
   default = ""
 }
+
+variable connection_timeout {
+  default = "2m"
+}
@@ -0,0 +1,20 @@
+resource "hcloud_server" "builder-x64" {
+  image = "centos-7"
+  name = "terrafarm-c7-x64"
+  location = "${var.region}"
+  server_type = "${var.node_size}"
+  ssh_keys = [
+    "${data.hcloud_ssh_key.terrafarm.id}"
+  ]
+
+  labels {
+    terrafarm = "true"
+  }
+
+  connection {
+    user = "root"
+    type = "ssh"
+    private_key = "${file(var.key)}"
+    timeout = "${var.connection_timeout}"
+  }
+}
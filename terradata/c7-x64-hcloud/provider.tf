@@ -0,0 +1,8 @@
+
+provider "hcloud" {
+  token = "${var.token}"
+}
+
+data "hcloud_ssh_key" "terrafarm" {
+  fingerprint = "${var.fingerprint}"
+}
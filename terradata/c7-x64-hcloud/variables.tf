@@ -0,0 +1,27 @@
+variable key {
+  default = ""
+}
+
+variable fingerprint {
+  default = ""
+}
+
+variable auth {
+  default = ""
+}
+
+variable token {
+  default = ""
+}
+
+variable region {
+  default = ""
+}
+
+variable node_size {
+  default = ""
+}
+
+variable connection_timeout {
+  default = "2m"
+}
@@ -0,0 +1,30 @@
+resource "docker_container" "builder-x64" {
+  image = "centos:7"
+  name = "terrafarm-c7-x64"
+  hostname = "terrafarm-c7-x64"
+  must_run = true
+
+  command = [
+    "/bin/bash", "-c",
+    "yum -y -q install openssh-server && ssh-keygen -A && exec /usr/sbin/sshd -D -e"
+  ]
+
+  # SSH port is mapped to free local port chosen by docker
+  ports {
+    internal = 22
+  }
+
+  upload {
+    content = "${file("${var.key}.pub")}"
+    file = "/root/.ssh/authorized_keys"
+  }
+
+  connection {
+    host = "127.0.0.1"
+    port = "${self.ports.0.external}"
+    user = "root"
+    type = "ssh"
+    private_key = "${file(var.key)}"
+    timeout = "${var.connection_timeout}"
+  }
+}
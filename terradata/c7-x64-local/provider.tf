@@ -0,0 +1,5 @@
+
+provider "docker" {
+  # Docker daemon or podman API socket is taken from DOCKER_HOST
+  # environment variable (unix:///var/run/docker.sock by default)
+}
@@ -8,6 +8,10 @@ package terraform
 // ////////////////////////////////////////////////////////////////////////////////// //
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+
 	"pkg.re/essentialkaos/ek.v9/jsonutil"
 )
 
@@ -33,14 +37,17 @@ type TFResource struct {
 type TFResourceInfo struct {
 	ID         string                `json:"id"`
 	Attributes *TFResourceAttributes `json:"attributes"`
+	Tainted    bool                  `json:"tainted"`
 }
 
 // TFResourceAttributes contains terraform resource attributes
 type TFResourceAttributes struct {
-	ID     string `json:"id"`
-	IP     string `json:"ipv4_address"`
-	Name   string `json:"name"`
-	Status string `json:"status"`
+	ID          string `json:"id"`
+	IP          string `json:"ipv4_address"`
+	ContainerIP string `json:"ip_address"`       // Used by local containers
+	SSHPort     string `json:"ports.0.external"` // Used by local containers
+	Name        string `json:"name"`
+	Status      string `json:"status"`
 }
 
 // ////////////////////////////////////////////////////////////////////////////////// //
@@ -57,3 +64,143 @@ func ReadState(file string) (*TFState, error) {
 
 	return state, nil
 }
+
+// RemoveProvisioners remove all provisioners with given type from
+// resource configuration
+func RemoveProvisioners(data, provisionerType string) string {
+	header := fmt.Sprintf("provisioner \"%s\"", provisionerType)
+
+	for {
+		start := strings.Index(data, header)
+
+		if start == -1 {
+			return data
+		}
+
+		end := findBlockEnd(data, start)
+
+		if end == -1 {
+			return data
+		}
+
+		// Remove provisioner with leading indentation and trailing newline
+		start = strings.LastIndex(data[:start], "\n") + 1
+
+		if end < len(data) && data[end] == '\n' {
+			end++
+		}
+
+		data = data[:start] + data[end:]
+	}
+}
+
+// SetAttribute set value of attribute with given name
+func SetAttribute(data, name, value string) string {
+	re := regexp.MustCompile(`(?m)^(\s*` + regexp.QuoteMeta(name) + `\s*=\s*)".*"`)
+	return re.ReplaceAllString(data, `${1}"`+value+`"`)
+}
+
+// GetAttribute return value of attribute with given name
+func GetAttribute(data, name string) string {
+	re := regexp.MustCompile(`(?m)^\s*` + regexp.QuoteMeta(name) + `\s*=\s*"(.*)"`)
+	match := re.FindStringSubmatch(data)
+
+	if len(match) != 2 {
+		return ""
+	}
+
+	return match[1]
+}
+
+// SetResourceName set name of the first resource in configuration
+func SetResourceName(data, name string) string {
+	re := regexp.MustCompile(`(resource\s+"[^"]+"\s+)"[^"]+"`)
+	loc := re.FindStringSubmatchIndex(data)
+
+	if loc == nil {
+		return data
+	}
+
+	return data[:loc[3]] + `"` + name + `"` + data[loc[1]:]
+}
+
+// AddBlock add block to the end of resource configuration
+func AddBlock(data, block string) string {
+	end := strings.LastIndex(data, "}")
+
+	if end == -1 {
+		return data
+	}
+
+	return data[:end] + block + data[end:]
+}
+
+// GetResourceName return name of the first resource in configuration
+func GetResourceName(data string) string {
+	re := regexp.MustCompile(`resource\s+"[^"]+"\s+"([^"]+)"`)
+	match := re.FindStringSubmatch(data)
+
+	if len(match) != 2 {
+		return ""
+	}
+
+	return match[1]
+}
+
+// RenderRemoteExec render remote-exec provisioner with given commands
+func RenderRemoteExec(commands []string) string {
+	var lines []string
+
+	for _, command := range commands {
+		command = strings.Replace(command, "\\", "\\\\", -1)
+		command = strings.Replace(command, "\"", "\\\"", -1)
+		lines = append(lines, "      \""+command+"\"")
+	}
+
+	return fmt.Sprintf(
+		"\n  provisioner \"remote-exec\" {\n    inline = [\n%s\n    ]\n  }\n",
+		strings.Join(lines, ",\n"),
+	)
+}
+
+// RenderFile render file provisioner
+func RenderFile(source, destination string) string {
+	return fmt.Sprintf(
+		"\n  provisioner \"file\" {\n    source = \"%s\"\n    destination = \"%s\"\n  }\n",
+		source, destination,
+	)
+}
+
+// ////////////////////////////////////////////////////////////////////////////////// //
+
+// findBlockEnd return index of the character after closing brace of
+// block which starts at given index
+func findBlockEnd(data string, start int) int {
+	var depth int
+	var inString bool
+
+	for i := start; i < len(data); i++ {
+		switch data[i] {
+		case '"':
+			if i == 0 || data[i-1] != '\\' {
+				inString = !inString
+			}
+		case '{':
+			if !inString {
+				depth++
+			}
+		case '}':
+			if inString {
+				continue
+			}
+
+			depth--
+
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return -1
+}